@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AdmissionRules decide who may log in once their identity is verified.
+// Deny rules win over allow rules. If any allow rule is set, users must match
+// at least one of them, otherwise everyone not denied is admitted.
+type AdmissionRules struct {
+	AllowedUsers        []string
+	DeniedUsers         []string
+	AllowedGroups       []string
+	DeniedGroups        []string
+	AllowedEmailDomains []string
+	DeniedEmailDomains  []string
+}
+
+// accessDeniedError is returned by login methods for users the admission
+// rules reject.
+type accessDeniedError struct {
+	reason string
+}
+
+func (e *accessDeniedError) Error() string {
+	return "access denied: " + e.reason
+}
+
+// admit returns an *accessDeniedError if ls may not log in. A nil
+// *AdmissionRules admits everyone.
+func (r *AdmissionRules) admit(ls *loginState) error {
+	if r == nil {
+		return nil
+	}
+	username := ls.username()
+	domain := emailDomain(ls.Email)
+
+	if contains(r.DeniedUsers, username) {
+		return &accessDeniedError{fmt.Sprintf("user %q is denied", username)}
+	}
+	for _, g := range ls.Groups {
+		if contains(r.DeniedGroups, g) {
+			return &accessDeniedError{fmt.Sprintf("group %q is denied", g)}
+		}
+	}
+	if domain != "" && containsFold(r.DeniedEmailDomains, domain) {
+		return &accessDeniedError{fmt.Sprintf("email domain %q is denied", domain)}
+	}
+
+	if len(r.AllowedUsers) == 0 && len(r.AllowedGroups) == 0 && len(r.AllowedEmailDomains) == 0 {
+		return nil
+	}
+	if contains(r.AllowedUsers, username) {
+		return nil
+	}
+	for _, g := range ls.Groups {
+		if contains(r.AllowedGroups, g) {
+			return nil
+		}
+	}
+	if domain != "" && containsFold(r.AllowedEmailDomains, domain) {
+		return nil
+	}
+	return &accessDeniedError{fmt.Sprintf("user %q matches no allow rule", username)}
+}
+
+// emailDomain returns the domain of email, or an empty string if it has none.
+func emailDomain(email string) string {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return ""
+	}
+	return email[i+1:]
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestAdmissionRules(t *testing.T) {
+	alice := &loginState{Username: "alice", Email: "alice@Example.com", Groups: []string{"devs", "ops"}}
+	tests := []struct {
+		rules *AdmissionRules
+		want  bool
+	}{
+		{nil, true},
+		{&AdmissionRules{}, true},
+		{&AdmissionRules{AllowedUsers: []string{"alice"}}, true},
+		{&AdmissionRules{AllowedUsers: []string{"bob"}}, false},
+		{&AdmissionRules{AllowedGroups: []string{"ops"}}, true},
+		{&AdmissionRules{AllowedGroups: []string{"admins"}}, false},
+		{&AdmissionRules{AllowedEmailDomains: []string{"example.com"}}, true},
+		{&AdmissionRules{AllowedEmailDomains: []string{"mail.example.com"}}, false},
+		// Any allow rule is enough.
+		{&AdmissionRules{AllowedUsers: []string{"bob"}, AllowedGroups: []string{"devs"}}, true},
+		// Deny rules win.
+		{&AdmissionRules{AllowedUsers: []string{"alice"}, DeniedGroups: []string{"ops"}}, false},
+		{&AdmissionRules{AllowedGroups: []string{"devs"}, DeniedUsers: []string{"alice"}}, false},
+		{&AdmissionRules{AllowedUsers: []string{"alice"}, DeniedEmailDomains: []string{"EXAMPLE.COM"}}, false},
+		{&AdmissionRules{DeniedUsers: []string{"bob"}}, true},
+	}
+	for i, tt := range tests {
+		err := tt.rules.admit(alice)
+		if got := err == nil; got != tt.want {
+			t.Errorf("case %d: admitted = %v, want %v (err: %v)", i, got, tt.want, err)
+		}
+		if _, ok := err.(*accessDeniedError); err != nil && !ok {
+			t.Errorf("case %d: error %v is not an *accessDeniedError", i, err)
+		}
+	}
+
+	// Users without a username, such as OpenShift users, are matched by name.
+	bob := &loginState{Name: "bob"}
+	if err := (&AdmissionRules{AllowedUsers: []string{"bob"}}).admit(bob); err != nil {
+		t.Errorf("user matched by name rejected: %v", err)
+	}
+}
+
+func TestLoginAdmission(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	defer p.Close()
+
+	c := testOIDCConfig(p)
+	c.Admission = &AdmissionRules{DeniedEmailDomains: []string{"example.com"}}
+	a := newTestAuthenticator(t, c)
+	q, cookie := startLogin(t, a)
+	p.codes["good-code"] = testAuthCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	w, ok := finishLogin(a, "good-code", q.Get("state"), cookie)
+	if ok {
+		t.Fatal("denied user logged in")
+	}
+	loc, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loc.Query().Get("error"); got != errorAccessDenied {
+		t.Errorf("error = %q, want %q", got, errorAccessDenied)
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == openshiftSessionCookieName && c.Value != "" {
+			t.Error("denied user got a session cookie")
+		}
+	}
+	if n := len(a.sessions.listSessions()); n != 0 {
+		t.Errorf("%d sessions stored for a denied user", n)
+	}
+
+	c = testOIDCConfig(p)
+	c.Admission = &AdmissionRules{AllowedUsers: []string{"user-id"}}
+	a = newTestAuthenticator(t, c)
+	if _, err := a.Authenticate(loginSession(t, a, p)); err != nil {
+		t.Errorf("allowed user not authenticated: %v", err)
+	}
+}
@@ -2,16 +2,15 @@ package auth
 
 import (
 	"context"
-	"crypto/rand"
 	"crypto/subtle"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -38,6 +37,11 @@ const (
 	errorMissingState = "missing_state"
 	errorInvalidCode  = "invalid_code"
 	errorInvalidState = "invalid_state"
+
+	errorInvalidScopeProfile = "invalid_scope_profile"
+	errorX509                = "client_certificate_error"
+	errorRequestHeader       = "request_header_error"
+	errorAccessDenied        = "access_denied"
 )
 
 var (
@@ -52,20 +56,62 @@ var (
 )
 
 type Authenticator struct {
-	authFunc func() (*oauth2.Config, loginMethod)
-
-	clientFunc func() *http.Client
+	// providers are the identity providers users can log in with. The
+	// first one is used unless the user picks another.
+	providers []*provider
 
 	// userFunc returns the User associated with the cookie from a request.
 	// This is not part of loginMethod to avoid creating an unnecessary
 	// HTTP client for every call.
 	userFunc func(*http.Request) (*User, error)
 
+	// impersonator is set when the console authenticates users itself, by
+	// client certificate or front proxy headers, rather than them logging in
+	// with a provider.
+	impersonator *impersonatingAuth
+	// requestHeader is set with the request-header auth source, so its front
+	// proxy CA can be reloaded.
+	requestHeader *requestHeaderAuth
+
+	// sessions is the store of server-side sessions, or nil if the auth
+	// source doesn't keep any.
+	sessions SessionStore
+	inflight inflightRequests
+
 	errorURL      string
 	successURL    string
 	cookiePath    string
 	refererURL    *url.URL
 	secureCookies bool
+
+	// scopeProfiles are the restricted scope sets users can log in with.
+	scopeProfiles map[string][]string
+
+	// pkce and nonce enable PKCE and ID token nonces in the login flow.
+	pkce                bool
+	nonce               bool
+	stateCookieSameSite http.SameSite
+
+	// idleTimeout is how long a session may be unused before it ends, or
+	// zero if sessions never idle out. activityInterval is how often a
+	// session's activity is recorded.
+	idleTimeout      time.Duration
+	activityInterval time.Duration
+
+	// started is closed once every provider was reached. Each provider can
+	// be used as soon as it was reached itself, see provider.ready.
+	started chan struct{}
+
+	// source is the configured auth source, and events the log of
+	// authentication events, if any.
+	source AuthSource
+	events *EventLog
+
+	// csrfKeys sign CSRF tokens and seal impersonation cookies.
+	csrfKeys *KeySet
+
+	// groupImpersonationUser is sent with impersonations of groups only.
+	groupImpersonationUser string
 }
 
 // loginMethod is used to handle OAuth2 responses and associate bearer tokens
@@ -76,7 +122,8 @@ type Authenticator struct {
 type loginMethod interface {
 	// login turns on oauth2 token response into a user session and associates a
 	// cookie with the user.
-	login(http.ResponseWriter, *oauth2.Token) (*loginState, error)
+	// The loginFlow holds the state of the login the token belongs to.
+	login(http.ResponseWriter, *oauth2.Token, *loginFlow) (*loginState, error)
 	// logout deletes any cookies associated with the user.
 	logout(http.ResponseWriter, *http.Request)
 	// getKubeAdminLogoutURL returns the logout URL for the special
@@ -90,8 +137,27 @@ type AuthSource int
 const (
 	AuthSourceTectonic  AuthSource = 0
 	AuthSourceOpenShift AuthSource = 1
+	// AuthSourceX509 authenticates users by TLS client certificate.
+	AuthSourceX509 AuthSource = 2
+	// AuthSourceRequestHeader trusts the user set in request headers by an
+	// authenticating front proxy.
+	AuthSourceRequestHeader AuthSource = 3
 )
 
+// String returns the name of s as used by the --user-auth flag.
+func (s AuthSource) String() string {
+	switch s {
+	case AuthSourceOpenShift:
+		return "openshift"
+	case AuthSourceX509:
+		return "x509"
+	case AuthSourceRequestHeader:
+		return "request-header"
+	default:
+		return "oidc"
+	}
+}
+
 type Config struct {
 	AuthSource AuthSource
 
@@ -112,6 +178,102 @@ type Config struct {
 	// cookiePath is an abstraction leak. (unfortunately, a necessary one.)
 	CookiePath    string
 	SecureCookies bool
+
+	// SessionStore holds OIDC login sessions. Defaults to an in-memory store.
+	SessionStore SessionStore
+	// CookieKeys, if set, encrypts and authenticates the OpenShift session
+	// cookie. The newest key encrypts, any key decrypts.
+	CookieKeys *KeySet
+
+	// DisablePKCE turns off PKCE for providers that reject the
+	// code_challenge parameter.
+	DisablePKCE bool
+	// DisableNonce turns off the OIDC nonce for providers that don't return
+	// it in the ID token. Nonces are never sent to OpenShift.
+	DisableNonce bool
+	// StateCookieSameSite is the SameSite attribute of the state cookie set
+	// during login. Defaults to http.SameSiteLaxMode. Providers that POST
+	// back to the callback need http.SameSiteNoneMode.
+	StateCookieSameSite http.SameSite
+
+	// ScopeProfiles are named sets of scopes users can request instead of
+	// Scope when logging in, for example a view-only set for auditors. Only
+	// supported with AuthSourceOpenShift.
+	ScopeProfiles map[string][]string
+
+	// ClaimMapping names the ID token claims holding the user's details.
+	// Only used with OIDC.
+	ClaimMapping ClaimMapping
+
+	// Admission, if set, decides which users may log in with OpenShift or
+	// OIDC. Users rejected by it are sent to ErrorURL with access_denied.
+	Admission *AdmissionRules
+
+	// Providers, if set, are the OIDC providers users can choose from. The
+	// single provider configured by IssuerURL, ClientID and the related
+	// fields above is ignored.
+	Providers []ProviderConfig
+
+	// KubectlClientID and KubectlClientSecret are kubectl's OAuth2 client at
+	// the provider configured by IssuerURL. If set, users can get
+	// credentials of kubectl's own, see KubectlLogin.
+	KubectlClientID     string
+	KubectlClientSecret string
+	// KubectlRedirectURL is where the provider sends users back to after
+	// KubectlLogin. It must be registered for kubectl's client.
+	KubectlRedirectURL string
+
+	// ImpersonatorToken is the token the console uses to talk to the API
+	// server on behalf of client certificate and front proxy users,
+	// impersonating them. It must be allowed to impersonate users and groups.
+	// Only used with AuthSourceX509 and AuthSourceRequestHeader.
+	ImpersonatorToken string
+
+	// GroupImpersonationUser is the user sent with impersonations of groups
+	// only, see StartImpersonation. The API server requires a user whenever
+	// groups are impersonated. It shouldn't be a real user or have roles of
+	// its own, so requests only get the permissions of the groups. Users
+	// impersonating groups must be allowed to impersonate this user too.
+	// Defaults to DefaultGroupImpersonationUser.
+	GroupImpersonationUser string
+
+	// RequestHeaderClientCA is the CA file the front proxy's client
+	// certificate must be signed by. Only used with AuthSourceRequestHeader.
+	RequestHeaderClientCA string
+	// RequestHeaderAllowedNames are the common names the front proxy's
+	// certificate may have. Any name is allowed if empty.
+	RequestHeaderAllowedNames []string
+	// RequestHeaderUsernameHeaders are the headers checked in order for the
+	// username, for example X-Remote-User.
+	RequestHeaderUsernameHeaders []string
+	// RequestHeaderGroupHeaders are the headers holding the user's groups,
+	// for example X-Remote-Group.
+	RequestHeaderGroupHeaders []string
+
+	// PostLogoutRedirectURL is where OIDC providers supporting RP-initiated
+	// logout send users after logging them out. It must be registered with
+	// the provider.
+	PostLogoutRedirectURL string
+
+	// MaxSessionAge is how long an OIDC session may be silently renewed with
+	// a refresh token before the user has to log in again. Zero disables
+	// refreshing, so sessions end when the ID token expires.
+	MaxSessionAge time.Duration
+
+	// IdleTimeout, if set, ends sessions whose user hasn't been active for
+	// that long, however long their token is valid. With OpenShift it
+	// requires CookieKeys to sign the last activity cookie.
+	IdleTimeout time.Duration
+
+	// EventLog, if set, receives structured logins, logouts and rejected
+	// requests for auditing.
+	EventLog *EventLog
+
+	// CSRFKeys sign CSRF tokens and seal impersonation cookies. Defaults to
+	// CookieKeys, or keys derived from those of a FileSessionStore, which
+	// every replica shares. Otherwise a random key is used, and tokens only
+	// work with the console instance that issued them.
+	CSRFKeys *KeySet
 }
 
 func newHTTPClient(issuerCA string, includeSystemRoots bool) (*http.Client, error) {
@@ -163,115 +325,196 @@ func newHTTPClient(issuerCA string, includeSystemRoots bool) (*http.Client, erro
 }
 
 // NewAuthenticator initializes an Authenticator struct. It blocks until the authenticator is
-// able to contact the provider.
+// able to contact the provider, or ctx is done.
 func NewAuthenticator(ctx context.Context, c *Config) (*Authenticator, error) {
-	// Retry connecting to the identity provider every 10s for 5 minutes
-	const (
-		backoff  = time.Second * 10
-		maxSteps = 30
-	)
-	steps := 0
-
-	for {
-		a, err := newUnstartedAuthenticator(c)
+	a, err := StartAuthenticator(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-a.started:
+		return a, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// StartAuthenticator initializes an Authenticator without waiting for the
+// providers. They're contacted in the background, retrying with exponential
+// backoff until they're reachable, and until then logins are answered with
+// 503 Service Unavailable. Only configuration errors are returned.
+func StartAuthenticator(ctx context.Context, c *Config) (*Authenticator, error) {
+	a, err := newUnstartedAuthenticator(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c.ScopeProfiles) > 0 && c.AuthSource != AuthSourceOpenShift {
+		return nil, fmt.Errorf("scope profiles are only supported with OpenShift")
+	}
+
+	switch c.AuthSource {
+	case AuthSourceX509, AuthSourceRequestHeader:
+		if len(c.Providers) > 0 {
+			return nil, fmt.Errorf("multiple providers are only supported with OIDC")
+		}
+		if c.ImpersonatorToken == "" {
+			return nil, fmt.Errorf("authenticating users by the console requires a token to impersonate them with")
+		}
+		a.impersonator = &impersonatingAuth{
+			source:    "x509",
+			identify:  x509Identity,
+			errorCode: errorX509,
+			token:     c.ImpersonatorToken,
+			loginURL:  c.RedirectURL,
+		}
+		if c.AuthSource == AuthSourceRequestHeader {
+			h, err := newRequestHeaderAuth(c)
+			if err != nil {
+				return nil, err
+			}
+			a.requestHeader = h
+			a.impersonator.source = "request-header"
+			a.impersonator.identify = h.identify
+			a.impersonator.errorCode = errorRequestHeader
+		}
+		a.userFunc = a.impersonator.authenticate
+	case AuthSourceOpenShift:
+		if len(c.Providers) > 0 {
+			return nil, fmt.Errorf("multiple providers are only supported with OIDC")
+		}
+		if c.IdleTimeout > 0 && c.CookieKeys == nil {
+			return nil, fmt.Errorf("an idle timeout with OpenShift requires cookie keys")
+		}
+		clientFunc, err := newClientFunc(c.IssuerCA)
 		if err != nil {
 			return nil, err
 		}
 
-		var authSourceFunc func() (oauth2.Endpoint, loginMethod, error)
-		switch c.AuthSource {
-		case AuthSourceOpenShift:
-			a.userFunc = getOpenShiftUser
-			authSourceFunc = func() (oauth2.Endpoint, loginMethod, error) {
-				// Use the k8s CA for OAuth metadata discovery.
-				// Don't include system roots when talking to the API server.
-				k8sClient, errK8Client := newHTTPClient(c.K8sCA, false)
-				if errK8Client != nil {
-					return oauth2.Endpoint{}, nil, errK8Client
-				}
-
-				return newOpenShiftAuth(ctx, &openShiftConfig{
-					k8sClient:     k8sClient,
-					oauthClient:   a.clientFunc(),
-					issuerURL:     c.IssuerURL,
-					cookiePath:    c.CookiePath,
-					secureCookies: c.SecureCookies,
-				})
+		// Don't include system roots when talking to the API server.
+		users := newOpenShiftUserResolver(c.IssuerURL, func() (*http.Client, error) {
+			return newHTTPClient(c.K8sCA, false)
+		})
+		a.userFunc = func(r *http.Request) (*User, error) {
+			return getOpenShiftUser(r, c.CookieKeys, users)
+		}
+		p := newProvider(defaultProviderConfig(c), clientFunc, func() (oauth2.Endpoint, loginMethod, error) {
+			// Use the k8s CA for OAuth metadata discovery.
+			// Don't include system roots when talking to the API server.
+			k8sClient, errK8Client := newHTTPClient(c.K8sCA, false)
+			if errK8Client != nil {
+				return oauth2.Endpoint{}, nil, errK8Client
 			}
-		default:
-			// OIDC auth source is stateful, so only create it once.
-			endpoint, oidcAuthSource, err := newOIDCAuth(ctx, &oidcConfig{
-				client:        a.clientFunc(),
+
+			return newOpenShiftAuth(ctx, &openShiftConfig{
+				k8sClient:     k8sClient,
+				oauthClient:   clientFunc(),
 				issuerURL:     c.IssuerURL,
-				clientID:      c.ClientID,
 				cookiePath:    c.CookiePath,
 				secureCookies: c.SecureCookies,
+				cookieKeys:    c.CookieKeys,
+				users:         users,
+				admission:     c.Admission,
+				idleTimeout:   c.IdleTimeout,
 			})
-			a.userFunc = func(r *http.Request) (*User, error) {
-				if oidcAuthSource == nil {
-					return nil, fmt.Errorf("OIDC auth source is not intialized")
-				}
-				return oidcAuthSource.authenticate(r)
-			}
-			authSourceFunc = func() (oauth2.Endpoint, loginMethod, error) {
-				return endpoint, oidcAuthSource, err
-			}
+		})
+		a.providers = []*provider{p}
+	default:
+		configs := c.Providers
+		if len(configs) == 0 {
+			configs = []ProviderConfig{defaultProviderConfig(c)}
+		} else if err := validateProviderConfigs(configs); err != nil {
+			return nil, err
 		}
 
-		fallbackEndpoint, fallbackLoginMethod, err := authSourceFunc()
-		if err != nil {
-			steps++
-			if steps > maxSteps {
-				log.Errorf("error contacting auth provider: %v", err)
-				return nil, err
-			}
-
-			log.Errorf("error contacting auth provider (retrying in %s): %v", backoff, err)
-
-			time.Sleep(backoff)
-			continue
+		// Every provider shares the session store, so a session cookie is
+		// valid no matter which provider issued it.
+		sessions := c.SessionStore
+		if sessions == nil {
+			sessions = NewMemorySessionStore(DefaultMaxSessions, DefaultMaxSessionsPerUser)
 		}
+		a.sessions = sessions
 
-		a.authFunc = func() (*oauth2.Config, loginMethod) {
-			// rebuild non-pointer struct each time to prevent any mutation
-			baseOAuth2Config := oauth2.Config{
-				ClientID:     c.ClientID,
-				ClientSecret: c.ClientSecret,
-				RedirectURL:  c.RedirectURL,
-				Scopes:       c.Scope,
-				Endpoint:     fallbackEndpoint,
-			}
-
-			currentEndpoint, currentLoginMethod, errAuthSource := authSourceFunc()
-			if errAuthSource != nil {
-				log.Errorf("failed to get latest auth source data: %v", errAuthSource)
-				return &baseOAuth2Config, fallbackLoginMethod
+		oidcSources := make(oidcProviders)
+		for _, pc := range configs {
+			pc := pc
+			clientFunc, err := newClientFunc(pc.IssuerCA)
+			if err != nil {
+				return nil, err
 			}
 
-			baseOAuth2Config.Endpoint = currentEndpoint
-			return &baseOAuth2Config, currentLoginMethod
+			var (
+				endpoint       oauth2.Endpoint
+				oidcAuthSource *oidcAuth
+			)
+			p := newProvider(pc, clientFunc, func() (oauth2.Endpoint, loginMethod, error) {
+				// OIDC auth source is stateful, so only create it once.
+				if oidcAuthSource != nil {
+					return endpoint, oidcAuthSource, nil
+				}
+				var err error
+				endpoint, oidcAuthSource, err = newOIDCAuth(ctx, &oidcConfig{
+					client:        clientFunc(),
+					provider:      pc.Name,
+					issuerURL:     pc.IssuerURL,
+					clientID:      pc.ClientID,
+					clientSecret:  pc.ClientSecret,
+					scope:         pc.Scope,
+					cookiePath:    c.CookiePath,
+					secureCookies: c.SecureCookies,
+					sessions:      sessions,
+					maxSessionAge: c.MaxSessionAge,
+					claims:        pc.ClaimMapping,
+					admission:     c.Admission,
+
+					postLogoutRedirectURL: c.PostLogoutRedirectURL,
+					kubectlClientID:       pc.KubectlClientID,
+					kubectlClientSecret:   pc.KubectlClientSecret,
+					kubectlRedirectURL:    c.KubectlRedirectURL,
+				})
+				if err != nil {
+					// Don't return a typed nil loginMethod.
+					return endpoint, nil, err
+				}
+				return endpoint, oidcAuthSource, nil
+			})
+			a.providers = append(a.providers, p)
+			oidcSources[pc.Name] = p
+		}
+		a.userFunc = func(r *http.Request) (*User, error) {
+			return oidcSources.authenticate(r, sessions)
+		}
+		if a.idleTimeout > 0 {
+			go a.expireIdleSessions(ctx)
 		}
-
-		return a, nil
 	}
-}
 
-func newUnstartedAuthenticator(c *Config) (*Authenticator, error) {
-	// make sure we get a valid starting client
-	fallbackClient, err := newHTTPClient(c.IssuerCA, true)
-	if err != nil {
-		return nil, err
+	if len(a.providers) == 0 {
+		close(a.started)
+	} else {
+		go a.start(ctx)
 	}
+	return a, nil
+}
 
-	clientFunc := func() *http.Client {
-		currentClient, err := newHTTPClient(c.IssuerCA, true)
-		if err != nil {
-			log.Errorf("failed to get latest http client: %v", err)
-			return fallbackClient
-		}
-		return currentClient
+// defaultProviderConfig returns the config of the single, unnamed provider
+// configured by the top-level fields of c.
+func defaultProviderConfig(c *Config) ProviderConfig {
+	return ProviderConfig{
+		IssuerURL:    c.IssuerURL,
+		IssuerCA:     c.IssuerCA,
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		Scope:        c.Scope,
+		RedirectURL:  c.RedirectURL,
+		ClaimMapping: c.ClaimMapping,
+
+		KubectlClientID:     c.KubectlClientID,
+		KubectlClientSecret: c.KubectlClientSecret,
 	}
+}
 
+func newUnstartedAuthenticator(c *Config) (*Authenticator, error) {
 	errURL := "/"
 	if c.ErrorURL != "" {
 		errURL = c.ErrorURL
@@ -291,13 +534,45 @@ func newUnstartedAuthenticator(c *Config) (*Authenticator, error) {
 		return nil, err
 	}
 
+	stateCookieSameSite := c.StateCookieSameSite
+	if stateCookieSameSite == 0 {
+		stateCookieSameSite = http.SameSiteLaxMode
+	}
+
+	csrfKeys := c.CSRFKeys
+	if csrfKeys == nil {
+		csrfKeys = c.CookieKeys
+	}
+	if fs, ok := c.SessionStore.(*FileSessionStore); ok && csrfKeys == nil {
+		csrfKeys = fs.keys.derive("csrf")
+	}
+	if csrfKeys == nil {
+		csrfKeys = newRandomKeySet()
+	}
+
+	groupImpersonationUser := c.GroupImpersonationUser
+	if groupImpersonationUser == "" {
+		groupImpersonationUser = DefaultGroupImpersonationUser
+	}
+
 	return &Authenticator{
-		clientFunc:    clientFunc,
-		errorURL:      errURL,
-		successURL:    sucURL,
-		cookiePath:    c.CookiePath,
-		refererURL:    refUrl,
-		secureCookies: c.SecureCookies,
+		errorURL:            errURL,
+		successURL:          sucURL,
+		cookiePath:          c.CookiePath,
+		refererURL:          refUrl,
+		secureCookies:       c.SecureCookies,
+		scopeProfiles:       c.ScopeProfiles,
+		pkce:                !c.DisablePKCE,
+		nonce:               !c.DisableNonce && c.AuthSource != AuthSourceOpenShift,
+		stateCookieSameSite: stateCookieSameSite,
+		idleTimeout:         c.IdleTimeout,
+		activityInterval:    activityInterval(c.IdleTimeout),
+		started:             make(chan struct{}),
+		source:              c.AuthSource,
+		events:              c.EventLog,
+		csrfKeys:            csrfKeys,
+
+		groupImpersonationUser: groupImpersonationUser,
 	}, nil
 }
 
@@ -305,38 +580,181 @@ func newUnstartedAuthenticator(c *Config) (*Authenticator, error) {
 type User struct {
 	ID       string
 	Username string
+	Groups   []string
 	Token    string
+	// Impersonate is set when Token is the console's own token rather than
+	// the user's, so requests for the user must impersonate Username and
+	// Groups. See SetAuthHeaders.
+	Impersonate bool
+	// ScopeProfile is the restricted scope profile the user logged in with,
+	// or empty if they have the default scopes.
+	ScopeProfile string
+	// SessionID identifies the server-side session the user authenticated
+	// with. It is empty for auth sources without server-side sessions.
+	SessionID string
+	// Impersonating is who the user started impersonating, if anyone. See
+	// StartImpersonation.
+	Impersonating *Impersonation
+	// groupImpersonationUser is sent if Impersonating only impersonates
+	// groups, see Config.GroupImpersonationUser.
+	groupImpersonationUser string
+}
+
+// SetAuthHeaders sets the headers that authenticate a request to the API
+// server as u. Impersonation headers and websocket subprotocols sent by the
+// browser are removed: only the impersonation the user started with
+// StartImpersonation is sent. For users the console impersonates, the API
+// server would even honor them with the console's token.
+func (u *User) SetAuthHeaders(h http.Header) {
+	h.Set("Authorization", fmt.Sprintf("Bearer %s", u.Token))
+
+	for key := range h {
+		if strings.HasPrefix(key, "Impersonate-") {
+			h.Del(key)
+		}
+	}
+	if protocols := h["Sec-Websocket-Protocol"]; len(protocols) > 0 {
+		var kept []string
+		for _, value := range protocols {
+			for _, protocol := range strings.Split(value, ",") {
+				protocol = strings.TrimSpace(protocol)
+				if !strings.HasPrefix(protocol, "Impersonate-") {
+					kept = append(kept, protocol)
+				}
+			}
+		}
+		h.Del("Sec-Websocket-Protocol")
+		if len(kept) > 0 {
+			h.Set("Sec-Websocket-Protocol", strings.Join(kept, ", "))
+		}
+	}
+
+	switch {
+	case u.Impersonate:
+		(&Impersonation{User: u.Username, Groups: u.Groups}).setHeaders(h, u.groupImpersonationUser)
+	case u.Impersonating != nil:
+		u.Impersonating.setHeaders(h, u.groupImpersonationUser)
+	}
 }
 
 func (a *Authenticator) Authenticate(r *http.Request) (*User, error) {
-	return a.userFunc(r)
+	// OIDC sessions only wait for the provider they were issued by, see
+	// oidcProviders.authenticate.
+	if a.sessions == nil && !a.Ready() {
+		return nil, errStarting
+	}
+	user, err := a.userFunc(r)
+	if err != nil {
+		return nil, err
+	}
+	user.groupImpersonationUser = a.groupImpersonationUser
+	return user, nil
 }
 
-// LoginFunc redirects to the OIDC provider for user login.
+// LoginFunc redirects to the OIDC provider for user login. The optional
+// `then` query parameter is a path on the console to return to afterwards,
+// and `scopeProfile` names a restricted set of scopes to request.
 func (a *Authenticator) LoginFunc(w http.ResponseWriter, r *http.Request) {
-	var randData [4]byte
-	if _, err := io.ReadFull(rand.Reader, randData[:]); err != nil {
-		panic(err)
+	if a.impersonator != nil {
+		a.impersonatingLogin(w, r)
+		return
+	}
+	if !a.providers[0].isReady() {
+		serviceUnavailable(w)
+		return
 	}
-	state := hex.EncodeToString(randData[:])
+	a.login(w, r, a.providers[0])
+}
 
-	cookie := http.Cookie{
-		Name:     stateCookieName,
-		Value:    state,
-		HttpOnly: true,
-		Secure:   a.secureCookies,
+func (a *Authenticator) login(w http.ResponseWriter, r *http.Request, p *provider) {
+	flow := newLoginFlow(a.pkce, a.nonce)
+	flow.Provider = p.name
+	if then := r.URL.Query().Get("then"); then != "" {
+		if _, err := a.returnURL(then); err != nil {
+			log.Infof("ignoring invalid return path %q: %v", then, err)
+		} else {
+			// Kept with the state, so it can't be swapped during the login.
+			flow.Then = then
+		}
 	}
-	http.SetCookie(w, &cookie)
-	http.Redirect(w, r, a.getOAuth2Config().AuthCodeURL(state), http.StatusSeeOther)
+	oauthConfig, _ := p.authFunc()
+	if profile := r.URL.Query().Get("scopeProfile"); profile != "" {
+		scopes, ok := a.scopeProfiles[profile]
+		if !ok {
+			log.Infof("unknown scope profile %q", profile)
+			a.loginFailed(w, r, p, errorInvalidScopeProfile, fmt.Errorf("unknown scope profile %q", profile))
+			return
+		}
+		flow.ScopeProfile = profile
+		oauthConfig.Scopes = scopes
+	}
+	if err := a.setLoginFlowCookie(w, flow, p.stateCookiePath()); err != nil {
+		log.Errorf("failed to set state cookie: %v", err)
+		a.loginFailed(w, r, p, errorInternal, nil)
+		return
+	}
+	http.Redirect(w, r, oauthConfig.AuthCodeURL(flow.State, flow.authCodeOptions()...), http.StatusSeeOther)
 }
 
-// LogoutFunc cleans up session cookies.
+// LogoutFunc cleans up session cookies. If the user's OIDC provider supports
+// RP-initiated logout, it responds with the URL that ends the provider's
+// session too.
 func (a *Authenticator) LogoutFunc(w http.ResponseWriter, r *http.Request) {
-	a.getLoginMethod().logout(w, r)
+	// Every provider shares the session cookie and store, so any of them can
+	// log out a user without a session.
+	p := a.readyProvider()
+	e := AuthEvent{Type: EventLogout}
+	if a.sessions != nil {
+		// Log out with the provider the session belongs to.
+		if ls, err := getLoginState(a.sessions, r); err == nil {
+			e.User, e.Provider = ls.username(), ls.provider
+			if sp := a.provider(ls.provider); sp != nil {
+				p = sp
+			}
+		}
+	} else if a.events != nil {
+		// Only look the user up for the event log, it may take a request
+		// to the API server.
+		if u, err := a.userFunc(r); err == nil {
+			e.User = u.Username
+		}
+	}
+	var lm loginMethod
+	if a.impersonator != nil {
+		lm = a.impersonator
+	} else if p != nil && p.isReady() {
+		_, lm = p.authFunc()
+	} else {
+		http.Error(w, "Identity provider not reachable", http.StatusServiceUnavailable)
+		return
+	}
+	// Impersonation ends with the session.
+	a.setImpersonationCookie(w, "", -1)
+	sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+	lm.logout(sw, r)
+	if sw.code >= 400 {
+		// The user is still logged in, for example because their token
+		// couldn't be revoked.
+		e.Type = EventLogoutFailed
+	}
+	a.RecordEvent(r, e)
+}
+
+// ScopeProfiles returns the names of the scope profiles users can log in with.
+func (a *Authenticator) ScopeProfiles() []string {
+	profiles := []string{}
+	for name := range a.scopeProfiles {
+		profiles = append(profiles, name)
+	}
+	sort.Strings(profiles)
+	return profiles
 }
 
 // GetKubeAdminLogoutURL returns the logout URL for the special kube:admin user in OpenShift
 func (a *Authenticator) GetKubeAdminLogoutURL() string {
+	if !a.Ready() {
+		return ""
+	}
 	return a.getLoginMethod().getKubeAdminLogoutURL()
 }
 
@@ -344,17 +762,36 @@ func (a *Authenticator) GetKubeAdminLogoutURL() string {
 // Requests with unexpected params are redirected to the root route.
 func (a *Authenticator) CallbackFunc(fn func(loginInfo LoginJSON, successURL string, w http.ResponseWriter)) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if a.impersonator != nil {
+			a.impersonatingCallback(fn, w, r)
+			return
+		}
+
 		q := r.URL.Query()
 		qErr := q.Get("error")
 		code := q.Get("code")
 		urlState := q.Get("state")
 
-		cookieState, err := r.Cookie(stateCookieName)
+		flow, err := a.getLoginFlow(r)
 		if err != nil {
 			log.Errorf("failed to parse state cookie: %v", err)
-			a.redirectAuthError(w, errorMissingState, err)
+			a.loginFailed(w, r, nil, errorMissingState, err)
 			return
 		}
+		p := a.provider(flow.Provider)
+		if p == nil {
+			log.Errorf("state cookie names unknown provider %q", flow.Provider)
+			a.loginFailed(w, r, nil, errorInvalidState, fmt.Errorf("unknown provider %q", flow.Provider))
+			return
+		}
+		// Keep the state, so the login can be completed once the provider
+		// is reachable.
+		if !p.isReady() {
+			serviceUnavailable(w)
+			return
+		}
+		// The state is only good for one attempt.
+		a.clearLoginFlowCookie(w, p.stateCookiePath())
 
 		// Lack of both `error` and `code` indicates some other redirect with no params.
 		if qErr == "" && code == "" {
@@ -364,43 +801,71 @@ func (a *Authenticator) CallbackFunc(fn func(loginInfo LoginJSON, successURL str
 
 		if code == "" {
 			log.Infof("missing auth code in query param")
-			a.redirectAuthError(w, errorMissingCode, nil)
+			a.loginFailed(w, r, p, errorMissingCode, nil)
 			return
 		}
 
-		if urlState != cookieState.Value {
+		if subtle.ConstantTimeCompare([]byte(urlState), []byte(flow.State)) != 1 {
 			log.Errorf("State in url does not match State cookie")
-			a.redirectAuthError(w, errorInvalidState, nil)
+			a.loginFailed(w, r, p, errorInvalidState, nil)
 			return
 		}
-		ctx := oidc.ClientContext(context.TODO(), a.clientFunc())
-		oauthConfig, lm := a.authFunc()
+		if p.callbackPath != "" && r.URL.Path != p.callbackPath {
+			log.Errorf("callback for provider %q arrived at %s, expected %s", p.name, r.URL.Path, p.callbackPath)
+			a.loginFailed(w, r, p, errorInvalidState, nil)
+			return
+		}
+		client := p.clientFunc()
+		if flow.Verifier != "" {
+			client = withCodeVerifier(client, flow.Verifier)
+		}
+		ctx := oidc.ClientContext(context.TODO(), client)
+		oauthConfig, lm := p.authFunc()
 		token, err := oauthConfig.Exchange(ctx, code)
 		if err != nil {
 			log.Infof("unable to verify auth code with issuer: %v", err)
-			a.redirectAuthError(w, errorInvalidCode, err)
+			a.loginFailed(w, r, p, errorInvalidCode, err)
 			return
 		}
 
-		ls, err := lm.login(w, token)
+		ls, err := lm.login(w, token, flow)
+		if denied, ok := err.(*accessDeniedError); ok {
+			log.Infof("rejected login with provider %q: %v", p.name, denied)
+			a.loginFailed(w, r, p, errorAccessDenied, denied)
+			return
+		}
 		if err != nil {
 			log.Errorf("error constructing login state: %v", err)
-			a.redirectAuthError(w, errorInternal, nil)
+			a.loginFailed(w, r, p, errorInternal, nil)
 			return
 		}
 
-		log.Infof("oauth success, redirecting to: %q", a.successURL)
-		fn(ls.toLoginJSON(), a.successURL, w)
-	}
-}
+		// The user has a new session, so any CSRF token they have is stale.
+		a.setCSRFCookie(w, csrfBinding(&User{SessionID: ls.sessionID, Token: ls.rawToken}))
+
+		successURL := a.successURL
+		if flow.Then != "" {
+			if returnURL, err := a.returnURL(flow.Then); err != nil {
+				log.Infof("ignoring invalid return path %q: %v", flow.Then, err)
+			} else {
+				successURL = returnURL
+			}
+		}
 
-func (a *Authenticator) getOAuth2Config() *oauth2.Config {
-	oauthConfig, _ := a.authFunc()
-	return oauthConfig
+		if p.name != "" {
+			log.Infof("user %q logged in with provider %q", ls.UserID, p.name)
+		}
+		a.RecordEvent(r, AuthEvent{Type: EventLoginSucceeded, User: ls.username(), Provider: p.name})
+		log.Infof("oauth success, redirecting to: %q", successURL)
+		fn(ls.toLoginJSON(), successURL, w)
+	}
 }
 
 func (a *Authenticator) getLoginMethod() loginMethod {
-	_, lm := a.authFunc()
+	if a.impersonator != nil {
+		return a.impersonator
+	}
+	_, lm := a.providers[0].authFunc()
 	return lm
 }
 
@@ -457,44 +922,50 @@ func (a *Authenticator) VerifySourceOrigin(r *http.Request) (err error) {
 	return nil
 }
 
-func (a *Authenticator) SetCSRFCookie(path string, w *http.ResponseWriter) {
-	cookie := http.Cookie{
-		Name:  CSRFCookieName,
-		Value: randomString(64),
-		// JS needs to read this Cookie
-		HttpOnly: false,
-		Path:     path,
-		Secure:   a.secureCookies,
+// SetCSRFCookie issues a CSRF token bound to the session of user, unless
+// the request already carries one that isn't about to expire. It's called
+// on every authenticated request, so tokens of active users are renewed and
+// a token for another session is replaced.
+func (a *Authenticator) SetCSRFCookie(w http.ResponseWriter, r *http.Request, user *User) {
+	binding := csrfBinding(user)
+	if cookie, err := r.Cookie(CSRFCookieName); err == nil {
+		if exp, err := a.checkCSRFToken(cookie.Value, binding, time.Now()); err == nil && time.Until(exp) > csrfTokenTTL/2 {
+			return
+		}
 	}
-	http.SetCookie(*w, &cookie)
+	a.setCSRFCookie(w, binding)
 }
 
-func (a *Authenticator) VerifyCSRFToken(r *http.Request) (err error) {
+// VerifyCSRFToken checks the CSRF token sent in the header, or the query for
+// websockets, was issued for the session of user and hasn't expired. Safe
+// methods don't need one, except to open a websocket, which isn't subject
+// to the same-origin policy.
+func (a *Authenticator) VerifyCSRFToken(r *http.Request, user *User) error {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			return nil
+		}
+	}
 	CSRFToken := r.Header.Get(CSRFHeader)
 	if CSRFToken == "" {
 		// Fallback to a query parameter, which is needed for websockets
 		CSRFToken = r.URL.Query().Get(CSRFQueryParam)
 	}
-
-	CRSCookie, err := r.Cookie(CSRFCookieName)
-	if err != nil {
-		return fmt.Errorf("No CSRF Cookie!")
-	}
-
-	tokenBytes := []byte(CSRFToken)
-	cookieBytes := []byte(CRSCookie.Value)
-
-	if 1 == subtle.ConstantTimeCompare(tokenBytes, cookieBytes) {
-		return nil
+	if CSRFToken == "" {
+		return fmt.Errorf("No CSRF token!")
 	}
 
-	return fmt.Errorf("CSRF token does not match CSRF cookie")
+	_, err := a.checkCSRFToken(CSRFToken, csrfBinding(user), time.Now())
+	return err
 }
 
-func NewDexClient(hostAndPort string, caCrt, clientCrt, clientKey string) (api.DexClient, error) {
+// NewDexClient connects to the Dex API. The returned io.Closer closes the
+// connection.
+func NewDexClient(hostAndPort string, caCrt, clientCrt, clientKey string) (api.DexClient, io.Closer, error) {
 	clientCert, err := tls.LoadX509KeyPair(clientCrt, clientKey)
 	if err != nil {
-		return nil, fmt.Errorf("invalid client crt file: %s", err)
+		return nil, nil, fmt.Errorf("invalid client crt file: %s", err)
 	}
 
 	var certPool *x509.CertPool
@@ -503,12 +974,12 @@ func NewDexClient(hostAndPort string, caCrt, clientCrt, clientKey string) (api.D
 		var err error
 
 		if caPEM, err = ioutil.ReadFile(caCrt); err != nil {
-			log.Fatalf("Failed to read cert file: %v", err)
+			return nil, nil, fmt.Errorf("failed to read cert file: %v", err)
 		}
 
 		certPool = x509.NewCertPool()
 		if !certPool.AppendCertsFromPEM(caPEM) {
-			log.Fatalf("No certs found in %q", caCrt)
+			return nil, nil, fmt.Errorf("no certs found in %q", caCrt)
 		}
 	}
 
@@ -520,7 +991,7 @@ func NewDexClient(hostAndPort string, caCrt, clientCrt, clientKey string) (api.D
 
 	conn, err := grpc.Dial(hostAndPort, grpc.WithTransportCredentials(creds))
 	if err != nil {
-		return nil, fmt.Errorf("dail: %v", err)
+		return nil, nil, fmt.Errorf("dail: %v", err)
 	}
-	return api.NewDexClient(conn), nil
+	return api.NewDexClient(conn), conn, nil
 }
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// identity is a user authenticated by the console itself rather than by the
+// API server.
+type identity struct {
+	username string
+	groups   []string
+	// expires is when the credential proving the identity expires, or nil if
+	// the console can't tell.
+	expires *time.Time
+}
+
+// impersonatingAuth authenticates users by something the console verifies
+// itself, such as a client certificate. The console then talks to the API
+// server with its own token, impersonating the user.
+type impersonatingAuth struct {
+	// source is the AuthSource of SessionStatus.
+	source string
+	// identify returns the user r was sent by.
+	identify func(r *http.Request) (*identity, error)
+	// errorCode is the error the login page shows if identify fails.
+	errorCode string
+	// token is the console's own token. It must be allowed to impersonate
+	// users and groups. It's replaced when the token is rotated.
+	mu    sync.RWMutex
+	token string
+	// loginURL is where LoginFunc sends users so CallbackFunc can hand the
+	// user's identity to the UI.
+	loginURL string
+}
+
+func (i *impersonatingAuth) authenticate(r *http.Request) (*User, error) {
+	id, err := i.identify(r)
+	if err != nil {
+		return nil, err
+	}
+	i.mu.RLock()
+	token := i.token
+	i.mu.RUnlock()
+	return &User{
+		ID:          id.username,
+		Username:    id.username,
+		Groups:      id.groups,
+		Token:       token,
+		Impersonate: true,
+	}, nil
+}
+
+// SetImpersonatorToken replaces the token users authenticated by the console
+// are impersonated with, for example after it was rotated. It does nothing
+// for other auth sources.
+func (a *Authenticator) SetImpersonatorToken(token string) {
+	if a.impersonator == nil {
+		return
+	}
+	a.impersonator.mu.Lock()
+	a.impersonator.token = token
+	a.impersonator.mu.Unlock()
+}
+
+func (i *impersonatingAuth) login(http.ResponseWriter, *oauth2.Token, *loginFlow) (*loginState, error) {
+	return nil, errors.New("users authenticated by the console don't log in with OAuth")
+}
+
+// logout does nothing. The browser keeps presenting the same credentials.
+func (i *impersonatingAuth) logout(http.ResponseWriter, *http.Request) {}
+
+func (i *impersonatingAuth) getKubeAdminLogoutURL() string {
+	return ""
+}
+
+// impersonatingLogin is LoginFunc for users authenticated by the console.
+// There's nothing to log in to, so it goes straight to the callback, keeping
+// the return path.
+func (a *Authenticator) impersonatingLogin(w http.ResponseWriter, r *http.Request) {
+	u := a.impersonator.loginURL
+	if then := r.URL.Query().Get("then"); then != "" {
+		u += "?then=" + url.QueryEscape(then)
+	}
+	http.Redirect(w, r, u, http.StatusSeeOther)
+}
+
+// impersonatingCallback is CallbackFunc for users authenticated by the
+// console.
+func (a *Authenticator) impersonatingCallback(fn func(loginInfo LoginJSON, successURL string, w http.ResponseWriter), w http.ResponseWriter, r *http.Request) {
+	user, err := a.impersonator.authenticate(r)
+	if err != nil {
+		log.Infof("%s login failed: %v", a.impersonator.source, err)
+		a.loginFailed(w, r, nil, a.impersonator.errorCode, err)
+		return
+	}
+
+	a.setCSRFCookie(w, csrfBinding(user))
+
+	successURL := a.successURL
+	if then := r.URL.Query().Get("then"); then != "" {
+		if returnURL, err := a.returnURL(then); err != nil {
+			log.Infof("ignoring invalid return path %q: %v", then, err)
+		} else {
+			successURL = returnURL
+		}
+	}
+	a.RecordEvent(r, AuthEvent{Type: EventLoginSucceeded, User: user.Username})
+	log.Infof("user %q logged in with %s", user.Username, a.impersonator.source)
+	fn(LoginJSON{UserID: user.ID, Name: user.Username, Groups: user.Groups}, successURL, w)
+}
@@ -2,22 +2,60 @@ package auth
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sync"
 	"time"
 
 	oidc "github.com/coreos/go-oidc"
 	"golang.org/x/oauth2"
 )
 
+// refreshBeforeExpiry is how long before the ID token expires a session
+// with a refresh token is renewed.
+const refreshBeforeExpiry = time.Minute
+
 type oidcAuth struct {
+	// provider is the name of the provider, or empty if it's the only one.
+	provider string
 	verifier *oidc.IDTokenVerifier
+	// claims names the ID token claims holding the user's details.
+	claims ClaimMapping
+	// admission decides which users may log in.
+	admission *AdmissionRules
+
+	// oauth2Config and client are used to refresh tokens.
+	oauth2Config *oauth2.Config
+	client       *http.Client
+	// refreshMux serializes token refreshes so concurrent requests don't
+	// redeem the same refresh token twice.
+	refreshMux sync.Mutex
+	// maxSessionAge bounds how long a session can be renewed with its
+	// refresh token.
+	maxSessionAge time.Duration
+
+	// endSessionURL is the provider's end_session_endpoint, or empty if it
+	// doesn't support RP-initiated logout.
+	endSessionURL string
+	// postLogoutRedirectURL is where the provider sends users after ending
+	// their session, if set.
+	postLogoutRedirectURL string
+	// logoutVerifier verifies back-channel logout tokens, which needn't
+	// have an expiry.
+	logoutVerifier *oidc.IDTokenVerifier
+	// kubectl is kubectl's OAuth2 client, if configured, and kubectlVerifier
+	// verifies the ID tokens issued to it.
+	kubectl         *oauth2.Config
+	kubectlVerifier *oidc.IDTokenVerifier
 
-	// This preserves the old logic of associating users with session keys
-	// and requires smart routing when running multiple backend instances.
-	sessions *SessionStore
+	// sessions associates users with session keys. Unless a shared store is
+	// configured, this requires smart routing when running multiple backend
+	// instances.
+	sessions SessionStore
 
 	cookiePath    string
 	secureCookies bool
@@ -25,10 +63,22 @@ type oidcAuth struct {
 
 type oidcConfig struct {
 	client        *http.Client
+	provider      string
 	issuerURL     string
 	clientID      string
+	clientSecret  string
+	scope         []string
 	cookiePath    string
 	secureCookies bool
+	sessions      SessionStore
+	maxSessionAge time.Duration
+	claims        ClaimMapping
+	admission     *AdmissionRules
+
+	postLogoutRedirectURL string
+	kubectlClientID       string
+	kubectlClientSecret   string
+	kubectlRedirectURL    string
 }
 
 func newOIDCAuth(ctx context.Context, c *oidcConfig) (oauth2.Endpoint, *oidcAuth, error) {
@@ -38,17 +88,63 @@ func newOIDCAuth(ctx context.Context, c *oidcConfig) (oauth2.Endpoint, *oidcAuth
 		return oauth2.Endpoint{}, nil, err
 	}
 
+	var metadata struct {
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+	if err := p.Claims(&metadata); err != nil {
+		return oauth2.Endpoint{}, nil, err
+	}
+
+	sessions := c.sessions
+	if sessions == nil {
+		sessions = NewMemorySessionStore(DefaultMaxSessions, DefaultMaxSessionsPerUser)
+	}
+
+	var (
+		kubectl         *oauth2.Config
+		kubectlVerifier *oidc.IDTokenVerifier
+	)
+	if c.kubectlClientID != "" {
+		kubectl = &oauth2.Config{
+			ClientID:     c.kubectlClientID,
+			ClientSecret: c.kubectlClientSecret,
+			Scopes:       kubectlScopes(c.scope),
+			Endpoint:     p.Endpoint(),
+			RedirectURL:  c.kubectlRedirectURL,
+		}
+		kubectlVerifier = p.Verifier(&oidc.Config{ClientID: c.kubectlClientID})
+	}
+
 	return p.Endpoint(), &oidcAuth{
+		provider: c.provider,
 		verifier: p.Verifier(&oidc.Config{
 			ClientID: c.clientID,
 		}),
-		sessions:      NewSessionStore(32768),
+		logoutVerifier: p.Verifier(&oidc.Config{
+			ClientID:        c.clientID,
+			SkipExpiryCheck: true,
+		}),
+		kubectl:               kubectl,
+		kubectlVerifier:       kubectlVerifier,
+		claims:                c.claims,
+		admission:             c.admission,
+		endSessionURL:         metadata.EndSessionEndpoint,
+		postLogoutRedirectURL: c.postLogoutRedirectURL,
+		oauth2Config: &oauth2.Config{
+			ClientID:     c.clientID,
+			ClientSecret: c.clientSecret,
+			Scopes:       c.scope,
+			Endpoint:     p.Endpoint(),
+		},
+		client:        c.client,
+		maxSessionAge: c.maxSessionAge,
+		sessions:      sessions,
 		cookiePath:    c.cookiePath,
 		secureCookies: c.secureCookies,
 	}, nil
 }
 
-func (o *oidcAuth) login(w http.ResponseWriter, token *oauth2.Token) (*loginState, error) {
+func (o *oidcAuth) login(w http.ResponseWriter, token *oauth2.Token, flow *loginFlow) (*loginState, error) {
 	rawIDToken, ok := token.Extra("id_token").(string)
 	if !ok {
 		return nil, errors.New("token response did not have an id_token field")
@@ -58,14 +154,29 @@ func (o *oidcAuth) login(w http.ResponseWriter, token *oauth2.Token) (*loginStat
 	if err != nil {
 		return nil, err
 	}
+	// Verify doesn't check the nonce, so a replayed ID token would pass.
+	if flow != nil && flow.Nonce != "" &&
+		subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(flow.Nonce)) != 1 {
+		return nil, errors.New("ID token nonce does not match the login request")
+	}
 	var c json.RawMessage
 	if err := idToken.Claims(&c); err != nil {
 		return nil, fmt.Errorf("parsing claims: %v", err)
 	}
-	ls, err := newLoginState(rawIDToken, []byte(c))
+	ls, err := o.claims.newLoginState(rawIDToken, []byte(c))
 	if err != nil {
 		return nil, err
 	}
+	if err := o.admission.admit(ls); err != nil {
+		return nil, err
+	}
+	ls.provider = o.provider
+	if token.RefreshToken != "" && o.maxSessionAge > 0 {
+		// Only kept server-side. The browser only ever sees the session token.
+		ls.refreshToken = token.RefreshToken
+		ls.refreshExp = ls.now().Add(o.maxSessionAge)
+	}
+	ls.lastActivity = time.Now()
 	if err := o.sessions.addSession(ls); err != nil {
 		return nil, err
 	}
@@ -73,7 +184,7 @@ func (o *oidcAuth) login(w http.ResponseWriter, token *oauth2.Token) (*loginStat
 	cookie := http.Cookie{
 		Name:     openshiftSessionCookieName,
 		Value:    ls.sessionToken,
-		MaxAge:   maxAge(ls.exp, time.Now()),
+		MaxAge:   maxAge(ls.sessionExp(), time.Now()),
 		HttpOnly: true,
 		Path:     o.cookiePath,
 		Secure:   o.secureCookies,
@@ -84,9 +195,16 @@ func (o *oidcAuth) login(w http.ResponseWriter, token *oauth2.Token) (*loginStat
 	return ls, nil
 }
 
+// logoutResponse is returned by logout if the browser should go on to the
+// provider to end its session too.
+type logoutResponse struct {
+	EndSessionURL string `json:"endSessionURL"`
+}
+
 func (o *oidcAuth) logout(w http.ResponseWriter, r *http.Request) {
 	// The returned login state can be nil even if err == nil.
-	if ls, _ := o.getLoginState(r); ls != nil {
+	ls, _ := o.getLoginState(r)
+	if ls != nil {
 		o.sessions.deleteSession(ls.sessionToken)
 	}
 	// Delete session cookie
@@ -99,39 +217,200 @@ func (o *oidcAuth) logout(w http.ResponseWriter, r *http.Request) {
 		Secure:   o.secureCookies,
 	}
 	http.SetCookie(w, &cookie)
-	w.WriteHeader(http.StatusNoContent)
+
+	if ls == nil || o.endSessionURL == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	u, err := o.endSessionLogoutURL(ls)
+	if err != nil {
+		log.Errorf("invalid end_session_endpoint: %v", err)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(logoutResponse{EndSessionURL: u})
+}
+
+// endSessionLogoutURL returns the URL that logs the user of ls out of the
+// provider, as described by OpenID Connect RP-Initiated Logout.
+func (o *oidcAuth) endSessionLogoutURL(ls *loginState) (string, error) {
+	u, err := url.Parse(o.endSessionURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	// The ID token tells the provider which session to end, even if it has
+	// expired.
+	q.Set("id_token_hint", ls.rawToken)
+	q.Set("client_id", o.oauth2Config.ClientID)
+	if o.postLogoutRedirectURL != "" {
+		q.Set("post_logout_redirect_uri", o.postLogoutRedirectURL)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
 }
 
 func (o *oidcAuth) getLoginState(r *http.Request) (*loginState, error) {
+	return getLoginState(o.sessions, r)
+}
+
+func getLoginState(sessions SessionStore, r *http.Request) (*loginState, error) {
 	sessionCookie, err := r.Cookie(openshiftSessionCookieName)
 	if err != nil {
 		return nil, err
 	}
 	sessionToken := sessionCookie.Value
-	ls := o.sessions.getSession(sessionToken)
+	ls := sessions.getSession(sessionToken)
 	if ls == nil {
 		return nil, fmt.Errorf("No session found on server")
 	}
-	if ls.exp.Sub(ls.now()) < 0 {
-		o.sessions.deleteSession(sessionToken)
+	if ls.sessionExp().Sub(ls.now()) < 0 {
+		sessions.deleteSession(sessionToken)
 		return nil, fmt.Errorf("Session is expired.")
 	}
 	return ls, nil
 }
 
+// needsRefresh reports whether the session's ID token should be renewed.
+// Sessions are never renewed past refreshExp.
+func (ls *loginState) needsRefresh() bool {
+	now := ls.now()
+	return ls.refreshToken != "" && now.Before(ls.refreshExp) && ls.exp.Sub(now) < refreshBeforeExpiry
+}
+
+// refresh redeems the session's refresh token for a new ID token, verifies it
+// and stores the renewed session. If the refresh fails the session is deleted
+// so the user is cleanly logged out rather than left with an expired token.
+func (o *oidcAuth) refresh(ls *loginState) (*loginState, error) {
+	o.refreshMux.Lock()
+	defer o.refreshMux.Unlock()
+
+	// Another request may have refreshed the session while we were waiting.
+	current := o.sessions.getSession(ls.sessionToken)
+	if current == nil {
+		return nil, errors.New("session was deleted")
+	}
+	if !current.needsRefresh() {
+		return current, nil
+	}
+
+	renewed, err := o.redeemRefreshToken(current)
+	if err != nil {
+		// Replicas sharing the session store don't share refreshMux. If one
+		// of them renewed the session meanwhile, the provider may have
+		// rejected our now stale refresh token: keep the renewed session.
+		if latest := o.sessions.getSession(ls.sessionToken); latest != nil && latest.rawToken != current.rawToken {
+			return latest, nil
+		}
+		o.sessions.deleteSession(ls.sessionToken)
+		return nil, fmt.Errorf("failed to refresh session, logging out: %v", err)
+	}
+	if err := o.sessions.updateSession(renewed); err != nil {
+		return nil, err
+	}
+	log.Debugf("refreshed session %s of user %q", renewed.sessionID, renewed.UserID)
+	return renewed, nil
+}
+
+func (o *oidcAuth) redeemRefreshToken(ls *loginState) (*loginState, error) {
+	ctx := oidc.ClientContext(context.Background(), o.client)
+	token, err := o.oauth2Config.TokenSource(ctx, &oauth2.Token{RefreshToken: ls.refreshToken}).Token()
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("refresh response did not have an id_token field")
+	}
+	idToken, err := o.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	var c json.RawMessage
+	if err := idToken.Claims(&c); err != nil {
+		return nil, fmt.Errorf("parsing claims: %v", err)
+	}
+	renewed, err := o.claims.newLoginState(rawIDToken, []byte(c))
+	if err != nil {
+		return nil, err
+	}
+	if renewed.UserID != ls.UserID {
+		return nil, fmt.Errorf("refreshed token subject %q does not match session subject %q", renewed.UserID, ls.UserID)
+	}
+	// The user's groups may have changed since they logged in.
+	if err := o.admission.admit(renewed); err != nil {
+		return nil, err
+	}
+
+	renewed.sessionToken = ls.sessionToken
+	renewed.sessionID = ls.sessionID
+	renewed.provider = ls.provider
+	renewed.lastActivity = ls.lastActivity
+	renewed.impersonation = ls.impersonation
+	if renewed.providerSID == "" {
+		renewed.providerSID = ls.providerSID
+	}
+	renewed.refreshExp = ls.refreshExp
+	renewed.now = ls.now
+	renewed.refreshToken = ls.refreshToken
+	// Providers that rotate refresh tokens return a new one with every refresh.
+	if token.RefreshToken != "" {
+		renewed.refreshToken = token.RefreshToken
+	}
+	return renewed, nil
+}
+
 func (o *oidcAuth) authenticate(r *http.Request) (*User, error) {
 	ls, err := o.getLoginState(r)
 	if err != nil {
 		return nil, err
 	}
+	return o.authenticateLoginState(ls)
+}
+
+func (o *oidcAuth) authenticateLoginState(ls *loginState) (*User, error) {
+	var err error
+	if ls.needsRefresh() {
+		if ls, err = o.refresh(ls); err != nil {
+			return nil, err
+		}
+	}
 
 	return &User{
-		ID:       ls.UserID,
-		Username: ls.Name,
-		Token:    ls.rawToken,
+		ID:            ls.UserID,
+		Username:      ls.Username,
+		Groups:        ls.Groups,
+		Token:         ls.rawToken,
+		SessionID:     ls.sessionID,
+		Impersonating: ls.impersonation,
 	}, nil
 }
 
+// oidcProviders are OIDC providers sharing a session store, by name.
+type oidcProviders map[string]*provider
+
+// authenticate returns the user of the session in r. Sessions are renewed by
+// the provider that authenticated them, so they can't be used until it was
+// reached.
+func (providers oidcProviders) authenticate(r *http.Request, sessions SessionStore) (*User, error) {
+	ls, err := getLoginState(sessions, r)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := providers[ls.provider]
+	if !ok {
+		return nil, fmt.Errorf("session was authenticated by unknown provider %q", ls.provider)
+	}
+	if !p.isReady() {
+		return nil, errStarting
+	}
+	_, lm := p.authFunc()
+	return lm.(*oidcAuth).authenticateLoginState(ls)
+}
+
 func (o *oidcAuth) getKubeAdminLogoutURL() string {
 	return ""
 }
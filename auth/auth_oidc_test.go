@@ -0,0 +1,594 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// testOIDCProvider is a test OpenID Connect provider that signs ID tokens and
+// supports the refresh_token grant.
+type testOIDCProvider struct {
+	t        *testing.T
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+	subject  string
+	// sid is the provider's session ID, if set.
+	sid string
+	// idTokenTTL is the lifetime of issued ID tokens.
+	idTokenTTL time.Duration
+
+	mux         sync.Mutex
+	refreshes   int
+	failRefresh bool
+	// onRefresh, if set, is called before answering a refresh request.
+	onRefresh func()
+	// codes are the authorization codes the provider accepts.
+	codes map[string]testAuthCode
+}
+
+// testAuthCode is an authorization code issued by testOIDCProvider.
+type testAuthCode struct {
+	// challenge is the PKCE code challenge the code was requested with.
+	challenge string
+	// nonce is returned in the ID token.
+	nonce string
+	// clientID and clientSecret, if set, are the client the code was issued
+	// to instead of the console's. The ID token is issued to it too.
+	clientID     string
+	clientSecret string
+}
+
+func newTestOIDCProvider(t *testing.T) *testOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate signing key: %v", err)
+	}
+	p := &testOIDCProvider{
+		t:          t,
+		key:        key,
+		clientID:   "fake-client-id",
+		subject:    "user-id",
+		idTokenTTL: time.Hour,
+		codes:      make(map[string]testAuthCode),
+	}
+	p.server = httptest.NewServer(http.HandlerFunc(p.ServeHTTP))
+	return p
+}
+
+func (p *testOIDCProvider) issuer() string {
+	return p.server.URL
+}
+
+func (p *testOIDCProvider) Close() {
+	p.server.Close()
+}
+
+func (p *testOIDCProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{
+ "issuer": "%s",
+ "authorization_endpoint": "%s/auth",
+ "token_endpoint": "%s/token",
+ "jwks_uri": "%s/keys",
+ "end_session_endpoint": "%s/logout"
+}`, p.issuer(), p.issuer(), p.issuer(), p.issuer(), p.issuer())
+	case "/keys":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+			Key:       &p.key.PublicKey,
+			Algorithm: "RS256",
+			Use:       "sig",
+		}}})
+	case "/token":
+		p.handleToken(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (p *testOIDCProvider) handleToken(w http.ResponseWriter, r *http.Request) {
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		p.handleCode(w, r)
+		return
+	case "refresh_token":
+	default:
+		http.Error(w, `{"error": "unsupported_grant_type"}`, http.StatusBadRequest)
+		return
+	}
+
+	p.mux.Lock()
+	onRefresh := p.onRefresh
+	p.mux.Unlock()
+	if onRefresh != nil {
+		onRefresh()
+	}
+
+	p.mux.Lock()
+	fail := p.failRefresh
+	p.refreshes++
+	n := p.refreshes
+	p.mux.Unlock()
+
+	if fail {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error": "invalid_grant"}`)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  fmt.Sprintf("access-token-%d", n),
+		"token_type":    "bearer",
+		"expires_in":    int(p.idTokenTTL.Seconds()),
+		"refresh_token": fmt.Sprintf("refresh-token-%d", n),
+		"id_token":      p.idToken(map[string]interface{}{"jti": fmt.Sprintf("refresh-%d", n)}),
+	})
+}
+
+func (p *testOIDCProvider) handleCode(w http.ResponseWriter, r *http.Request) {
+	p.mux.Lock()
+	code, ok := p.codes[r.FormValue("code")]
+	delete(p.codes, r.FormValue("code"))
+	p.mux.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ok || (code.challenge != "" && pkceChallenge(r.FormValue("code_verifier")) != code.challenge) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error": "invalid_grant"}`)
+		return
+	}
+
+	extra := map[string]interface{}{}
+	if code.nonce != "" {
+		extra["nonce"] = code.nonce
+	}
+	refreshToken := "refresh-token-0"
+	if code.clientID != "" {
+		clientID, clientSecret, ok := r.BasicAuth()
+		if !ok {
+			clientID, clientSecret = r.FormValue("client_id"), r.FormValue("client_secret")
+		}
+		if clientID != code.clientID || clientSecret != code.clientSecret {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": "invalid_client"}`)
+			return
+		}
+		extra["aud"] = code.clientID
+		refreshToken = code.clientID + "-refresh-token"
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  "access-token",
+		"token_type":    "bearer",
+		"expires_in":    int(p.idTokenTTL.Seconds()),
+		"refresh_token": refreshToken,
+		"id_token":      p.idToken(extra),
+	})
+}
+
+// idToken returns a signed ID token with the given extra claims.
+func (p *testOIDCProvider) idToken(extra map[string]interface{}) string {
+	claims := map[string]interface{}{
+		"iss":   p.issuer(),
+		"sub":   p.subject,
+		"aud":   p.clientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(p.idTokenTTL).Unix(),
+		"email": "user@example.com",
+		"name":  "User",
+	}
+	if p.sid != "" {
+		claims["sid"] = p.sid
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	return p.sign(claims)
+}
+
+func (p *testOIDCProvider) sign(claims map[string]interface{}) string {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: p.key}, nil)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	raw, err := jws.CompactSerialize()
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return raw
+}
+
+func newTestOIDCAuth(t *testing.T, p *testOIDCProvider, maxSessionAge time.Duration) *oidcAuth {
+	_, o, err := newOIDCAuth(context.Background(), &oidcConfig{
+		client:        http.DefaultClient,
+		issuerURL:     p.issuer(),
+		clientID:      p.clientID,
+		cookiePath:    "/",
+		maxSessionAge: maxSessionAge,
+	})
+	if err != nil {
+		t.Fatalf("newOIDCAuth error: %v", err)
+	}
+	return o
+}
+
+// loginRequest logs in with token and returns a request carrying the session cookie.
+func loginRequest(t *testing.T, o *oidcAuth, token *oauth2.Token) *http.Request {
+	w := httptest.NewRecorder()
+	if _, err := o.login(w, token, nil); err != nil {
+		t.Fatalf("login error: %v", err)
+	}
+	r := httptest.NewRequest("GET", "/api/kubernetes/", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestOIDCRefresh(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	defer p.Close()
+	o := newTestOIDCAuth(t, p, time.Hour)
+
+	// ID tokens expiring within refreshBeforeExpiry are renewed on use.
+	p.idTokenTTL = refreshBeforeExpiry / 2
+	initial := p.idToken(nil)
+	token := (&oauth2.Token{AccessToken: "access-token-0", RefreshToken: "refresh-token-0"}).
+		WithExtra(map[string]interface{}{"id_token": initial})
+	r := loginRequest(t, o, token)
+
+	user, err := o.authenticate(r)
+	if err != nil {
+		t.Fatalf("authenticate error: %v", err)
+	}
+	if user.Token == initial {
+		t.Error("expected ID token to be refreshed")
+	}
+	if p.refreshes != 1 {
+		t.Errorf("refreshes = %d, want 1", p.refreshes)
+	}
+	ls, err := o.getLoginState(r)
+	if err != nil {
+		t.Fatalf("getLoginState error: %v", err)
+	}
+	if ls.refreshToken != "refresh-token-1" {
+		t.Errorf("rotated refresh token was not stored, got %q", ls.refreshToken)
+	}
+
+	// A failed refresh logs the user out.
+	p.mux.Lock()
+	p.failRefresh = true
+	p.mux.Unlock()
+	if _, err := o.authenticate(r); err == nil {
+		t.Fatal("expected authenticate to fail after refresh failure")
+	}
+	if _, err := o.getLoginState(r); err == nil {
+		t.Error("expected session to be deleted after refresh failure")
+	}
+}
+
+func TestOIDCRefreshByAnotherReplica(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	defer p.Close()
+	// Replicas share the session store, but not their refresh lock.
+	sessions := NewMemorySessionStore(DefaultMaxSessions, DefaultMaxSessionsPerUser)
+	newReplica := func() *oidcAuth {
+		_, o, err := newOIDCAuth(context.Background(), &oidcConfig{
+			client:        http.DefaultClient,
+			issuerURL:     p.issuer(),
+			clientID:      p.clientID,
+			cookiePath:    "/",
+			maxSessionAge: time.Hour,
+			sessions:      sessions,
+		})
+		if err != nil {
+			t.Fatalf("newOIDCAuth error: %v", err)
+		}
+		return o
+	}
+	a, b := newReplica(), newReplica()
+
+	p.idTokenTTL = refreshBeforeExpiry / 2
+	token := (&oauth2.Token{AccessToken: "access-token-0", RefreshToken: "refresh-token-0"}).
+		WithExtra(map[string]interface{}{"id_token": p.idToken(nil)})
+	r := loginRequest(t, a, token)
+
+	// While b redeems the refresh token, a renews the session with it. The
+	// provider rotates refresh tokens, so it rejects b's.
+	raced := false
+	p.onRefresh = func() {
+		if raced {
+			return
+		}
+		raced = true
+		if _, err := a.authenticate(r); err != nil {
+			t.Errorf("refresh by the other replica failed: %v", err)
+		}
+		p.mux.Lock()
+		p.failRefresh = true
+		p.mux.Unlock()
+	}
+
+	user, err := b.authenticate(r)
+	if err != nil {
+		t.Fatalf("session renewed by another replica was logged out: %v", err)
+	}
+	ls, err := b.getLoginState(r)
+	if err != nil {
+		t.Fatalf("session renewed by another replica was deleted: %v", err)
+	}
+	if user.Token != ls.rawToken || ls.refreshToken != "refresh-token-1" {
+		t.Errorf("user token = %q, session refresh token = %q, want the other replica's renewal", user.Token, ls.refreshToken)
+	}
+}
+
+func TestOIDCNoRefreshToken(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	defer p.Close()
+	o := newTestOIDCAuth(t, p, time.Hour)
+
+	p.idTokenTTL = refreshBeforeExpiry / 2
+	token := (&oauth2.Token{AccessToken: "access-token-0"}).
+		WithExtra(map[string]interface{}{"id_token": p.idToken(nil)})
+	r := loginRequest(t, o, token)
+
+	if _, err := o.authenticate(r); err != nil {
+		t.Fatalf("authenticate error: %v", err)
+	}
+	if p.refreshes != 0 {
+		t.Errorf("refreshes = %d, want 0", p.refreshes)
+	}
+}
+
+func TestOIDCMaxSessionAge(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	defer p.Close()
+	o := newTestOIDCAuth(t, p, time.Hour)
+
+	p.idTokenTTL = refreshBeforeExpiry / 2
+	token := (&oauth2.Token{AccessToken: "access-token-0", RefreshToken: "refresh-token-0"}).
+		WithExtra(map[string]interface{}{"id_token": p.idToken(nil)})
+	r := loginRequest(t, o, token)
+	cookie, err := r.Cookie(openshiftSessionCookieName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	o.sessions.getSession(cookie.Value).now = func() time.Time { return now }
+
+	// Renewed tokens may outlive the maximum session age.
+	p.idTokenTTL = 2 * time.Hour
+	now = now.Add(30 * time.Minute)
+	if _, err := o.authenticate(r); err != nil {
+		t.Fatalf("authenticate error: %v", err)
+	}
+	if p.refreshes != 1 {
+		t.Fatalf("refreshes = %d, want 1", p.refreshes)
+	}
+
+	now = now.Add(31 * time.Minute)
+	if _, err := o.authenticate(r); err == nil {
+		t.Error("session outlived the maximum session age")
+	}
+	if o.sessions.getSession(cookie.Value) != nil {
+		t.Error("expected session to be deleted after the maximum session age")
+	}
+	if p.refreshes != 1 {
+		t.Errorf("refreshes = %d, want 1", p.refreshes)
+	}
+}
+
+// loginSession logs in through a and returns a request with the session cookie.
+func loginSession(t *testing.T, a *Authenticator, p *testOIDCProvider) *http.Request {
+	q, cookie := startLogin(t, a)
+	p.codes["good-code"] = testAuthCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	w, ok := finishLogin(a, "good-code", q.Get("state"), cookie)
+	if !ok {
+		t.Fatalf("login failed, redirected to %s", w.Header().Get("Location"))
+	}
+
+	r := httptest.NewRequest("GET", "/api/console/session", nil)
+	for _, c := range w.Result().Cookies() {
+		if c.Name == openshiftSessionCookieName {
+			r.AddCookie(c)
+		}
+	}
+	return r
+}
+
+func TestKubectlCredentials(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	defer p.Close()
+	c := testOIDCConfig(p)
+	c.MaxSessionAge = time.Hour
+	c.KubectlClientID = "kubectl"
+	c.KubectlClientSecret = "kubectl-secret"
+	c.KubectlRedirectURL = "https://console.example.com/api/console/kubeconfig/callback"
+	a := newTestAuthenticator(t, c)
+
+	r := loginSession(t, a, p)
+	kubectlLogin := func() (url.Values, *http.Cookie) {
+		w := httptest.NewRecorder()
+		if err := a.KubectlLogin(w, r); err != nil {
+			t.Fatal(err)
+		}
+		loc, err := url.Parse(w.Header().Get("Location"))
+		if err != nil || w.Code != http.StatusSeeOther {
+			t.Fatalf("kubectl login: status = %d, location = %q", w.Code, w.Header().Get("Location"))
+		}
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == stateCookieName {
+				return loc.Query(), cookie
+			}
+		}
+		t.Fatal("kubectl login did not set a state cookie")
+		return nil, nil
+	}
+	kubectlCallback := func(q url.Values, cookie *http.Cookie, code string) (*OIDCCredentials, error) {
+		cr := httptest.NewRequest("GET", "/api/console/kubeconfig/callback?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), nil)
+		cr.AddCookie(r.Cookies()[0])
+		cr.AddCookie(cookie)
+		return a.KubectlCredentials(httptest.NewRecorder(), cr)
+	}
+
+	q, cookie := kubectlLogin()
+	if q.Get("client_id") != "kubectl" || q.Get("redirect_uri") != c.KubectlRedirectURL ||
+		!strings.Contains(q.Get("scope"), "offline_access") || q.Get("code_challenge") == "" {
+		t.Errorf("unexpected kubectl authorization request: %v", q)
+	}
+	if cookie.Path != "/api/console/kubeconfig/callback" {
+		t.Errorf("state cookie path = %q, want the kubectl redirect URL's", cookie.Path)
+	}
+	p.codes["kubectl-code"] = testAuthCode{
+		challenge:    q.Get("code_challenge"),
+		nonce:        q.Get("nonce"),
+		clientID:     "kubectl",
+		clientSecret: "kubectl-secret",
+	}
+	creds, err := kubectlCallback(q, cookie, "kubectl-code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.IssuerURL != p.issuer() || creds.ClientID != "kubectl" || creds.ClientSecret != "kubectl-secret" ||
+		creds.RefreshToken != "kubectl-refresh-token" || creds.IDToken == "" {
+		t.Errorf("credentials = %+v, want kubectl's own from %s", creds, p.issuer())
+	}
+
+	// Tokens of another user must not end up in the session user's kubeconfig.
+	q, cookie = kubectlLogin()
+	p.subject = "other-user-id"
+	p.codes["other-code"] = testAuthCode{
+		challenge:    q.Get("code_challenge"),
+		nonce:        q.Get("nonce"),
+		clientID:     "kubectl",
+		clientSecret: "kubectl-secret",
+	}
+	if _, err := kubectlCallback(q, cookie, "other-code"); err == nil {
+		t.Error("got credentials of another user")
+	}
+	p.subject = "user-id"
+
+	// Codes issued to the console's client aren't kubectl's.
+	q, cookie = kubectlLogin()
+	p.codes["console-code"] = testAuthCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	if _, err := kubectlCallback(q, cookie, "console-code"); err == nil {
+		t.Error("got credentials from a code issued to the console")
+	}
+
+	if err := a.KubectlLogin(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/console/kubeconfig", nil)); err == nil {
+		t.Error("started a kubectl login for a request without a session")
+	}
+}
+
+func TestKubectlScopes(t *testing.T) {
+	got := kubectlScopes([]string{"openid", "email", "audience:server:client_id:console", "audience:server:client_id:kubectl"})
+	if want := []string{"openid", "email", "offline_access"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("kubectlScopes = %v, want %v", got, want)
+	}
+}
+
+func TestSessionStatus(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	defer p.Close()
+	c := testOIDCConfig(p)
+	c.MaxSessionAge = time.Hour
+	a := newTestAuthenticator(t, c)
+
+	r := loginSession(t, a, p)
+	status, err := a.SessionStatus(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.AuthSource != "oidc" || status.Expires == nil || status.TokenExpires == nil {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if d := time.Until(*status.Expires); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("session expires in %s, want the max session age", d)
+	}
+
+	sessions, err := a.ListSessions("")
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("ListSessions = %v, %v", sessions, err)
+	}
+	if err := a.RevokeSession(sessions[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.SessionStatus(r); err != ErrSessionNotFound {
+		t.Errorf("status of revoked session: err = %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func TestOIDCLogout(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	defer p.Close()
+	c := testOIDCConfig(p)
+	c.PostLogoutRedirectURL = "http://example.com/"
+	a := newTestAuthenticator(t, c)
+
+	r := loginSession(t, a, p)
+	user, err := a.Authenticate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	a.LogoutFunc(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("logout status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var resp logoutResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(resp.EndSessionURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := u.Scheme+"://"+u.Host+u.Path, p.issuer()+"/logout"; got != want {
+		t.Errorf("end session URL = %s, want %s", got, want)
+	}
+	want := url.Values{
+		"id_token_hint":            {user.Token},
+		"client_id":                {p.clientID},
+		"post_logout_redirect_uri": {"http://example.com/"},
+	}
+	if !reflect.DeepEqual(u.Query(), want) {
+		t.Errorf("end session parameters = %v, want %v", u.Query(), want)
+	}
+	if _, err := a.Authenticate(r); err == nil {
+		t.Error("session still valid after logout")
+	}
+
+	// Without a session there's nothing to end at the provider.
+	w = httptest.NewRecorder()
+	a.LogoutFunc(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("logout without session: status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
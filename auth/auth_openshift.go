@@ -2,6 +2,8 @@ package auth
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -14,12 +16,37 @@ import (
 	"github.com/openshift/console/pkg/proxy"
 )
 
+const (
+	openShiftAccessTokensPath = "/apis/oauth.openshift.io/v1/oauthaccesstokens/"
+	// sha256TokenPrefix marks access tokens whose OAuthAccessToken is named
+	// after a hash of the token rather than the token itself.
+	sha256TokenPrefix = "sha256~"
+
+	tokenRevocationAttempts = 3
+)
+
+// tokenRevocationBackoff is how long to wait before retrying a failed token
+// revocation.
+var tokenRevocationBackoff = 500 * time.Millisecond
+
 // openShiftAuth implements OpenShift Authentication as defined in:
 // https://docs.openshift.com/container-platform/3.9/architecture/additional_concepts/authentication.html
 type openShiftAuth struct {
 	cookiePath         string
 	secureCookies      bool
 	kubeAdminLogoutURL string
+	// cookieKeys encrypts the access token stored in the session cookie.
+	// If nil, the token is stored in plain text.
+	cookieKeys *KeySet
+	users      *openShiftUserResolver
+	// admission decides which users may log in.
+	admission *AdmissionRules
+	// idleTimeout, if set, ends sessions unused for that long. The last
+	// activity is kept in a cookie signed with cookieKeys.
+	idleTimeout time.Duration
+	// apiServerURL and k8sClient are used to revoke access tokens on logout.
+	apiServerURL string
+	k8sClient    *http.Client
 }
 
 type openShiftConfig struct {
@@ -28,6 +55,10 @@ type openShiftConfig struct {
 	issuerURL     string
 	cookiePath    string
 	secureCookies bool
+	cookieKeys    *KeySet
+	users         *openShiftUserResolver
+	admission     *AdmissionRules
+	idleTimeout   time.Duration
 }
 
 func validateAbsURL(value string) error {
@@ -104,18 +135,51 @@ func newOpenShiftAuth(ctx context.Context, c *openShiftConfig) (oauth2.Endpoint,
 	return oauth2.Endpoint{
 		AuthURL:  metadata.Auth,
 		TokenURL: metadata.Token,
-	}, &openShiftAuth{c.cookiePath, c.secureCookies, kubeAdminLogoutURL}, nil
+	}, &openShiftAuth{
+		cookiePath:         c.cookiePath,
+		secureCookies:      c.secureCookies,
+		kubeAdminLogoutURL: kubeAdminLogoutURL,
+		cookieKeys:         c.cookieKeys,
+		users:              c.users,
+		admission:          c.admission,
+		idleTimeout:        c.idleTimeout,
+		apiServerURL:       c.issuerURL,
+		k8sClient:          c.k8sClient,
+	}, nil
 
 }
 
-func (o *openShiftAuth) login(w http.ResponseWriter, token *oauth2.Token) (*loginState, error) {
+func (o *openShiftAuth) login(w http.ResponseWriter, token *oauth2.Token, flow *loginFlow) (*loginState, error) {
 	if token.AccessToken == "" {
 		return nil, fmt.Errorf("token response did not contain an access token %#v", token)
 	}
+
+	// The token response doesn't say who the user is, so ask the API server.
+	user, err := o.users.resolve(context.Background(), token.AccessToken)
+	if err == errUserForbidden {
+		// Admission rules can't be checked without the identity.
+		return nil, fmt.Errorf("failed to look up user, the requested scopes must include user:info: %v", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %v", err)
+	}
 	ls := &loginState{
-		// Not clear if there's another way to fill in information like the user's name.
+		UserID:   user.UID,
+		Username: user.Name,
+		Name:     user.Name,
+		Groups:   user.Groups,
 		rawToken: token.AccessToken,
 	}
+	if err := o.admission.admit(ls); err != nil {
+		// Don't leave a usable token behind for a user who isn't let in.
+		if revokeErr := o.revokeToken(context.Background(), token.AccessToken); revokeErr != nil {
+			log.Errorf("failed to revoke access token of rejected user %q: %v", user.Name, revokeErr)
+		}
+		return nil, err
+	}
+	if flow != nil {
+		ls.ScopeProfile = flow.ScopeProfile
+	}
 
 	expiresIn := (time.Hour * 24).Seconds()
 	if !token.Expiry.IsZero() {
@@ -125,14 +189,14 @@ func (o *openShiftAuth) login(w http.ResponseWriter, token *oauth2.Token) (*logi
 	// NOTE: In Tectonic, we previously had issues with tokens being bigger than
 	// cookies can handle. Since OpenShift doesn't store groups in the token, the
 	// token can't grow arbitrarily big, so we assume it will always fit in a cookie
-	// value.
+	// value, even once encrypted.
 	//
 	// NOTE: in the future we'll have to avoid the use of cookies. This should likely switch to frontend
 	// only logic using the OAuth2 implicit flow.
 	// https://tools.ietf.org/html/rfc6749#section-4.2
 	cookie := http.Cookie{
 		Name:     openshiftSessionCookieName,
-		Value:    ls.rawToken,
+		Value:    encodeSessionCookie(ls.rawToken, o.cookieKeys),
 		MaxAge:   int(expiresIn),
 		HttpOnly: true,
 		Path:     o.cookiePath,
@@ -140,29 +204,145 @@ func (o *openShiftAuth) login(w http.ResponseWriter, token *oauth2.Token) (*logi
 	}
 
 	http.SetCookie(w, &cookie)
+	if o.idleTimeout > 0 {
+		o.setActivityCookie(w, cookie.Value, time.Now())
+	}
+
+	// The token itself is restricted by the API server. The profile is only
+	// kept so the UI can tell the user is, for example, read-only.
+	profileCookie := http.Cookie{
+		Name:     openshiftScopeProfileCookieName,
+		Value:    ls.ScopeProfile,
+		MaxAge:   int(expiresIn),
+		HttpOnly: true,
+		Path:     o.cookiePath,
+		Secure:   o.secureCookies,
+	}
+	if ls.ScopeProfile == "" {
+		profileCookie.MaxAge = -1
+	}
+	http.SetCookie(w, &profileCookie)
 	return ls, nil
 }
 
+// openShiftLogoutResponse reports whether logging out revoked the user's
+// access token.
+type openShiftLogoutResponse struct {
+	TokenRevoked bool   `json:"tokenRevoked"`
+	Message      string `json:"message,omitempty"`
+}
+
+// logout revokes the user's access token and deletes the session cookies. If
+// the token can't be revoked the user stays logged in, so they can try again
+// rather than leave a usable token behind.
 func (o *openShiftAuth) logout(w http.ResponseWriter, r *http.Request) {
 	// NOTE: cookies are going away, this should be removed in the future
 
-	// Delete session cookie
-	cookie := http.Cookie{
-		Name:     openshiftSessionCookieName,
-		Value:    "",
-		MaxAge:   0,
-		HttpOnly: true,
-		Path:     o.cookiePath,
-		Secure:   o.secureCookies,
+	revoked := false
+	if cookie, err := r.Cookie(openshiftSessionCookieName); err == nil {
+		if token, err := decodeSessionCookie(cookie.Value, o.cookieKeys); err == nil && token != "" {
+			if err := o.revokeToken(r.Context(), token); err != nil {
+				log.Errorf("failed to revoke access token on logout: %v", err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadGateway)
+				json.NewEncoder(w).Encode(openShiftLogoutResponse{
+					Message: "Failed to revoke your access token, so you are still logged in. Please try again.",
+				})
+				return
+			}
+			revoked = true
+			if o.users != nil {
+				o.users.forget(token)
+			}
+		}
 	}
-	http.SetCookie(w, &cookie)
-	w.WriteHeader(http.StatusNoContent)
+
+	o.deleteSessionCookies(w)
+	if !revoked {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openShiftLogoutResponse{TokenRevoked: true})
+}
+
+func (o *openShiftAuth) deleteSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{openshiftSessionCookieName, openshiftScopeProfileCookieName, openshiftActivityCookieName} {
+		cookie := http.Cookie{
+			Name:     name,
+			Value:    "",
+			MaxAge:   0,
+			HttpOnly: true,
+			Path:     o.cookiePath,
+			Secure:   o.secureCookies,
+		}
+		http.SetCookie(w, &cookie)
+	}
+}
+
+// revokeToken deletes the OAuthAccessToken of token, retrying failures that
+// may be temporary.
+func (o *openShiftAuth) revokeToken(ctx context.Context, token string) error {
+	for attempt := 1; ; attempt++ {
+		retry, err := o.deleteAccessToken(ctx, token)
+		if err == nil || !retry || attempt == tokenRevocationAttempts {
+			return err
+		}
+		log.Infof("failed to revoke access token, retrying: %v", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(tokenRevocationBackoff):
+		}
+	}
+}
+
+// deleteAccessToken deletes the OAuthAccessToken of token, authenticating
+// with the token itself. It reports whether a failure is worth retrying.
+func (o *openShiftAuth) deleteAccessToken(ctx context.Context, token string) (bool, error) {
+	u := strings.TrimSuffix(o.apiServerURL, "/") + openShiftAccessTokensPath + url.PathEscape(openShiftTokenName(token))
+	req, err := http.NewRequest(http.MethodDelete, u, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	resp, err := o.k8sClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return true, fmt.Errorf("request to delete access token failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode/100 == 2,
+		// The token is already gone or no longer accepted.
+		resp.StatusCode == http.StatusNotFound,
+		resp.StatusCode == http.StatusUnauthorized:
+		return false, nil
+	case resp.StatusCode/100 == 5, resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("request to delete access token failed: %s", resp.Status)
+	default:
+		return false, fmt.Errorf("request to delete access token failed: %s", resp.Status)
+	}
+}
+
+// openShiftTokenName returns the name of the OAuthAccessToken of token.
+// Tokens with the sha256~ prefix are stored under a hash of the token, so
+// the token can't be read back from the API.
+func openShiftTokenName(token string) string {
+	if !strings.HasPrefix(token, sha256TokenPrefix) {
+		return token
+	}
+	sum := sha256.Sum256([]byte(strings.TrimPrefix(token, sha256TokenPrefix)))
+	return sha256TokenPrefix + base64.RawURLEncoding.EncodeToString(sum[:])
 }
 
-func getOpenShiftUser(r *http.Request) (*User, error) {
-	// TODO: This doesn't do any validation of the cookie with the assumption that the
-	// API server will reject tokens it doesn't recognize. If we want to keep some backend
-	// state we should sign this cookie. If not there's not much we can do.
+func getOpenShiftUser(r *http.Request, cookieKeys *KeySet, users *openShiftUserResolver) (*User, error) {
+	// The token is checked with the API server when the user's identity is
+	// looked up, and the result is cached for a short while. Proxied requests
+	// carry the token, so the API server still rejects tokens revoked in the
+	// meantime. When cookie keys are configured the cookie is authenticated, so
+	// tampered cookies are rejected here.
 	cookie, err := r.Cookie(openshiftSessionCookieName)
 	if err != nil {
 		return nil, err
@@ -171,9 +351,61 @@ func getOpenShiftUser(r *http.Request) (*User, error) {
 		return nil, fmt.Errorf("unauthenticated")
 	}
 
-	return &User{
-		Token: cookie.Value,
-	}, nil
+	token, err := decodeSessionCookie(cookie.Value, cookieKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{
+		Token: token,
+	}
+	if profileCookie, err := r.Cookie(openshiftScopeProfileCookieName); err == nil {
+		user.ScopeProfile = profileCookie.Value
+	}
+	if users == nil {
+		return user, nil
+	}
+
+	info, err := users.resolve(r.Context(), token)
+	switch {
+	case err == errTokenRejected:
+		return nil, err
+	case err != nil:
+		// Don't lock users out while the API server is unreachable, or if
+		// their token's scopes can't read the user. Requests are proxied with
+		// the token, which the API server still checks. The resolver logged
+		// the error.
+	default:
+		user.ID = info.UID
+		user.Username = info.Name
+		user.Groups = info.Groups
+	}
+	return user, nil
+}
+
+// encodeSessionCookie encrypts the access token for the session cookie so a
+// copy of the browser's cookies doesn't yield a usable cluster token.
+func encodeSessionCookie(token string, keys *KeySet) string {
+	if keys == nil {
+		return token
+	}
+	sealed := keys.seal([]byte(token), []byte(openshiftSessionCookieName))
+	return base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+func decodeSessionCookie(value string, keys *KeySet) (string, error) {
+	if keys == nil {
+		return value, nil
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return "", fmt.Errorf("malformed session cookie: %v", err)
+	}
+	token, err := keys.open(sealed, []byte(openshiftSessionCookieName))
+	if err != nil {
+		return "", fmt.Errorf("invalid session cookie: %v", err)
+	}
+	return string(token), nil
 }
 
 func (o *openShiftAuth) getKubeAdminLogoutURL() string {
@@ -0,0 +1,533 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// testAPIServer serves the OpenShift user and OAuth access token APIs for a
+// fixed set of tokens.
+type testAPIServer struct {
+	server *httptest.Server
+
+	mux      sync.Mutex
+	lookups  int
+	down     bool
+	revoked  map[string]bool
+	tokenMap map[string]string
+	// forbidden are the tokens that may not read their user.
+	forbidden map[string]bool
+	// failDeletes is the number of access token deletions to fail.
+	failDeletes int
+	deletes     int
+}
+
+func newTestAPIServer() *testAPIServer {
+	s := &testAPIServer{
+		revoked: make(map[string]bool),
+		tokenMap: map[string]string{
+			"secret-access-token": "kube:admin",
+			"access-token":        "developer",
+		},
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.ServeHTTP))
+	return s
+}
+
+func (s *testAPIServer) Close() {
+	s.server.Close()
+}
+
+func (s *testAPIServer) resolver() *openShiftUserResolver {
+	return newOpenShiftUserResolver(s.server.URL, func() (*http.Client, error) {
+		return http.DefaultClient, nil
+	})
+}
+
+func (s *testAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, openShiftAccessTokensPath) && r.Method == http.MethodDelete {
+		s.deleteAccessToken(w, r)
+		return
+	}
+	if r.URL.Path != openShiftUserPath {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.lookups++
+	if s.down {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	name, ok := s.tokenMap[token]
+	if !ok || s.revoked[token] {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.forbidden[token] {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"kind": "User", "metadata": {"name": %q, "uid": "uid-%s"}, "groups": ["system:authenticated", "dev"]}`, name, name)
+}
+
+func (s *testAPIServer) deleteAccessToken(w http.ResponseWriter, r *http.Request) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.deletes++
+	if s.failDeletes > 0 {
+		s.failDeletes--
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if _, ok := s.tokenMap[token]; !ok || s.revoked[token] {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if strings.TrimPrefix(r.URL.Path, openShiftAccessTokensPath) != openShiftTokenName(token) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	s.revoked[token] = true
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, `{"kind": "Status", "status": "Success"}`)
+}
+
+func openShiftSessionRequest(t *testing.T, o *openShiftAuth, accessToken string) *http.Request {
+	w := httptest.NewRecorder()
+	if _, err := o.login(w, &oauth2.Token{AccessToken: accessToken}, nil); err != nil {
+		t.Fatalf("login error: %v", err)
+	}
+	r := httptest.NewRequest("GET", "/api/kubernetes/", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestOpenShiftEncryptedCookie(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{0x01}, encryptionKeySize)
+	newKey := bytes.Repeat([]byte{0x02}, encryptionKeySize)
+	oldKeys, err := NewKeySet(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotatedKeys, err := NewKeySet(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := newTestAPIServer()
+	defer api.Close()
+	o := &openShiftAuth{cookiePath: "/", cookieKeys: oldKeys, users: api.resolver()}
+	r := openShiftSessionRequest(t, o, "secret-access-token")
+
+	cookie, err := r.Cookie(openshiftSessionCookieName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookie.Value == "secret-access-token" {
+		t.Fatal("access token stored in plain text")
+	}
+
+	// Cookies encrypted with an older key are accepted after rotation.
+	user, err := getOpenShiftUser(r, rotatedKeys, nil)
+	if err != nil {
+		t.Fatalf("getOpenShiftUser error: %v", err)
+	}
+	if user.Token != "secret-access-token" {
+		t.Errorf("token = %q, want %q", user.Token, "secret-access-token")
+	}
+
+	// Cookies are rejected once their key is removed.
+	newKeys, err := NewKeySet(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getOpenShiftUser(r, newKeys, nil); err == nil {
+		t.Error("expected cookie encrypted with a removed key to be rejected")
+	}
+
+	// Tampered and plain text cookies are rejected.
+	sealed, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed[len(sealed)-1] ^= 0xff
+	for _, value := range []string{base64.RawURLEncoding.EncodeToString(sealed), "secret-access-token"} {
+		tampered := httptest.NewRequest("GET", "/api/kubernetes/", nil)
+		tampered.AddCookie(&http.Cookie{Name: openshiftSessionCookieName, Value: value})
+		if _, err := getOpenShiftUser(tampered, rotatedKeys, nil); err == nil {
+			t.Errorf("expected cookie %q to be rejected", value)
+		}
+	}
+}
+
+func TestOpenShiftPlainCookie(t *testing.T) {
+	api := newTestAPIServer()
+	defer api.Close()
+	o := &openShiftAuth{cookiePath: "/", users: api.resolver()}
+	r := openShiftSessionRequest(t, o, "access-token")
+	user, err := getOpenShiftUser(r, nil, nil)
+	if err != nil {
+		t.Fatalf("getOpenShiftUser error: %v", err)
+	}
+	if user.Token != "access-token" {
+		t.Errorf("token = %q, want %q", user.Token, "access-token")
+	}
+}
+
+func TestOpenShiftUserIdentity(t *testing.T) {
+	api := newTestAPIServer()
+	defer api.Close()
+	users := api.resolver()
+	o := &openShiftAuth{cookiePath: "/", users: users}
+
+	w := httptest.NewRecorder()
+	ls, err := o.login(w, &oauth2.Token{AccessToken: "access-token"}, nil)
+	if err != nil {
+		t.Fatalf("login error: %v", err)
+	}
+	if ls.UserID != "uid-developer" || ls.Name != "developer" {
+		t.Errorf("login state identity = %q/%q, want uid-developer/developer", ls.UserID, ls.Name)
+	}
+
+	r := httptest.NewRequest("GET", "/api/kubernetes/", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	user, err := getOpenShiftUser(r, nil, users)
+	if err != nil {
+		t.Fatalf("getOpenShiftUser error: %v", err)
+	}
+	wantGroups := []string{"system:authenticated", "dev"}
+	if user.ID != "uid-developer" || user.Username != "developer" || !reflect.DeepEqual(user.Groups, wantGroups) {
+		t.Errorf("unexpected user: %+v", user)
+	}
+
+	// The identity looked up at login is cached for later requests.
+	if _, err := getOpenShiftUser(r, nil, users); err != nil {
+		t.Fatalf("getOpenShiftUser error: %v", err)
+	}
+	if api.lookups != 1 {
+		t.Errorf("lookups = %d, want 1", api.lookups)
+	}
+
+	// A transient API server failure doesn't log the user out.
+	users.forget("access-token")
+	api.mux.Lock()
+	api.down = true
+	api.mux.Unlock()
+	user, err = getOpenShiftUser(r, nil, users)
+	if err != nil {
+		t.Fatalf("getOpenShiftUser error while API server is down: %v", err)
+	}
+	if user.Token != "access-token" || user.Username != "" {
+		t.Errorf("expected token-only user while API server is down, got %+v", user)
+	}
+
+	// Tokens the API server rejects are rejected too, once the failed
+	// lookup is retried.
+	now := time.Now().Add(openShiftUserFailureTTL)
+	users.now = func() time.Time { return now }
+	api.mux.Lock()
+	api.down = false
+	api.revoked["access-token"] = true
+	api.mux.Unlock()
+	if _, err := getOpenShiftUser(r, nil, users); err == nil {
+		t.Fatal("expected revoked token to be rejected")
+	}
+}
+
+func TestOpenShiftUserCacheExpiry(t *testing.T) {
+	api := newTestAPIServer()
+	defer api.Close()
+	users := api.resolver()
+	now := time.Now()
+	users.now = func() time.Time { return now }
+
+	if _, err := users.resolve(context.Background(), "access-token"); err != nil {
+		t.Fatalf("resolve error: %v", err)
+	}
+	api.mux.Lock()
+	api.revoked["access-token"] = true
+	api.mux.Unlock()
+
+	if _, err := users.resolve(context.Background(), "access-token"); err != nil {
+		t.Errorf("expected cached identity, got error: %v", err)
+	}
+	now = now.Add(openShiftUserCacheTTL)
+	if _, err := users.resolve(context.Background(), "access-token"); err != errTokenRejected {
+		t.Errorf("resolve error = %v, want %v", err, errTokenRejected)
+	}
+	if _, err := users.resolve(context.Background(), "unknown-token"); err != errTokenRejected {
+		t.Errorf("resolve error = %v, want %v", err, errTokenRejected)
+	}
+}
+
+func TestOpenShiftUserLookupFailures(t *testing.T) {
+	api := newTestAPIServer()
+	defer api.Close()
+	api.forbidden = map[string]bool{"access-token": true}
+	users := api.resolver()
+	now := time.Now()
+	users.now = func() time.Time { return now }
+	lookups := func() int {
+		api.mux.Lock()
+		defer api.mux.Unlock()
+		return api.lookups
+	}
+
+	// Tokens that can't read their user, for example without the user:info
+	// scope, aren't looked up again on every request.
+	for i := 0; i < 3; i++ {
+		if _, err := users.resolve(context.Background(), "access-token"); err != errUserForbidden {
+			t.Fatalf("resolve error = %v, want %v", err, errUserForbidden)
+		}
+	}
+	if n := lookups(); n != 1 {
+		t.Errorf("lookups = %d, want 1", n)
+	}
+
+	// Other failures are retried sooner.
+	api.mux.Lock()
+	api.down = true
+	api.mux.Unlock()
+	for i := 0; i < 3; i++ {
+		if _, err := users.resolve(context.Background(), "other-token"); err == nil {
+			t.Fatal("expected an error while the API server is down")
+		}
+	}
+	if n := lookups(); n != 2 {
+		t.Errorf("lookups = %d, want 2", n)
+	}
+	api.mux.Lock()
+	api.down = false
+	api.mux.Unlock()
+	now = now.Add(openShiftUserFailureTTL)
+	if _, err := users.resolve(context.Background(), "other-token"); err != errTokenRejected {
+		t.Errorf("resolve error = %v, want %v", err, errTokenRejected)
+	}
+	if n := lookups(); n != 3 {
+		t.Errorf("lookups = %d, want 3", n)
+	}
+}
+
+func TestOpenShiftScopeProfiles(t *testing.T) {
+	p := &mockOpenShiftProvider{}
+	s := httptest.NewServer(http.HandlerFunc(p.handleDiscovery))
+	defer s.Close()
+	p.issuer = s.URL
+
+	a, err := NewAuthenticator(context.Background(), &Config{
+		AuthSource:    AuthSourceOpenShift,
+		ClientID:      "fake-client-id",
+		ClientSecret:  "fake-secret",
+		Scope:         []string{"user:full"},
+		ScopeProfiles: map[string][]string{"view": {"user:info", "user:check-access", "role:view:*"}},
+		RedirectURL:   "http://example.com/auth/callback",
+		IssuerURL:     p.issuer,
+		ErrorURL:      "http://example.com/error",
+		SuccessURL:    "http://example.com/",
+		CookiePath:    "/",
+		RefererPath:   "http://example.com/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a.ScopeProfiles(); !reflect.DeepEqual(got, []string{"view"}) {
+		t.Errorf("ScopeProfiles() = %v, want [view]", got)
+	}
+
+	tests := []struct {
+		query     string
+		wantScope string
+		wantError string
+	}{
+		{"", "user:full", ""},
+		{"?scopeProfile=view", "user:info user:check-access role:view:*", ""},
+		{"?scopeProfile=admin", "", errorInvalidScopeProfile},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		a.LoginFunc(w, httptest.NewRequest("GET", "/auth/login"+tt.query, nil))
+		loc, err := url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := loc.Query().Get("scope"); got != tt.wantScope {
+			t.Errorf("%q: scope = %q, want %q", tt.query, got, tt.wantScope)
+		}
+		if got := loc.Query().Get("error"); got != tt.wantError {
+			t.Errorf("%q: error = %q, want %q", tt.query, got, tt.wantError)
+		}
+	}
+
+	// Scope profiles restrict OpenShift tokens, so they aren't supported with OIDC.
+	if _, err := NewAuthenticator(context.Background(), &Config{
+		ScopeProfiles: map[string][]string{"view": {"openid"}},
+	}); err == nil {
+		t.Error("expected scope profiles to be rejected with OIDC")
+	}
+}
+
+func TestOpenShiftScopeProfileSession(t *testing.T) {
+	api := newTestAPIServer()
+	defer api.Close()
+	o := &openShiftAuth{cookiePath: "/", users: api.resolver()}
+
+	login := func(flow *loginFlow) (*loginState, *http.Request) {
+		w := httptest.NewRecorder()
+		ls, err := o.login(w, &oauth2.Token{AccessToken: "access-token"}, flow)
+		if err != nil {
+			t.Fatalf("login error: %v", err)
+		}
+		r := httptest.NewRequest("GET", "/api/kubernetes/", nil)
+		for _, c := range w.Result().Cookies() {
+			if c.MaxAge >= 0 {
+				r.AddCookie(c)
+			}
+		}
+		return ls, r
+	}
+
+	ls, r := login(&loginFlow{State: "state", ScopeProfile: "view"})
+	if got := ls.toLoginJSON().ScopeProfile; got != "view" {
+		t.Errorf("LoginJSON scope profile = %q, want %q", got, "view")
+	}
+	user, err := getOpenShiftUser(r, nil, o.users)
+	if err != nil {
+		t.Fatalf("getOpenShiftUser error: %v", err)
+	}
+	if user.ScopeProfile != "view" {
+		t.Errorf("user scope profile = %q, want %q", user.ScopeProfile, "view")
+	}
+
+	// Logging in again with the default scopes drops the profile.
+	_, r = login(&loginFlow{State: "state"})
+	if user, err = getOpenShiftUser(r, nil, o.users); err != nil {
+		t.Fatalf("getOpenShiftUser error: %v", err)
+	}
+	if user.ScopeProfile != "" {
+		t.Errorf("user scope profile = %q, want none", user.ScopeProfile)
+	}
+}
+
+func TestOpenShiftTokenName(t *testing.T) {
+	for token, want := range map[string]string{
+		"access-token":        "access-token",
+		"sha256~access-token": "sha256~Pxa-1wifRlPl7yG_0oJNfzqq7MelmOfonFgOFgapzFI",
+	} {
+		if got := openShiftTokenName(token); got != want {
+			t.Errorf("openShiftTokenName(%q) = %q, want %q", token, got, want)
+		}
+	}
+}
+
+func TestOpenShiftLogout(t *testing.T) {
+	defer func(backoff time.Duration) { tokenRevocationBackoff = backoff }(tokenRevocationBackoff)
+	tokenRevocationBackoff = time.Millisecond
+
+	api := newTestAPIServer()
+	defer api.Close()
+	api.tokenMap["sha256~access-token"] = "developer"
+	o := &openShiftAuth{cookiePath: "/", users: api.resolver(), apiServerURL: api.server.URL, k8sClient: http.DefaultClient}
+
+	logout := func(r *http.Request) (*httptest.ResponseRecorder, openShiftLogoutResponse) {
+		w := httptest.NewRecorder()
+		o.logout(w, r)
+		var resp openShiftLogoutResponse
+		if w.Code != http.StatusNoContent {
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w, resp
+	}
+
+	// Temporary failures are retried.
+	api.failDeletes = tokenRevocationAttempts - 1
+	r := openShiftSessionRequest(t, o, "sha256~access-token")
+	w, resp := logout(r)
+	if w.Code != http.StatusOK || !resp.TokenRevoked {
+		t.Fatalf("logout = %d %+v, want the token revoked", w.Code, resp)
+	}
+	if !api.revoked["sha256~access-token"] {
+		t.Error("access token was not deleted")
+	}
+	if api.deletes != tokenRevocationAttempts {
+		t.Errorf("deletes = %d, want %d", api.deletes, tokenRevocationAttempts)
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Value != "" {
+			t.Errorf("cookie %s was not deleted", c.Name)
+		}
+	}
+
+	// If the token can't be revoked the session is kept.
+	api.deletes = 0
+	api.failDeletes = tokenRevocationAttempts
+	r = openShiftSessionRequest(t, o, "access-token")
+	w, resp = logout(r)
+	if w.Code != http.StatusBadGateway || resp.TokenRevoked || resp.Message == "" {
+		t.Errorf("failed logout = %d %+v, want an error", w.Code, resp)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("session cookies deleted although the token was not revoked")
+	}
+	if api.revoked["access-token"] {
+		t.Error("access token deleted")
+	}
+
+	// A token the API server no longer accepts needs no revoking.
+	api.revoked["access-token"] = true
+	if w, resp := logout(r); w.Code != http.StatusOK || !resp.TokenRevoked {
+		t.Errorf("logout with revoked token = %d %+v, want success", w.Code, resp)
+	}
+
+	if w, _ := logout(httptest.NewRequest("POST", "/auth/logout", nil)); w.Code != http.StatusNoContent {
+		t.Errorf("logout without session: status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
+
+func TestOpenShiftLoginAdmission(t *testing.T) {
+	api := newTestAPIServer()
+	defer api.Close()
+	o := &openShiftAuth{
+		cookiePath:   "/",
+		users:        api.resolver(),
+		admission:    &AdmissionRules{DeniedGroups: []string{"dev"}},
+		apiServerURL: api.server.URL,
+		k8sClient:    http.DefaultClient,
+	}
+
+	w := httptest.NewRecorder()
+	_, err := o.login(w, &oauth2.Token{AccessToken: "access-token"}, nil)
+	if _, ok := err.(*accessDeniedError); !ok {
+		t.Fatalf("login error = %v, want access denied", err)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("denied user got session cookies")
+	}
+	if !api.revoked["access-token"] {
+		t.Error("access token of denied user was not revoked")
+	}
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// requestHeaderAuth trusts the identity a front proxy puts in request
+// headers, like the API server's request header authenticator. The headers
+// are only trusted on connections presenting a client certificate signed by
+// the front proxy CA, so clients can't set them themselves.
+type requestHeaderAuth struct {
+	// mu guards roots, the front proxy CA, which is replaced when it's
+	// rotated.
+	mu    sync.RWMutex
+	roots *x509.CertPool
+	// allowedNames are the common names the front proxy's certificate may
+	// have. Any name is allowed if empty.
+	allowedNames []string
+	// usernameHeaders are checked in order for the username.
+	usernameHeaders []string
+	// groupHeaders hold the user's groups, one per header value.
+	groupHeaders []string
+}
+
+func newRequestHeaderAuth(c *Config) (*requestHeaderAuth, error) {
+	if c.RequestHeaderClientCA == "" {
+		return nil, errors.New("request header authentication requires a front proxy CA")
+	}
+	if len(c.RequestHeaderUsernameHeaders) == 0 {
+		return nil, errors.New("request header authentication requires a username header")
+	}
+	data, err := ioutil.ReadFile(c.RequestHeaderClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("load front proxy CA file %s: %v", c.RequestHeaderClientCA, err)
+	}
+	h := &requestHeaderAuth{
+		allowedNames:    c.RequestHeaderAllowedNames,
+		usernameHeaders: c.RequestHeaderUsernameHeaders,
+		groupHeaders:    c.RequestHeaderGroupHeaders,
+	}
+	if err := h.setRoots(data); err != nil {
+		return nil, fmt.Errorf("file %s: %v", c.RequestHeaderClientCA, err)
+	}
+	return h, nil
+}
+
+func (h *requestHeaderAuth) setRoots(caPEM []byte) error {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return errors.New("contained no CA data")
+	}
+	h.mu.Lock()
+	h.roots = roots
+	h.mu.Unlock()
+	return nil
+}
+
+// SetRequestHeaderClientCA replaces the front proxy CA, for example after it
+// was rotated. It must be the same bundle the listener verifies client
+// certificates against. It does nothing for other auth sources.
+func (a *Authenticator) SetRequestHeaderClientCA(caPEM []byte) error {
+	if a.requestHeader == nil {
+		return nil
+	}
+	return a.requestHeader.setRoots(caPEM)
+}
+
+func (h *requestHeaderAuth) identify(r *http.Request) (*identity, error) {
+	if err := h.verifyProxy(r); err != nil {
+		return nil, err
+	}
+
+	var username string
+	for _, name := range h.usernameHeaders {
+		if username = r.Header.Get(name); username != "" {
+			break
+		}
+	}
+	if username == "" {
+		return nil, fmt.Errorf("no username in headers %s", strings.Join(h.usernameHeaders, ", "))
+	}
+	var groups []string
+	for _, name := range h.groupHeaders {
+		groups = append(groups, r.Header[http.CanonicalHeaderKey(name)]...)
+	}
+	return &identity{username: username, groups: groups}, nil
+}
+
+// verifyProxy checks that r was sent by the front proxy. The listener may
+// trust other client CAs too, so the certificate is verified again against
+// the front proxy CA alone.
+func (h *requestHeaderAuth) verifyProxy(r *http.Request) error {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return errors.New("no front proxy client certificate")
+	}
+	cert := r.TLS.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, c := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	h.mu.RLock()
+	roots := h.roots
+	h.mu.RUnlock()
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return fmt.Errorf("verify front proxy client certificate: %v", err)
+	}
+	if len(h.allowedNames) == 0 {
+		return nil
+	}
+	for _, name := range h.allowedNames {
+		if cert.Subject.CommonName == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("front proxy client certificate common name %q is not allowed", cert.Subject.CommonName)
+}
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRequestHeaderAuthentication(t *testing.T) {
+	proxyCA := newTestCA(t, "front-proxy")
+	rotatedProxyCA := newTestCA(t, "front-proxy-rotated")
+	userCA := newTestCA(t, "users")
+
+	dir, err := ioutil.TempDir("", "bridge-request-header")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "front-proxy-ca.crt")
+	if err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: proxyCA.cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewAuthenticator(context.Background(), &Config{
+		AuthSource:                   AuthSourceRequestHeader,
+		ImpersonatorToken:            "console-sa-token",
+		RedirectURL:                  "https://example.com/auth/callback",
+		ErrorURL:                     "https://example.com/error",
+		SuccessURL:                   "https://example.com/",
+		RefererPath:                  "https://example.com/",
+		CookiePath:                   "/api/",
+		RequestHeaderClientCA:        caFile,
+		RequestHeaderAllowedNames:    []string{"oauth2-proxy"},
+		RequestHeaderUsernameHeaders: []string{"X-Remote-User", "X-Forwarded-User"},
+		RequestHeaderGroupHeaders:    []string{"X-Remote-Group"},
+	})
+	if err != nil {
+		t.Fatalf("NewAuthenticator error: %v", err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := a.Authenticate(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(user)
+	}))
+	// The listener trusts client certificates of users too, as it would with
+	// --tls-client-ca-file, so the authenticator must not rely on it.
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(proxyCA.cert)
+	clientCAs.AddCert(userCA.cert)
+	// The listener has picked up the rotated front proxy CA already.
+	clientCAs.AddCert(rotatedProxyCA.cert)
+	srv.TLS = &tls.Config{ClientCAs: clientCAs, ClientAuth: tls.VerifyClientCertIfGiven}
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(header http.Header, certs ...tls.Certificate) (*User, int) {
+		client := srv.Client()
+		transport := client.Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = certs
+		client.Transport = transport
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = header
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, resp.StatusCode
+		}
+		var user User
+		if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+			t.Fatal(err)
+		}
+		return &user, resp.StatusCode
+	}
+
+	proxyCert := proxyCA.issue(t, pkix.Name{CommonName: "oauth2-proxy"}, x509.ExtKeyUsageClientAuth)
+	header := http.Header{
+		"X-Forwarded-User": {"alice"},
+		"X-Remote-Group":   {"ops", "dev"},
+	}
+	user, status := get(header, proxyCert)
+	if status != http.StatusOK {
+		t.Fatalf("front proxy request: status %d", status)
+	}
+	want := &User{ID: "alice", Username: "alice", Groups: []string{"ops", "dev"}, Token: "console-sa-token", Impersonate: true}
+	if !reflect.DeepEqual(user, want) {
+		t.Errorf("user = %+v, want %+v", user, want)
+	}
+
+	header = http.Header{"X-Remote-User": {"alice"}, "X-Forwarded-User": {"bob"}}
+	if user, _ := get(header, proxyCert); user == nil || user.Username != "alice" {
+		t.Errorf("user = %+v, want the first username header to win", user)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		certs  []tls.Certificate
+	}{
+		{"no certificate", http.Header{"X-Remote-User": {"mallory"}}, nil},
+		{"user certificate", http.Header{"X-Remote-User": {"mallory"}}, []tls.Certificate{userCA.issue(t, pkix.Name{CommonName: "oauth2-proxy"}, x509.ExtKeyUsageClientAuth)}},
+		{"name not allowed", http.Header{"X-Remote-User": {"mallory"}}, []tls.Certificate{proxyCA.issue(t, pkix.Name{CommonName: "other-proxy"}, x509.ExtKeyUsageClientAuth)}},
+		{"no username", http.Header{"X-Remote-Group": {"ops"}}, []tls.Certificate{proxyCert}},
+	} {
+		if _, status := get(tc.header, tc.certs...); status != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want %d", tc.name, status, http.StatusUnauthorized)
+		}
+	}
+
+	rotatedProxyCert := rotatedProxyCA.issue(t, pkix.Name{CommonName: "oauth2-proxy"}, x509.ExtKeyUsageClientAuth)
+	if _, status := get(header, rotatedProxyCert); status != http.StatusUnauthorized {
+		t.Errorf("before reloading the front proxy CA: status = %d, want %d", status, http.StatusUnauthorized)
+	}
+	if err := a.SetRequestHeaderClientCA([]byte("not a CA")); err == nil {
+		t.Error("expected an error reloading a front proxy CA without certificates")
+	}
+	if err := a.SetRequestHeaderClientCA(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rotatedProxyCA.cert.Raw})); err != nil {
+		t.Fatal(err)
+	}
+	if _, status := get(header, rotatedProxyCert); status != http.StatusOK {
+		t.Errorf("after reloading the front proxy CA: status = %d, want %d", status, http.StatusOK)
+	}
+	if _, status := get(header, proxyCert); status != http.StatusUnauthorized {
+		t.Errorf("with the replaced front proxy CA: status = %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestRequestHeaderConfig(t *testing.T) {
+	for _, c := range []*Config{
+		{AuthSource: AuthSourceRequestHeader, ImpersonatorToken: "token", RequestHeaderUsernameHeaders: []string{"X-Remote-User"}},
+		{AuthSource: AuthSourceRequestHeader, ImpersonatorToken: "token", RequestHeaderClientCA: "/does/not/exist", RequestHeaderUsernameHeaders: []string{"X-Remote-User"}},
+		{AuthSource: AuthSourceRequestHeader, ImpersonatorToken: "token", RequestHeaderClientCA: "/does/not/exist"},
+	} {
+		if _, err := NewAuthenticator(context.Background(), c); err == nil {
+			t.Errorf("NewAuthenticator(%+v) succeeded, want error", c)
+		}
+	}
+}
@@ -191,6 +191,35 @@ func TestRedirectAuthError(t *testing.T) {
 
 const validReferer string = "https://example.com/asdf/"
 
+// testConfig returns a Config with the console URLs tests share. Tests set
+// the fields of the auth source they exercise on top.
+func testConfig() *Config {
+	return &Config{
+		RedirectURL: "http://example.com/auth/callback",
+		ErrorURL:    "http://example.com/error",
+		SuccessURL:  "http://example.com/",
+		CookiePath:  "/",
+		RefererPath: "http://example.com/",
+	}
+}
+
+// testOIDCConfig returns a testConfig logging users in with p.
+func testOIDCConfig(p *testOIDCProvider) *Config {
+	c := testConfig()
+	c.IssuerURL = p.issuer()
+	c.ClientID = p.clientID
+	c.ClientSecret = "fake-secret"
+	return c
+}
+
+func newTestAuthenticator(t *testing.T, c *Config) *Authenticator {
+	a, err := NewAuthenticator(context.Background(), c)
+	if err != nil {
+		t.Fatalf("NewAuthenticator error: %v", err)
+	}
+	return a
+}
+
 func makeAuthenticator() (*Authenticator, error) {
 	errURL := "https://example.com/error"
 	sucURL := "https://example.com/success"
@@ -262,53 +291,3 @@ func TestReferer(t *testing.T) {
 	testReferer(t, "🍆🍆🍆🍆🍆🍆", false)
 	testReferer(t, "https://google.com/asdf/", false)
 }
-
-func testCSRF(t *testing.T, token string, cookie string, accept bool) {
-	a, err := makeAuthenticator()
-	if err != nil {
-		t.Fatal(err)
-		return
-	}
-
-	r, err := http.NewRequest("POST", "/some-path", nil)
-
-	if err != nil {
-		t.Fatal(err)
-		return
-	}
-
-	if len(cookie) > 0 {
-		r.Header.Set(CSRFHeader, token)
-		r.AddCookie(&http.Cookie{
-			Name:     CSRFCookieName,
-			Value:    cookie,
-			MaxAge:   1000000,
-			HttpOnly: true,
-			Path:     a.cookiePath,
-		})
-	}
-
-	err = a.VerifyCSRFToken(r)
-
-	if err != nil && accept {
-		t.Errorf("Unexpected error for CSRF `%v//%v`:\n%v", token, cookie, err)
-		return
-	}
-
-	if err == nil && !accept {
-		t.Errorf("Unexpected pass  for CSRF `%v//%v`:\n%v", token, cookie, err)
-	}
-
-	if accept {
-		t.Logf("CSRF accepted `%v` / `%v`", cookie, token)
-	} else {
-		t.Logf("CSRF rejected `%v` / `%v`", cookie, token)
-	}
-}
-func TestCSRF(t *testing.T) {
-	testCSRF(t, "a", "a", true)
-	testCSRF(t, "a", "b", false)
-	testCSRF(t, "a", "", false)
-	testCSRF(t, "", "b", false)
-	testCSRF(t, "", "", false)
-}
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+)
+
+// x509Identity returns the user of the client certificate r was sent with,
+// which the console's TLS listener verified against the client CA bundle. The
+// certificate's common name is the username and its organizations are the
+// groups, like the API server's own x509 authenticator.
+func x509Identity(r *http.Request) (*identity, error) {
+	// VerifiedChains is only set if the listener verified the certificate
+	// against the client CA bundle.
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, errors.New("no verified client certificate")
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	if cert.Subject.CommonName == "" {
+		return nil, errors.New("client certificate has no common name")
+	}
+	// The user is logged in for as long as their certificate is valid.
+	expires := cert.NotAfter
+	return &identity{
+		username: cert.Subject.CommonName,
+		groups:   cert.Subject.Organization,
+		expires:  &expires,
+	}, nil
+}
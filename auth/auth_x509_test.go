@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issue(t *testing.T, subject pkix.Name, usage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestX509Authentication(t *testing.T) {
+	c := testConfig()
+	c.AuthSource = AuthSourceX509
+	c.ImpersonatorToken = "console-sa-token"
+	a := newTestAuthenticator(t, c)
+	ca := newTestCA(t, "users")
+	otherCA := newTestCA(t, "other")
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := a.Authenticate(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(user)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	srv.TLS = &tls.Config{ClientCAs: clientCAs, ClientAuth: tls.VerifyClientCertIfGiven}
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(certs ...tls.Certificate) (*User, int, error) {
+		client := srv.Client()
+		transport := client.Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = certs
+		client.Transport = transport
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, resp.StatusCode, nil
+		}
+		var user User
+		return &user, resp.StatusCode, json.NewDecoder(resp.Body).Decode(&user)
+	}
+
+	cert := ca.issue(t, pkix.Name{CommonName: "alice", Organization: []string{"dev", "ops"}}, x509.ExtKeyUsageClientAuth)
+	user, status, err := get(cert)
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("trusted certificate: status %d, err %v", status, err)
+	}
+	want := &User{ID: "alice", Username: "alice", Groups: []string{"dev", "ops"}, Token: "console-sa-token", Impersonate: true}
+	if !reflect.DeepEqual(user, want) {
+		t.Errorf("user = %+v, want %+v", user, want)
+	}
+	a.SetImpersonatorToken("rotated-sa-token")
+	if user, _, err := get(cert); err != nil || user == nil || user.Token != "rotated-sa-token" {
+		t.Errorf("after rotating the token: user = %+v, err %v, want the rotated token", user, err)
+	}
+
+	if _, status, err := get(); err != nil || status != http.StatusUnauthorized {
+		t.Errorf("no certificate: status %d, err %v, want %d", status, err, http.StatusUnauthorized)
+	}
+	if _, status, err := get(ca.issue(t, pkix.Name{Organization: []string{"ops"}}, x509.ExtKeyUsageClientAuth)); err != nil || status != http.StatusUnauthorized {
+		t.Errorf("certificate without common name: status %d, err %v, want %d", status, err, http.StatusUnauthorized)
+	}
+	// Certificates the listener can't verify either fail the TLS handshake or
+	// aren't sent at all, if the client sees their CA isn't accepted.
+	if _, status, err := get(otherCA.issue(t, pkix.Name{CommonName: "mallory"}, x509.ExtKeyUsageClientAuth)); err == nil && status == http.StatusOK {
+		t.Error("certificate from an untrusted CA was accepted")
+	}
+	if _, status, err := get(ca.issue(t, pkix.Name{CommonName: "server"}, x509.ExtKeyUsageServerAuth)); err == nil && status == http.StatusOK {
+		t.Error("certificate without client auth usage was accepted")
+	}
+}
+
+func TestX509Login(t *testing.T) {
+	c := testConfig()
+	c.AuthSource = AuthSourceX509
+	c.ImpersonatorToken = "console-sa-token"
+	a := newTestAuthenticator(t, c)
+
+	w := httptest.NewRecorder()
+	a.LoginFunc(w, httptest.NewRequest("GET", "/auth/login?then=/k8s/cluster/nodes", nil))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("login status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if got, want := w.Header().Get("Location"), "http://example.com/auth/callback?then=%2Fk8s%2Fcluster%2Fnodes"; got != want {
+		t.Errorf("login redirects to %s, want %s", got, want)
+	}
+
+	ca := newTestCA(t, "users")
+	r := httptest.NewRequest("GET", "/auth/callback?then=/k8s/cluster/nodes", nil)
+	leaf := ca.issue(t, pkix.Name{CommonName: "alice", Organization: []string{"ops"}}, x509.ExtKeyUsageClientAuth)
+	cert, err := x509.ParseCertificate(leaf.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert, ca.cert}}}
+
+	var (
+		loginInfo  LoginJSON
+		successURL string
+	)
+	w = httptest.NewRecorder()
+	a.CallbackFunc(func(l LoginJSON, u string, _ http.ResponseWriter) { loginInfo, successURL = l, u })(w, r)
+	if successURL != "http://example.com/k8s/cluster/nodes" {
+		t.Errorf("success URL = %q, want the return path", successURL)
+	}
+	if loginInfo.UserID != "alice" || loginInfo.Name != "alice" || !reflect.DeepEqual(loginInfo.Groups, []string{"ops"}) {
+		t.Errorf("login info = %+v", loginInfo)
+	}
+
+	status, err := a.SessionStatus(r)
+	if err != nil || status.AuthSource != "x509" || status.Expires == nil || !status.Expires.Equal(cert.NotAfter) {
+		t.Errorf("SessionStatus = %+v, %v, want x509 expiring with the certificate", status, err)
+	}
+
+	w = httptest.NewRecorder()
+	a.CallbackFunc(func(LoginJSON, string, http.ResponseWriter) { t.Error("login succeeded without a certificate") })(w, httptest.NewRequest("GET", "/auth/callback", nil))
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("callback without certificate: status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+}
+
+func TestSetAuthHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Impersonate-User", "system:admin")
+	h.Add("Impersonate-Group", "system:masters")
+	h.Set("Impersonate-Extra-Scopes", "all")
+	h.Set("Sec-Websocket-Protocol", "base64.binary.k8s.io, Impersonate-User.c3lzdGVtOmFkbWlu")
+
+	// Users with their own token may only impersonate others through the
+	// console, which sends the headers itself.
+	user := &User{Username: "developer", Token: "user-token"}
+	user.SetAuthHeaders(h)
+	want := http.Header{
+		"Authorization":          {"Bearer user-token"},
+		"Sec-Websocket-Protocol": {"base64.binary.k8s.io"},
+	}
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("headers = %v, want %v", h, want)
+	}
+	user.Impersonating = &Impersonation{
+		User:   "bob",
+		Groups: []string{"ops", "dev"},
+		Extra:  map[string][]string{"scopes": {"view", "edit"}},
+	}
+	h.Set("Impersonate-User", "system:admin")
+	user.SetAuthHeaders(h)
+	want = http.Header{
+		"Authorization":            {"Bearer user-token"},
+		"Impersonate-User":         {"bob"},
+		"Impersonate-Group":        {"ops", "dev"},
+		"Impersonate-Extra-Scopes": {"view", "edit"},
+		"Sec-Websocket-Protocol":   {"base64.binary.k8s.io"},
+	}
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("headers = %v, want %v", h, want)
+	}
+
+	h.Set("Impersonate-User", "system:admin")
+	h.Set("Sec-Websocket-Protocol", "base64.binary.k8s.io, Impersonate-User.c3lzdGVtOmFkbWlu")
+	user = &User{Username: "alice", Groups: []string{"ops", "dev"}, Token: "console-sa-token", Impersonate: true}
+	user.SetAuthHeaders(h)
+	want = http.Header{
+		"Authorization":          {"Bearer console-sa-token"},
+		"Impersonate-User":       {"alice"},
+		"Impersonate-Group":      {"ops", "dev"},
+		"Sec-Websocket-Protocol": {"base64.binary.k8s.io"},
+	}
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("headers = %v, want %v", h, want)
+	}
+}
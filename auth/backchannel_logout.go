@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	oidc "github.com/coreos/go-oidc"
+)
+
+const (
+	backChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+	// logoutTokenMaxAge is how old a logout token may be, since they're not
+	// required to expire.
+	logoutTokenMaxAge = 5 * time.Minute
+)
+
+// logoutClaims are the claims of a back-channel logout token, besides the
+// ones the verifier checks.
+type logoutClaims struct {
+	Subject string                     `json:"sub"`
+	SID     string                     `json:"sid"`
+	Events  map[string]json.RawMessage `json:"events"`
+}
+
+// verifyLogoutToken verifies a logout token as described by OpenID Connect
+// Back-Channel Logout.
+func (o *oidcAuth) verifyLogoutToken(rawToken string) (*logoutClaims, error) {
+	ctx := oidc.ClientContext(context.Background(), o.client)
+	token, err := o.logoutVerifier.Verify(ctx, rawToken)
+	if err != nil {
+		return nil, err
+	}
+	var c logoutClaims
+	if err := token.Claims(&c); err != nil {
+		return nil, fmt.Errorf("parsing claims: %v", err)
+	}
+	if _, ok := c.Events[backChannelLogoutEvent]; !ok {
+		return nil, errors.New("not a logout token: missing back-channel logout event")
+	}
+	// A nonce means it's an ID token, which mustn't be accepted as a
+	// logout token.
+	if token.Nonce != "" {
+		return nil, errors.New("logout token must not have a nonce")
+	}
+	if c.Subject == "" && c.SID == "" {
+		return nil, errors.New("logout token has neither sub nor sid")
+	}
+	if token.IssuedAt.IsZero() || time.Since(token.IssuedAt) > logoutTokenMaxAge {
+		return nil, fmt.Errorf("logout token issued at %v is too old", token.IssuedAt)
+	}
+	return &c, nil
+}
+
+// BackChannelLogoutFunc handles OIDC back-channel logout requests, which
+// providers send when a user logs out of them elsewhere. Every session of the
+// user, or only those of the provider session named by the token's sid, is
+// deleted.
+func (a *Authenticator) BackChannelLogoutFunc(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Invalid method: only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.sessions == nil {
+		http.NotFound(w, r)
+		return
+	}
+	rawToken := r.PostFormValue("logout_token")
+	if rawToken == "" {
+		http.Error(w, "Missing logout_token", http.StatusBadRequest)
+		return
+	}
+
+	// The issuer and audience of the token tell which provider sent it.
+	err := errors.New("no OIDC provider")
+	unreachable := false
+	for _, p := range a.providers {
+		if !p.isReady() {
+			unreachable = true
+			continue
+		}
+		_, lm := p.authFunc()
+		o, ok := lm.(*oidcAuth)
+		if !ok {
+			continue
+		}
+		var c *logoutClaims
+		if c, err = o.verifyLogoutToken(rawToken); err != nil {
+			continue
+		}
+		n := a.deleteProviderSessions(o.provider, c)
+		log.Infof("back-channel logout of user %q ended %d sessions", c.Subject, n)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if unreachable {
+		// The token may be from a provider that can't be verified yet, so
+		// have the provider retry.
+		http.Error(w, "Identity provider not reachable", http.StatusServiceUnavailable)
+		return
+	}
+	log.Infof("rejected back-channel logout: %v", err)
+	http.Error(w, "Invalid logout_token", http.StatusBadRequest)
+}
+
+// deleteProviderSessions deletes the sessions of provider matching the logout
+// token claims c and returns how many it deleted.
+func (a *Authenticator) deleteProviderSessions(provider string, c *logoutClaims) int {
+	n := 0
+	for _, ls := range a.sessions.listSessions() {
+		if ls.provider != provider ||
+			(c.Subject != "" && ls.UserID != c.Subject) ||
+			(c.SID != "" && ls.providerSID != c.SID) {
+			continue
+		}
+		if err := a.RevokeSession(ls.sessionID); err == nil {
+			n++
+		}
+	}
+	return n
+}
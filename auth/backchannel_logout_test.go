@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func (p *testOIDCProvider) logoutToken(claims map[string]interface{}) string {
+	c := map[string]interface{}{
+		"iss":    p.issuer(),
+		"aud":    p.clientID,
+		"iat":    time.Now().Unix(),
+		"jti":    "logout-1",
+		"events": map[string]interface{}{backChannelLogoutEvent: map[string]interface{}{}},
+	}
+	for k, v := range claims {
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+	}
+	return p.sign(c)
+}
+
+func backChannelLogout(a *Authenticator, token string) int {
+	body := url.Values{"logout_token": {token}}.Encode()
+	r := httptest.NewRequest("POST", "/auth/backchannel-logout", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	a.BackChannelLogoutFunc(w, r)
+	return w.Code
+}
+
+func TestBackChannelLogout(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	defer p.Close()
+	a := newTestAuthenticator(t, testOIDCConfig(p))
+
+	p.sid = "provider-session-1"
+	first := loginSession(t, a, p)
+	p.sid = "provider-session-2"
+	second := loginSession(t, a, p)
+
+	for _, tc := range []struct {
+		name   string
+		claims map[string]interface{}
+	}{
+		{"ID token", map[string]interface{}{"sid": "provider-session-1", "events": nil}},
+		{"nonce", map[string]interface{}{"sid": "provider-session-1", "nonce": "abc"}},
+		{"no sub or sid", nil},
+		{"wrong audience", map[string]interface{}{"sid": "provider-session-1", "aud": "other-client"}},
+		{"wrong issuer", map[string]interface{}{"sid": "provider-session-1", "iss": "https://other.example.com"}},
+		{"too old", map[string]interface{}{"sid": "provider-session-1", "iat": time.Now().Add(-time.Hour).Unix()}},
+		{"no iat", map[string]interface{}{"sid": "provider-session-1", "iat": nil}},
+	} {
+		if code := backChannelLogout(a, p.logoutToken(tc.claims)); code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", tc.name, code, http.StatusBadRequest)
+		}
+	}
+	if code := backChannelLogout(a, ""); code != http.StatusBadRequest {
+		t.Errorf("missing token: status = %d, want %d", code, http.StatusBadRequest)
+	}
+	if _, err := a.Authenticate(first); err != nil {
+		t.Fatalf("session ended by an invalid logout token: %v", err)
+	}
+
+	// A sid only ends the sessions of that provider session.
+	if code := backChannelLogout(a, p.logoutToken(map[string]interface{}{"sid": "provider-session-1"})); code != http.StatusOK {
+		t.Fatalf("logout by sid: status = %d, want %d", code, http.StatusOK)
+	}
+	if _, err := a.Authenticate(first); err == nil {
+		t.Error("session still valid after back-channel logout")
+	}
+	if _, err := a.Authenticate(second); err != nil {
+		t.Errorf("session of another provider session was ended: %v", err)
+	}
+
+	// A sub alone ends every session of the user.
+	if code := backChannelLogout(a, p.logoutToken(map[string]interface{}{"sub": p.subject})); code != http.StatusOK {
+		t.Fatalf("logout by sub: status = %d, want %d", code, http.StatusOK)
+	}
+	if _, err := a.Authenticate(second); err == nil {
+		t.Error("session still valid after back-channel logout of the user")
+	}
+
+	w := httptest.NewRecorder()
+	a.BackChannelLogoutFunc(w, httptest.NewRequest("GET", "/auth/backchannel-logout", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csrfTokenTTL is how long a CSRF token is valid. Tokens of active users are
+// renewed once half of it has passed.
+var csrfTokenTTL = 24 * time.Hour
+
+// csrfBinding returns what the CSRF tokens of u are bound to: their session,
+// their access token for auth sources without server-side sessions, or their
+// identity for users the console impersonates.
+func csrfBinding(u *User) string {
+	switch {
+	case u.SessionID != "":
+		return "session:" + u.SessionID
+	case u.Impersonate:
+		return "user:" + u.Username
+	default:
+		return "token:" + u.Token
+	}
+}
+
+// newCSRFToken returns a token of the form <expiry>.<nonce>.<signature>. The
+// signature covers the binding, which isn't part of the token, so a token
+// is only good for the session it was issued to.
+func (a *Authenticator) newCSRFToken(binding string, now time.Time) string {
+	payload := strconv.FormatInt(now.Add(csrfTokenTTL).Unix(), 10) + "." + randomString(16)
+	sig := a.csrfKeys.sign([]byte(payload + "." + binding))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// checkCSRFToken returns when token expires if it was issued for binding and
+// hasn't expired yet.
+func (a *Authenticator) checkCSRFToken(token, binding string, now time.Time) (time.Time, error) {
+	i := strings.LastIndex(token, ".")
+	if i < 0 {
+		return time.Time{}, errors.New("malformed CSRF token")
+	}
+	payload, encodedSig := token[:i], token[i+1:]
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("malformed CSRF token: %v", err)
+	}
+	if !a.csrfKeys.verify([]byte(payload+"."+binding), sig) {
+		return time.Time{}, errors.New("CSRF token is invalid or belongs to another session")
+	}
+	unix, err := strconv.ParseInt(strings.SplitN(payload, ".", 2)[0], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("malformed CSRF token: %v", err)
+	}
+	exp := time.Unix(unix, 0)
+	if !now.Before(exp) {
+		return time.Time{}, errors.New("CSRF token expired")
+	}
+	return exp, nil
+}
+
+// setCSRFCookie issues a new CSRF token bound to binding.
+func (a *Authenticator) setCSRFCookie(w http.ResponseWriter, binding string) {
+	path := a.refererURL.Path
+	if path == "" {
+		path = "/"
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:  CSRFCookieName,
+		Value: a.newCSRFToken(binding, time.Now()),
+		// JS needs to read this Cookie
+		HttpOnly: false,
+		Path:     path,
+		Secure:   a.secureCookies,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+func csrfRequest(token string) *http.Request {
+	r := httptest.NewRequest("POST", "/api/kubernetes/", nil)
+	if token != "" {
+		r.Header.Set(CSRFHeader, token)
+	}
+	return r
+}
+
+func TestCSRF(t *testing.T) {
+	a, err := makeAuthenticator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := &User{SessionID: "session-1"}
+	token := a.newCSRFToken(csrfBinding(alice), time.Now())
+
+	if err := a.VerifyCSRFToken(csrfRequest(token), alice); err != nil {
+		t.Errorf("valid token rejected: %v", err)
+	}
+	ws := httptest.NewRequest("GET", "/api/kubernetes/?"+url.Values{CSRFQueryParam: {token}}.Encode(), nil)
+	ws.Header.Set("Upgrade", "websocket")
+	if err := a.VerifyCSRFToken(ws, alice); err != nil {
+		t.Errorf("valid token in query rejected: %v", err)
+	}
+	ws = httptest.NewRequest("GET", "/api/kubernetes/", nil)
+	ws.Header.Set("Upgrade", "websocket")
+	if err := a.VerifyCSRFToken(ws, alice); err == nil {
+		t.Error("websocket without token accepted")
+	}
+	// Safe methods can't change anything, so a token that expired, for
+	// example while the browser was closed, doesn't fail them.
+	for _, method := range []string{"GET", "HEAD", "OPTIONS"} {
+		if err := a.VerifyCSRFToken(httptest.NewRequest(method, "/api/kubernetes/", nil), alice); err != nil {
+			t.Errorf("%s without token rejected: %v", method, err)
+		}
+	}
+
+	for name, tc := range map[string]struct {
+		token string
+		user  *User
+	}{
+		"missing token":     {"", alice},
+		"other session":     {token, &User{SessionID: "session-2"}},
+		"other token":       {token, &User{Token: "session-1"}},
+		"expired":           {a.newCSRFToken(csrfBinding(alice), time.Now().Add(-csrfTokenTTL)), alice},
+		"tampered":          {"9" + token, alice},
+		"unsigned":          {"a", alice},
+		"signed by another": {(&Authenticator{csrfKeys: newRandomKeySet()}).newCSRFToken(csrfBinding(alice), time.Now()), alice},
+	} {
+		if err := a.VerifyCSRFToken(csrfRequest(tc.token), tc.user); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+
+	// Impersonated users share the console's token, so their tokens are bound
+	// to their identity instead.
+	bob := &User{Username: "bob", Token: "console-token", Impersonate: true}
+	carol := &User{Username: "carol", Token: "console-token", Impersonate: true}
+	if err := a.VerifyCSRFToken(csrfRequest(a.newCSRFToken(csrfBinding(bob), time.Now())), carol); err == nil {
+		t.Error("token of another impersonated user accepted")
+	}
+}
+
+func TestCSRFKeyRotation(t *testing.T) {
+	oldKey, newKey := make([]byte, encryptionKeySize), make([]byte, encryptionKeySize)
+	newKey[0] = 1
+	oldKeys, err := NewKeySet(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := NewKeySet(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &User{SessionID: "session-1"}
+	token := (&Authenticator{csrfKeys: oldKeys}).newCSRFToken(csrfBinding(user), time.Now())
+	a := &Authenticator{csrfKeys: rotated}
+	if err := a.VerifyCSRFToken(csrfRequest(token), user); err != nil {
+		t.Errorf("token signed with the previous key rejected: %v", err)
+	}
+}
+
+func TestFileSessionStoreCSRFKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge-sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Replicas sharing the session directory must accept each other's
+	// tokens, and the tokens must survive a restart.
+	replica := func() *Authenticator {
+		ss, err := NewFileSessionStore(dir, newTestKeySet(t), 10, 0)
+		if err != nil {
+			t.Fatalf("NewFileSessionStore error: %v", err)
+		}
+		c := testConfig()
+		c.SessionStore = ss
+		a, err := newUnstartedAuthenticator(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	user := &User{SessionID: "session-1"}
+	token := replica().newCSRFToken(csrfBinding(user), time.Now())
+	if err := replica().VerifyCSRFToken(csrfRequest(token), user); err != nil {
+		t.Errorf("token of another replica rejected: %v", err)
+	}
+}
+
+func TestSetCSRFCookie(t *testing.T) {
+	a, err := makeAuthenticator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &User{SessionID: "session-1"}
+
+	csrfCookie := func(r *http.Request) *http.Cookie {
+		w := httptest.NewRecorder()
+		a.SetCSRFCookie(w, r, user)
+		for _, c := range w.Result().Cookies() {
+			if c.Name == CSRFCookieName {
+				return c
+			}
+		}
+		return nil
+	}
+	withCookie := func(token string) *http.Request {
+		r := csrfRequest("")
+		r.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: token})
+		return r
+	}
+
+	c := csrfCookie(csrfRequest(""))
+	if c == nil {
+		t.Fatal("no token issued")
+	}
+	if err := a.VerifyCSRFToken(csrfRequest(c.Value), user); err != nil {
+		t.Errorf("issued token rejected: %v", err)
+	}
+	if c.SameSite != http.SameSiteStrictMode || c.HttpOnly || c.Path != "/asdf/" {
+		t.Errorf("cookie = %+v, want a SameSite=Strict cookie readable by JS on the base path", c)
+	}
+
+	if c := csrfCookie(withCookie(c.Value)); c != nil {
+		t.Error("fresh token was replaced")
+	}
+	halfExpired := a.newCSRFToken(csrfBinding(user), time.Now().Add(-csrfTokenTTL/2-time.Minute))
+	if c := csrfCookie(withCookie(halfExpired)); c == nil {
+		t.Error("expiring token was not renewed")
+	}
+	otherSession := a.newCSRFToken(csrfBinding(&User{SessionID: "session-2"}), time.Now())
+	if c := csrfCookie(withCookie(otherSession)); c == nil {
+		t.Error("token of another session was not replaced")
+	}
+}
+
+func TestLoginRotatesCSRFToken(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	defer p.Close()
+	a := newTestAuthenticator(t, testOIDCConfig(p))
+
+	q, cookie := startLogin(t, a)
+	p.codes["good-code"] = testAuthCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	w, ok := finishLogin(a, "good-code", q.Get("state"), cookie)
+	if !ok {
+		t.Fatal("login failed")
+	}
+
+	r := httptest.NewRequest("GET", "/api/kubernetes/", nil)
+	var token string
+	for _, c := range w.Result().Cookies() {
+		switch c.Name {
+		case openshiftSessionCookieName:
+			r.AddCookie(c)
+		case CSRFCookieName:
+			token = c.Value
+		}
+	}
+	user, err := a.Authenticate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.VerifyCSRFToken(csrfRequest(token), user); err != nil {
+		t.Errorf("token issued on login rejected: %v", err)
+	}
+}
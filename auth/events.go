@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AuthEventType says what happened in an AuthEvent.
+type AuthEventType string
+
+const (
+	EventLoginSucceeded AuthEventType = "login_succeeded"
+	EventLoginFailed    AuthEventType = "login_failed"
+	EventLogout         AuthEventType = "logout"
+	EventLogoutFailed   AuthEventType = "logout_failed"
+	// EventAuthenticationFailed is a request without valid credentials.
+	EventAuthenticationFailed AuthEventType = "authentication_failed"
+	EventOriginRejected       AuthEventType = "origin_rejected"
+	EventCSRFRejected         AuthEventType = "csrf_rejected"
+	EventSessionIdle          AuthEventType = "session_idle"
+	EventImpersonationStarted AuthEventType = "impersonation_started"
+	EventImpersonationStopped AuthEventType = "impersonation_stopped"
+)
+
+// AuthEvent is a security relevant authentication event, such as a login or
+// a rejected request.
+type AuthEvent struct {
+	Time time.Time     `json:"time"`
+	Type AuthEventType `json:"type"`
+	// User is the username, if known.
+	User string `json:"user,omitempty"`
+	// Provider is the name of the identity provider, or of the auth source
+	// if there's a single, unnamed one.
+	Provider  string `json:"provider,omitempty"`
+	ClientIP  string `json:"clientIP,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+	// ErrorCode is the error the user was sent to the error page with, for
+	// failures.
+	ErrorCode string `json:"errorCode,omitempty"`
+	Message   string `json:"message,omitempty"`
+	// Impersonation is who User started or stopped impersonating.
+	Impersonation *Impersonation `json:"impersonation,omitempty"`
+}
+
+// EventLog writes AuthEvents as JSON, one per line. A nil *EventLog discards
+// them.
+type EventLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewEventLog returns an EventLog writing to w.
+func NewEventLog(w io.Writer) *EventLog {
+	return &EventLog{enc: json.NewEncoder(w)}
+}
+
+func (l *EventLog) write(e *AuthEvent) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(e); err != nil {
+		log.Errorf("failed to write auth event %s: %v", e.Type, err)
+	}
+}
+
+// RecordEvent writes e to the event log, filling in the time and the client
+// details of r.
+func (a *Authenticator) RecordEvent(r *http.Request, e AuthEvent) {
+	if a.events == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	if e.Provider == "" {
+		e.Provider = a.source.String()
+	}
+	if r != nil {
+		e.ClientIP = clientIP(r)
+		e.UserAgent = r.UserAgent()
+	}
+	a.events.write(&e)
+}
+
+// loginFailed records a failed login with provider p and sends the user to
+// the error page with authErr.
+func (a *Authenticator) loginFailed(w http.ResponseWriter, r *http.Request, p *provider, authErr string, err error) {
+	e := AuthEvent{Type: EventLoginFailed, ErrorCode: authErr}
+	if p != nil {
+		e.Provider = p.name
+	}
+	if err != nil {
+		e.Message = err.Error()
+	}
+	a.RecordEvent(r, e)
+	a.redirectAuthError(w, authErr, err)
+}
+
+// statusWriter remembers the status code written to it.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// clientIP returns the address of the peer r came from. Forwarding headers
+// are ignored, since clients can set them to anything.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func readEvents(t *testing.T, buf *bytes.Buffer) []AuthEvent {
+	var events []AuthEvent
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var e AuthEvent
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestAuthEvents(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	defer p.Close()
+	var buf bytes.Buffer
+	c := testOIDCConfig(p)
+	c.EventLog = NewEventLog(&buf)
+	a := newTestAuthenticator(t, c)
+
+	q, cookie := startLogin(t, a)
+	if _, ok := finishLogin(a, "bad-code", q.Get("state"), cookie); ok {
+		t.Fatal("login with a bad code succeeded")
+	}
+	r := loginSession(t, a, p)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("User-Agent", "test-browser")
+	a.LogoutFunc(httptest.NewRecorder(), r)
+
+	events := readEvents(t, &buf)
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3: %+v", len(events), events)
+	}
+	failed, login, logout := events[0], events[1], events[2]
+	if failed.Type != EventLoginFailed || failed.ErrorCode != errorInvalidCode || failed.User != "" {
+		t.Errorf("failed login event = %+v", failed)
+	}
+	if login.Type != EventLoginSucceeded || login.User != "user-id" || login.Provider != "oidc" {
+		t.Errorf("login event = %+v", login)
+	}
+	if logout.Type != EventLogout || logout.User != "user-id" || logout.ClientIP != "192.0.2.1" || logout.UserAgent != "test-browser" {
+		t.Errorf("logout event = %+v", logout)
+	}
+	for _, e := range events {
+		if e.Time.IsZero() {
+			t.Errorf("event %s has no time", e.Type)
+		}
+	}
+
+	// Without an event log nothing is recorded.
+	a = newTestAuthenticator(t, testOIDCConfig(p))
+	a.RecordEvent(r, AuthEvent{Type: EventLogout})
+}
@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// openshiftActivityCookieName holds the signed time of the user's last
+	// activity with OpenShift, which has no server-side sessions.
+	openshiftActivityCookieName = "openshift-session-activity"
+
+	// maxActivityInterval is how often, at most, a session's last activity
+	// is recorded, so busy sessions aren't written on every request.
+	maxActivityInterval = time.Minute
+
+	// BackgroundRequestHeader marks requests the UI makes on its own, such
+	// as polling, rather than for something the user did.
+	BackgroundRequestHeader = "X-Console-Background"
+)
+
+// ErrSessionIdle is returned by CheckActivity when the session was unused
+// for longer than the idle timeout.
+var ErrSessionIdle = errors.New("session is idle")
+
+// activityInterval returns how often activity is recorded for idleTimeout.
+// Activity between recordings is lost, so it's kept short compared to the
+// timeout.
+func activityInterval(idleTimeout time.Duration) time.Duration {
+	interval := idleTimeout / 10
+	if interval > maxActivityInterval {
+		interval = maxActivityInterval
+	}
+	return interval
+}
+
+// CheckActivity enforces the idle timeout on the session r authenticates
+// with. If the session was unused for longer than the timeout, it's ended and
+// ErrSessionIdle returned. Otherwise r is recorded as activity if active is
+// set and it's a request of the user, see isUserActivity.
+//
+// Client certificate and front proxy users have no console session, so they
+// never idle out.
+func (a *Authenticator) CheckActivity(w http.ResponseWriter, r *http.Request, active bool) error {
+	if a.idleTimeout <= 0 || a.impersonator != nil {
+		return nil
+	}
+	active = active && isUserActivity(r)
+	if a.sessions != nil {
+		return a.checkSessionActivity(r, active)
+	}
+	if o := a.openShiftAuth(); o != nil {
+		return o.checkActivity(w, r, active, a.activityInterval)
+	}
+	return nil
+}
+
+// isUserActivity reports whether r counts as activity for the idle timeout.
+// Requests the UI marks with BackgroundRequestHeader, such as polling, would
+// otherwise keep an unattended session alive. So would websockets and
+// watches, which stay open for as long as a page is.
+func isUserActivity(r *http.Request) bool {
+	if r.Header.Get(BackgroundRequestHeader) != "" {
+		return false
+	}
+	return !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") && r.URL.Query().Get("watch") != "true"
+}
+
+// openShiftAuth returns the OpenShift login method, or nil if the auth source
+// isn't OpenShift or it wasn't reached yet.
+func (a *Authenticator) openShiftAuth() *openShiftAuth {
+	if len(a.providers) == 0 || !a.Ready() {
+		return nil
+	}
+	_, lm := a.providers[0].authFunc()
+	o, _ := lm.(*openShiftAuth)
+	return o
+}
+
+func (a *Authenticator) checkSessionActivity(r *http.Request, active bool) error {
+	ls, err := getLoginState(a.sessions, r)
+	if err != nil {
+		return err
+	}
+	idle := ls.now().Sub(ls.lastActivity)
+	if idle > a.idleTimeout {
+		// Revoking also cancels the session's watches.
+		a.RevokeSession(ls.sessionID)
+		return ErrSessionIdle
+	}
+	if active && idle >= a.activityInterval {
+		if err := a.sessions.touchSession(ls.sessionToken, ls.now()); err != nil {
+			log.Errorf("failed to record activity of session %s: %v", ls.sessionID, err)
+		}
+	}
+	return nil
+}
+
+// expireIdleSessions revokes idle sessions until ctx is done. Sessions are
+// otherwise only found idle on their next request, and their watches could
+// go on indefinitely.
+func (a *Authenticator) expireIdleSessions(ctx context.Context) {
+	ticker := time.NewTicker(a.activityInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, ls := range a.sessions.listSessions() {
+			if ls.now().Sub(ls.lastActivity) > a.idleTimeout {
+				if err := a.RevokeSession(ls.sessionID); err == nil {
+					log.Infof("ended idle session %s of user %q", ls.sessionID, ls.UserID)
+				}
+			}
+		}
+	}
+}
+
+// checkActivity enforces the idle timeout with the activity cookie. An idle
+// user's access token is revoked and their cookies deleted.
+func (o *openShiftAuth) checkActivity(w http.ResponseWriter, r *http.Request, active bool, interval time.Duration) error {
+	session, err := r.Cookie(openshiftSessionCookieName)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	last, err := o.lastActivity(r, session.Value)
+	if err != nil || now.Sub(last) > o.idleTimeout {
+		if token, err := decodeSessionCookie(session.Value, o.cookieKeys); err == nil && token != "" {
+			if err := o.revokeToken(r.Context(), token); err != nil {
+				log.Errorf("failed to revoke access token of idle session: %v", err)
+			}
+			if o.users != nil {
+				o.users.forget(token)
+			}
+		}
+		o.deleteSessionCookies(w)
+		return ErrSessionIdle
+	}
+	if active && now.Sub(last) >= interval {
+		o.setActivityCookie(w, session.Value, now)
+	}
+	return nil
+}
+
+// lastActivity returns the time in the activity cookie of r. The cookie is
+// bound to the session cookie, so it can't be moved to another session. A
+// missing cookie expired with the idle timeout.
+func (o *openShiftAuth) lastActivity(r *http.Request, session string) (time.Time, error) {
+	cookie, err := r.Cookie(openshiftActivityCookieName)
+	if err != nil {
+		return time.Time{}, err
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("malformed activity cookie: %v", err)
+	}
+	data, err := o.cookieKeys.open(sealed, []byte(session))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid activity cookie: %v", err)
+	}
+	unix, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("malformed activity cookie: %v", err)
+	}
+	return time.Unix(unix, 0), nil
+}
+
+// setActivityCookie records at as the last activity of session. The cookie
+// expires with the idle timeout.
+func (o *openShiftAuth) setActivityCookie(w http.ResponseWriter, session string, at time.Time) {
+	sealed := o.cookieKeys.seal([]byte(strconv.FormatInt(at.Unix(), 10)), []byte(session))
+	http.SetCookie(w, &http.Cookie{
+		Name:     openshiftActivityCookieName,
+		Value:    base64.RawURLEncoding.EncodeToString(sealed),
+		MaxAge:   int(o.idleTimeout.Seconds()),
+		HttpOnly: true,
+		Path:     o.cookiePath,
+		Secure:   o.secureCookies,
+	})
+}
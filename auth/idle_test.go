@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func sessionToken(t *testing.T, r *http.Request) string {
+	cookie, err := r.Cookie(openshiftSessionCookieName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cookie.Value
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	defer p.Close()
+	c := testOIDCConfig(p)
+	c.IdleTimeout = time.Hour
+	a := newTestAuthenticator(t, c)
+
+	r := loginSession(t, a, p)
+	token := sessionToken(t, r)
+	if err := a.CheckActivity(httptest.NewRecorder(), r, true); err != nil {
+		t.Fatalf("new session: %v", err)
+	}
+	status, err := a.SessionStatus(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.IdleExpires == nil || time.Until(*status.IdleExpires) <= 59*time.Minute {
+		t.Errorf("idle expiry = %v, want in an hour", status.IdleExpires)
+	}
+
+	// Background requests don't count as activity.
+	halfIdle := time.Now().Add(-30 * time.Minute)
+	if err := a.sessions.touchSession(token, halfIdle); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.CheckActivity(httptest.NewRecorder(), r, false); err != nil {
+		t.Fatalf("half idle session: %v", err)
+	}
+	if last := a.sessions.getSession(token).lastActivity; !last.Equal(halfIdle) {
+		t.Errorf("background request recorded as activity at %v", last)
+	}
+	if err := a.CheckActivity(httptest.NewRecorder(), r, true); err != nil {
+		t.Fatalf("half idle session: %v", err)
+	}
+	if last := a.sessions.getSession(token).lastActivity; time.Since(last) > time.Minute {
+		t.Errorf("last activity = %v, want now", last)
+	}
+
+	if err := a.sessions.touchSession(token, time.Now().Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.SessionStatus(r); err != ErrSessionNotFound {
+		t.Errorf("idle session status error = %v, want %v", err, ErrSessionNotFound)
+	}
+	if err := a.CheckActivity(httptest.NewRecorder(), r, true); err != ErrSessionIdle {
+		t.Fatalf("idle session error = %v, want %v", err, ErrSessionIdle)
+	}
+	if _, err := a.Authenticate(r); err == nil {
+		t.Error("idle session still valid")
+	}
+}
+
+func TestPollingIdlesOut(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	defer p.Close()
+	c := testOIDCConfig(p)
+	c.IdleTimeout = 500 * time.Millisecond
+	a := newTestAuthenticator(t, c)
+
+	polling := loginSession(t, a, p)
+	polling.Header.Set(BackgroundRequestHeader, "true")
+	watching := loginSession(t, a, p)
+	watching.URL.RawQuery = "watch=true"
+	active := loginSession(t, a, p)
+
+	for start := time.Now(); time.Since(start) < 3*c.IdleTimeout; time.Sleep(c.IdleTimeout / 10) {
+		for _, r := range []*http.Request{polling, watching, active} {
+			a.CheckActivity(httptest.NewRecorder(), r, true)
+		}
+	}
+	if _, err := a.Authenticate(polling); err == nil {
+		t.Error("polled session still valid")
+	}
+	if _, err := a.Authenticate(watching); err == nil {
+		t.Error("watched session still valid")
+	}
+	if _, err := a.Authenticate(active); err != nil {
+		t.Errorf("active session ended: %v", err)
+	}
+}
+
+func TestExpireIdleSessions(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	defer p.Close()
+	a := newTestAuthenticator(t, testOIDCConfig(p))
+	a.idleTimeout = time.Hour
+	a.activityInterval = time.Millisecond
+
+	idle := loginSession(t, a, p)
+	active := loginSession(t, a, p)
+	if err := a.sessions.touchSession(sessionToken(t, idle), time.Now().Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// A watch of the idle session is canceled with it.
+	watch, done := a.TrackRequest(&User{SessionID: sessionID(sessionToken(t, idle))}, idle)
+	defer done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.expireIdleSessions(ctx)
+
+	select {
+	case <-watch.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch of idle session not canceled")
+	}
+	if _, err := a.Authenticate(idle); err == nil {
+		t.Error("idle session still valid")
+	}
+	if _, err := a.Authenticate(active); err != nil {
+		t.Errorf("active session ended: %v", err)
+	}
+}
+
+func TestOpenShiftIdleTimeout(t *testing.T) {
+	defer func(backoff time.Duration) { tokenRevocationBackoff = backoff }(tokenRevocationBackoff)
+	tokenRevocationBackoff = time.Millisecond
+
+	api := newTestAPIServer()
+	defer api.Close()
+	keys := newTestKeySet(t)
+	o := &openShiftAuth{
+		cookiePath:   "/",
+		cookieKeys:   keys,
+		users:        api.resolver(),
+		idleTimeout:  time.Hour,
+		apiServerURL: api.server.URL,
+		k8sClient:    http.DefaultClient,
+	}
+
+	r := openShiftSessionRequest(t, o, "access-token")
+	if err := o.checkActivity(httptest.NewRecorder(), r, true, time.Minute); err != nil {
+		t.Fatalf("new session: %v", err)
+	}
+
+	// withActivity returns a request for the session of r with an activity
+	// cookie for at, bound to session.
+	withActivity := func(session string, at time.Time) *http.Request {
+		req := httptest.NewRequest("GET", "/api/kubernetes/", nil)
+		req.AddCookie(&http.Cookie{Name: openshiftSessionCookieName, Value: sessionToken(t, r)})
+		if session != "" {
+			w := httptest.NewRecorder()
+			o.setActivityCookie(w, session, at)
+			req.AddCookie(w.Result().Cookies()[0])
+		}
+		return req
+	}
+
+	halfIdle := withActivity(sessionToken(t, r), time.Now().Add(-30*time.Minute))
+	w := httptest.NewRecorder()
+	if err := o.checkActivity(w, halfIdle, false, time.Minute); err != nil {
+		t.Fatalf("half idle session: %v", err)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("background request recorded as activity")
+	}
+	w = httptest.NewRecorder()
+	if err := o.checkActivity(w, halfIdle, true, time.Minute); err != nil {
+		t.Fatalf("half idle session: %v", err)
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != openshiftActivityCookieName {
+		t.Errorf("activity not recorded, cookies: %v", cookies)
+	}
+
+	for name, idle := range map[string]*http.Request{
+		"no activity cookie":          withActivity("", time.Now()),
+		"other session's cookie":      withActivity("other-session", time.Now()),
+		"activity older than timeout": withActivity(sessionToken(t, r), time.Now().Add(-2*time.Hour)),
+	} {
+		w := httptest.NewRecorder()
+		if err := o.checkActivity(w, idle, true, time.Minute); err != ErrSessionIdle {
+			t.Errorf("%s: error = %v, want %v", name, err, ErrSessionIdle)
+		}
+		for _, c := range w.Result().Cookies() {
+			if c.Value != "" {
+				t.Errorf("%s: cookie %s was not deleted", name, c.Name)
+			}
+		}
+	}
+	if !api.revoked["access-token"] {
+		t.Error("access token of idle session was not revoked")
+	}
+
+	// Logging in with a fresh token starts a new activity period.
+	api.revoked = make(map[string]bool)
+	r = openShiftSessionRequest(t, o, "access-token")
+	if _, err := o.lastActivity(r, sessionToken(t, r)); err != nil {
+		t.Errorf("login did not set the activity cookie: %v", err)
+	}
+	if _, err := NewAuthenticator(context.Background(), &Config{AuthSource: AuthSourceOpenShift, IdleTimeout: time.Hour}); err == nil {
+		t.Error("idle timeout without cookie keys accepted")
+	}
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// impersonationCookieName holds the sealed Impersonation of a session.
+const impersonationCookieName = "impersonation"
+
+// ErrImpersonationNotSupported is returned by StartImpersonation for users
+// the console already impersonates itself. The API server doesn't allow
+// impersonating twice.
+var ErrImpersonationNotSupported = errors.New("impersonation is not supported for users authenticated by the console")
+
+// DefaultGroupImpersonationUser is the default user sent with
+// impersonations of groups only, see Config.GroupImpersonationUser.
+const DefaultGroupImpersonationUser = "system:console:group-member"
+
+// Impersonation is who a user acts as in requests to the API server, which
+// checks they're allowed to. User may be empty to impersonate groups only.
+type Impersonation struct {
+	User   string   `json:"user"`
+	Groups []string `json:"groups,omitempty"`
+	// Extra are extra fields of the impersonated user, for example scopes.
+	Extra map[string][]string `json:"extra,omitempty"`
+}
+
+// impersonationExtraKeyRegexp matches extra keys that can be sent as part of
+// a header name.
+var impersonationExtraKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._%/-]*$`)
+
+// Validate returns an error if imp can't be sent to the API server.
+func (imp *Impersonation) Validate() error {
+	if imp.User == "" && len(imp.Groups) == 0 {
+		return errors.New("user or groups are required")
+	}
+	if !validHeaderValue(imp.User) {
+		return fmt.Errorf("invalid user %q", imp.User)
+	}
+	for _, g := range imp.Groups {
+		if g == "" || !validHeaderValue(g) {
+			return fmt.Errorf("invalid group %q", g)
+		}
+	}
+	for key, values := range imp.Extra {
+		if !impersonationExtraKeyRegexp.MatchString(key) {
+			return fmt.Errorf("invalid extra key %q", key)
+		}
+		for _, v := range values {
+			if !validHeaderValue(v) {
+				return fmt.Errorf("invalid value %q of extra %q", v, key)
+			}
+		}
+	}
+	return nil
+}
+
+func validHeaderValue(v string) bool {
+	return !strings.ContainsAny(v, "\r\n\x00")
+}
+
+// ImpersonatedUser returns the user the API server is asked to impersonate
+// for imp, groupUser if imp only impersonates groups.
+func (imp *Impersonation) ImpersonatedUser(groupUser string) string {
+	if imp.User != "" {
+		return imp.User
+	}
+	if groupUser == "" {
+		return DefaultGroupImpersonationUser
+	}
+	return groupUser
+}
+
+// setHeaders sets the impersonation headers for imp. groupUser is sent as
+// the user if imp only impersonates groups.
+func (imp *Impersonation) setHeaders(h http.Header, groupUser string) {
+	user := imp.ImpersonatedUser(groupUser)
+	h.Set("Impersonate-User", user)
+	for _, group := range imp.Groups {
+		h.Add("Impersonate-Group", group)
+	}
+	for key, values := range imp.Extra {
+		for _, v := range values {
+			h.Add("Impersonate-Extra-"+key, v)
+		}
+	}
+}
+
+// GroupImpersonationUser returns the user sent with impersonations of groups
+// only, see Config.GroupImpersonationUser.
+func (a *Authenticator) GroupImpersonationUser() string {
+	return a.groupImpersonationUser
+}
+
+// StartImpersonation makes the requests of user's session impersonate imp.
+// Sessions in the session store keep it with the rest of their state. Other
+// sessions keep it in a cookie sealed to the session, so it can't be altered
+// or used by another session.
+func (a *Authenticator) StartImpersonation(w http.ResponseWriter, r *http.Request, user *User, imp *Impersonation) error {
+	if user.Impersonate {
+		return ErrImpersonationNotSupported
+	}
+	if err := imp.Validate(); err != nil {
+		return err
+	}
+	if a.hasStoredSession(user) {
+		if err := a.setSessionImpersonation(r, imp); err != nil {
+			return err
+		}
+	} else {
+		data, err := json.Marshal(imp)
+		if err != nil {
+			return err
+		}
+		sealed := a.csrfKeys.seal(data, []byte(csrfBinding(user)))
+		a.setImpersonationCookie(w, base64.RawURLEncoding.EncodeToString(sealed), 0)
+	}
+
+	log.Infof("user %q started impersonating user %q, groups %v", user.Username, imp.User, imp.Groups)
+	a.RecordEvent(r, AuthEvent{Type: EventImpersonationStarted, User: user.Username, Impersonation: imp})
+	return nil
+}
+
+// StopImpersonation ends the impersonation of user's session, if any.
+func (a *Authenticator) StopImpersonation(w http.ResponseWriter, r *http.Request, user *User) {
+	imp, _ := a.Impersonation(r, user)
+	if a.hasStoredSession(user) {
+		if imp != nil {
+			if err := a.setSessionImpersonation(r, nil); err != nil {
+				log.Errorf("failed to stop impersonation of session %s: %v", user.SessionID, err)
+			}
+		}
+	} else {
+		a.setImpersonationCookie(w, "", -1)
+	}
+	if imp == nil {
+		return
+	}
+	log.Infof("user %q stopped impersonating user %q, groups %v", user.Username, imp.User, imp.Groups)
+	a.RecordEvent(r, AuthEvent{Type: EventImpersonationStopped, User: user.Username, Impersonation: imp})
+}
+
+// Impersonation returns who the session of user impersonates, or nil if
+// nobody.
+func (a *Authenticator) Impersonation(r *http.Request, user *User) (*Impersonation, error) {
+	if a.hasStoredSession(user) {
+		// Authenticate read it from the session.
+		return user.Impersonating, nil
+	}
+	cookie, err := r.Cookie(impersonationCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, nil
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil, fmt.Errorf("malformed impersonation cookie: %v", err)
+	}
+	data, err := a.csrfKeys.open(sealed, []byte(csrfBinding(user)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid impersonation cookie: %v", err)
+	}
+	var imp Impersonation
+	if err := json.Unmarshal(data, &imp); err != nil {
+		return nil, fmt.Errorf("malformed impersonation cookie: %v", err)
+	}
+	return &imp, nil
+}
+
+// hasStoredSession returns true if user authenticated with a session in the
+// session store.
+func (a *Authenticator) hasStoredSession(user *User) bool {
+	return a.sessions != nil && user.SessionID != ""
+}
+
+// setSessionImpersonation stores imp in the session of r.
+func (a *Authenticator) setSessionImpersonation(r *http.Request, imp *Impersonation) error {
+	ls, err := getLoginState(a.sessions, r)
+	if err != nil {
+		return err
+	}
+	// Replace rather than modify the state, which other requests may be
+	// reading.
+	updated := *ls
+	updated.impersonation = imp
+	return a.sessions.updateSession(&updated)
+}
+
+func (a *Authenticator) setImpersonationCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     impersonationCookieName,
+		Value:    value,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Path:     a.cookiePath,
+		Secure:   a.secureCookies,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
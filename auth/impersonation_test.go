@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestImpersonation(t *testing.T) {
+	a, err := makeAuthenticator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	a.events = NewEventLog(&buf)
+
+	user := &User{Username: "developer", SessionID: "session-1"}
+	imp := &Impersonation{
+		User:   "bob",
+		Groups: []string{"ops", "dev"},
+		Extra:  map[string][]string{"scopes": {"view"}},
+	}
+	w := httptest.NewRecorder()
+	if err := a.StartImpersonation(w, httptest.NewRequest("POST", "/api/console/impersonation", nil), user, imp); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Fatalf("cookies = %v, want one HttpOnly, SameSite=Strict impersonation cookie", cookies)
+	}
+	r := httptest.NewRequest("GET", "/api/kubernetes/", nil)
+	r.AddCookie(cookies[0])
+
+	got, err := a.Impersonation(r, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, imp) {
+		t.Errorf("impersonation = %+v, want %+v", got, imp)
+	}
+	if _, err := a.Impersonation(r, &User{Username: "developer", SessionID: "session-2"}); err == nil {
+		t.Error("impersonation of another session accepted")
+	}
+	if got, err := a.Impersonation(httptest.NewRequest("GET", "/api/kubernetes/", nil), user); got != nil || err != nil {
+		t.Errorf("without a cookie: impersonation = %v, error = %v, want none", got, err)
+	}
+
+	w = httptest.NewRecorder()
+	a.StopImpersonation(w, r, user)
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].Value != "" {
+		t.Errorf("impersonation cookie not deleted: %v", cookies)
+	}
+
+	events := readEvents(t, &buf)
+	if len(events) != 2 || events[0].Type != EventImpersonationStarted || events[1].Type != EventImpersonationStopped {
+		t.Fatalf("events = %+v, want impersonation started and stopped", events)
+	}
+	if events[0].User != "developer" || !reflect.DeepEqual(events[0].Impersonation, imp) {
+		t.Errorf("start event = %+v", events[0])
+	}
+
+	console := &User{Username: "alice", Token: "console-token", Impersonate: true}
+	if err := a.StartImpersonation(httptest.NewRecorder(), r, console, imp); err != ErrImpersonationNotSupported {
+		t.Errorf("impersonation by a user the console impersonates: error = %v, want %v", err, ErrImpersonationNotSupported)
+	}
+}
+
+func TestValidateImpersonation(t *testing.T) {
+	for _, tt := range []struct {
+		imp   Impersonation
+		valid bool
+	}{
+		{Impersonation{User: "bob"}, true},
+		{Impersonation{User: "system:serviceaccount:default:builder", Groups: []string{"system:serviceaccounts"}}, true},
+		{Impersonation{User: "bob", Extra: map[string][]string{"scopes.example.com/name": {"a", "b"}}}, true},
+		{Impersonation{Groups: []string{"ops"}}, true},
+		{Impersonation{}, false},
+		{Impersonation{Extra: map[string][]string{"scopes": {"view"}}}, false},
+		{Impersonation{User: "bob\r\nImpersonate-User: admin"}, false},
+		{Impersonation{User: "bob", Groups: []string{""}}, false},
+		{Impersonation{User: "bob", Extra: map[string][]string{"bad key": {"a"}}}, false},
+		{Impersonation{User: "bob", Extra: map[string][]string{"scopes": {"a\n"}}}, false},
+	} {
+		if err := tt.imp.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate(%+v) = %v, want valid = %v", tt.imp, err, tt.valid)
+		}
+	}
+}
+
+func TestImpersonationHeaders(t *testing.T) {
+	for _, tt := range []struct {
+		imp  Impersonation
+		want http.Header
+	}{
+		{
+			Impersonation{User: "bob", Groups: []string{"ops"}, Extra: map[string][]string{"scopes": {"view"}}},
+			http.Header{"Impersonate-User": {"bob"}, "Impersonate-Group": {"ops"}, "Impersonate-Extra-Scopes": {"view"}},
+		},
+		{
+			// The API server requires a user with groups.
+			Impersonation{Groups: []string{"ops", "dev"}},
+			http.Header{"Impersonate-User": {"system:console:groups"}, "Impersonate-Group": {"ops", "dev"}},
+		},
+	} {
+		h := http.Header{}
+		tt.imp.setHeaders(h, "system:console:groups")
+		if !reflect.DeepEqual(h, tt.want) {
+			t.Errorf("headers of %+v = %v, want %v", tt.imp, h, tt.want)
+		}
+	}
+}
+
+func TestSessionImpersonation(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	defer p.Close()
+	c := testOIDCConfig(p)
+	c.MaxSessionAge = time.Hour
+	c.GroupImpersonationUser = "system:console:groups"
+	a := newTestAuthenticator(t, c)
+	r := loginSession(t, a, p)
+	user, err := a.Authenticate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imp := &Impersonation{Groups: []string{"ops"}}
+	w := httptest.NewRecorder()
+	if err := a.StartImpersonation(w, r, user, imp); err != nil {
+		t.Fatal(err)
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("cookies = %v, want the impersonation kept in the session", cookies)
+	}
+	if user, err = a.Authenticate(r); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := a.Impersonation(r, user); err != nil || !reflect.DeepEqual(got, imp) {
+		t.Errorf("impersonation = %+v, error = %v, want %+v", got, err, imp)
+	}
+	h := http.Header{}
+	user.SetAuthHeaders(h)
+	if got := h.Get("Impersonate-User"); got != "system:console:groups" {
+		t.Errorf("Impersonate-User = %q, want the configured group impersonation user", got)
+	}
+
+	// Refreshing the session keeps the impersonation.
+	ls, err := getLoginState(a.sessions, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiring := *ls
+	expiring.exp = time.Now().Add(refreshBeforeExpiry / 2)
+	if err := a.sessions.updateSession(&expiring); err != nil {
+		t.Fatal(err)
+	}
+	if user, err = a.Authenticate(r); err != nil {
+		t.Fatal(err)
+	}
+	if p.refreshes != 1 {
+		t.Errorf("refreshes = %d, want 1", p.refreshes)
+	}
+	if !reflect.DeepEqual(user.Impersonating, imp) {
+		t.Errorf("impersonation after refresh = %+v, want %+v", user.Impersonating, imp)
+	}
+
+	a.StopImpersonation(httptest.NewRecorder(), r, user)
+	if user, err = a.Authenticate(r); err != nil {
+		t.Fatal(err)
+	}
+	if user.Impersonating != nil {
+		t.Errorf("impersonation after stopping = %+v, want none", user.Impersonating)
+	}
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+const encryptionKeySize = 32
+
+// KeySet is an ordered list of AES-256-GCM keys. The first key is used to
+// encrypt, while every key is tried when decrypting. This lets operators
+// rotate keys by prepending a new one and dropping the oldest once nothing
+// encrypted with it is still in use. Data is signed the same way, with
+// HMAC-SHA256 keys derived from the encryption keys.
+type KeySet struct {
+	aeads   []cipher.AEAD
+	macKeys [][]byte
+}
+
+// NewKeySet builds a KeySet from raw 32 byte keys, newest first.
+func NewKeySet(keys ...[]byte) (*KeySet, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("key set must contain at least one key")
+	}
+	ks := &KeySet{}
+	for i, key := range keys {
+		if len(key) != encryptionKeySize {
+			return nil, fmt.Errorf("key %d is %d bytes, expected %d", i, len(key), encryptionKeySize)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		ks.aeads = append(ks.aeads, aead)
+
+		// Don't use the same key for two algorithms.
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte("hmac-sha256"))
+		ks.macKeys = append(ks.macKeys, mac.Sum(nil))
+	}
+	return ks, nil
+}
+
+// newRandomKeySet returns a KeySet with a single random key.
+func newRandomKeySet() *KeySet {
+	key := make([]byte, encryptionKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		panic(fmt.Sprintf("FATAL ERROR: Unable to get random bytes for key: %v", err))
+	}
+	ks, err := NewKeySet(key)
+	if err != nil {
+		panic(err)
+	}
+	return ks
+}
+
+// LoadKeySet reads a KeySet from a file containing one base64 encoded key
+// per line, newest first. Blank lines and lines starting with '#' are
+// ignored, so the file can be mounted directly from a Secret.
+func LoadKeySet(path string) (*KeySet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file %s: %v", path, err)
+	}
+
+	var keys [][]byte
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("key file %s: invalid base64 key on line %d: %v", path, lineNum, err)
+		}
+		keys = append(keys, key)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("read key file %s: %v", path, err)
+	}
+
+	ks, err := NewKeySet(keys...)
+	if err != nil {
+		return nil, fmt.Errorf("key file %s: %v", path, err)
+	}
+	return ks, nil
+}
+
+// derive returns a KeySet of keys derived from those of ks for purpose, so
+// one configured key set can serve several purposes without reusing keys.
+func (ks *KeySet) derive(purpose string) *KeySet {
+	var keys [][]byte
+	for _, macKey := range ks.macKeys {
+		mac := hmac.New(sha256.New, macKey)
+		mac.Write([]byte(purpose))
+		keys = append(keys, mac.Sum(nil))
+	}
+	derived, err := NewKeySet(keys...)
+	if err != nil {
+		panic(err)
+	}
+	return derived
+}
+
+// seal encrypts and authenticates plaintext with the newest key. The
+// additional data is authenticated but not stored, and must be passed to
+// open unchanged.
+func (ks *KeySet) seal(plaintext, additionalData []byte) []byte {
+	aead := ks.aeads[0]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		panic(fmt.Sprintf("FATAL ERROR: Unable to get random bytes for nonce: %v", err))
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData)
+}
+
+// open decrypts data produced by seal with any key in the set.
+func (ks *KeySet) open(data, additionalData []byte) ([]byte, error) {
+	for _, aead := range ks.aeads {
+		if len(data) < aead.NonceSize() {
+			return nil, errors.New("ciphertext too short")
+		}
+		nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+		if plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData); err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, errors.New("unable to decrypt with any key in the key set")
+}
+
+// sign returns the HMAC of data with the newest key.
+func (ks *KeySet) sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, ks.macKeys[0])
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// verify reports whether sig is the HMAC of data with any key in the set.
+func (ks *KeySet) verify(data, sig []byte) bool {
+	for _, key := range ks.macKeys {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(data)
+		if hmac.Equal(mac.Sum(nil), sig) {
+			return true
+		}
+	}
+	return false
+}
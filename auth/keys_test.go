@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadKeySet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := base64.StdEncoding.EncodeToString(make([]byte, encryptionKeySize))
+	for _, tc := range []struct {
+		name    string
+		content string
+		keys    int
+		err     string
+	}{
+		{"keys", key + "\n" + key + "\n", 2, ""},
+		{"comments", "# rotated 2019-01-01\n\n" + key + "\n", 1, ""},
+		{"invalid key", "# newest first\n\n" + key + "\nnot base64!\n", 0, "line 4"},
+		{"empty", "# no keys\n", 0, "key"},
+	} {
+		path := filepath.Join(dir, tc.name)
+		if err := ioutil.WriteFile(path, []byte(tc.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		ks, err := LoadKeySet(path)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: error = %v, want one mentioning %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if len(ks.aeads) != tc.keys {
+			t.Errorf("%s: loaded %d keys, want %d", tc.name, len(ks.aeads), tc.keys)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	oidc "github.com/coreos/go-oidc"
+)
+
+// crossClientScopePrefix is the prefix of Dex's cross-client scopes, which
+// ask for ID tokens issued to another client too.
+const crossClientScopePrefix = "audience:server:client_id:"
+
+// OIDCCredentials are credentials issued to kubectl's own OAuth2 client, so
+// kubectl can authenticate to the API server and renew its ID token itself.
+type OIDCCredentials struct {
+	IssuerURL string
+	// IssuerCAPEM is the CA bundle of the issuer, or empty if it's trusted
+	// by the system roots.
+	IssuerCAPEM  []byte
+	ClientID     string
+	ClientSecret string
+	IDToken      string
+	// RefreshToken is empty if the provider didn't issue one.
+	RefreshToken string
+}
+
+// kubectlScopes returns the scopes kubectl's client asks for: the console's,
+// without cross-client scopes, and with offline_access, so kubectl gets a
+// refresh token.
+func kubectlScopes(scope []string) []string {
+	scopes := []string{}
+	offlineAccess := false
+	for _, s := range scope {
+		if strings.HasPrefix(s, crossClientScopePrefix) {
+			continue
+		}
+		if s == oidc.ScopeOfflineAccess {
+			offlineAccess = true
+		}
+		scopes = append(scopes, s)
+	}
+	if !offlineAccess {
+		scopes = append(scopes, oidc.ScopeOfflineAccess)
+	}
+	return scopes
+}
+
+// kubectlSession returns the OIDC session of r and the auth source of the
+// provider it was issued by, which must have a kubectl client.
+func (a *Authenticator) kubectlSession(r *http.Request) (*provider, *oidcAuth, *loginState, error) {
+	if a.sessions == nil {
+		return nil, nil, nil, ErrSessionsNotSupported
+	}
+	ls, err := getLoginState(a.sessions, r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := a.provider(ls.provider)
+	if p == nil {
+		return nil, nil, nil, fmt.Errorf("session was authenticated by unknown provider %q", ls.provider)
+	}
+	if !p.isReady() {
+		return nil, nil, nil, errStarting
+	}
+	_, lm := p.authFunc()
+	o := lm.(*oidcAuth)
+	if o.kubectl == nil {
+		return nil, nil, nil, fmt.Errorf("provider %q has no kubectl client", p.name)
+	}
+	return p, o, ls, nil
+}
+
+// kubectlCallbackPath scopes the state cookie of KubectlLogin to the kubectl
+// redirect URL.
+func (o *oidcAuth) kubectlCallbackPath() string {
+	if u, err := url.Parse(o.kubectl.RedirectURL); err == nil && u.Path != "" {
+		return u.Path
+	}
+	return "/"
+}
+
+// KubectlLogin sends the user of the OIDC session of r to log in to the
+// session's provider again, this time with kubectl's client. The provider
+// sends them back to the kubectl redirect URL, where KubectlCredentials
+// redeems the code. Users already logged in at the provider usually aren't
+// asked anything. Returns ErrSessionsNotSupported for auth sources without
+// OIDC sessions.
+func (a *Authenticator) KubectlLogin(w http.ResponseWriter, r *http.Request) error {
+	p, o, _, err := a.kubectlSession(r)
+	if err != nil {
+		return err
+	}
+	flow := newLoginFlow(a.pkce, a.nonce)
+	flow.Provider = p.name
+	if err := a.setLoginFlowCookie(w, flow, o.kubectlCallbackPath()); err != nil {
+		return err
+	}
+	http.Redirect(w, r, o.kubectl.AuthCodeURL(flow.State, flow.authCodeOptions()...), http.StatusSeeOther)
+	return nil
+}
+
+// KubectlCredentials redeems the authorization code the provider sent back
+// after KubectlLogin for credentials issued to kubectl. They must be the
+// session user's own.
+func (a *Authenticator) KubectlCredentials(w http.ResponseWriter, r *http.Request) (*OIDCCredentials, error) {
+	p, o, ls, err := a.kubectlSession(r)
+	if err != nil {
+		return nil, err
+	}
+	flow, err := a.getLoginFlow(r)
+	// The state is only good for one attempt.
+	a.clearLoginFlowCookie(w, o.kubectlCallbackPath())
+	if err != nil {
+		return nil, err
+	}
+
+	q := r.URL.Query()
+	if qErr := q.Get("error"); qErr != "" {
+		return nil, fmt.Errorf("provider returned error %q", qErr)
+	}
+	if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(flow.State)) != 1 || flow.Provider != p.name {
+		return nil, errors.New("state in url does not match state cookie")
+	}
+	code := q.Get("code")
+	if code == "" {
+		return nil, errors.New("missing auth code")
+	}
+
+	client := p.clientFunc()
+	if flow.Verifier != "" {
+		client = withCodeVerifier(client, flow.Verifier)
+	}
+	ctx := oidc.ClientContext(context.TODO(), client)
+	token, err := o.kubectl.Exchange(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("unable to verify auth code with issuer: %v", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response did not have an id_token field")
+	}
+	idToken, err := o.kubectlVerifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if flow.Nonce != "" &&
+		subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(flow.Nonce)) != 1 {
+		return nil, errors.New("ID token nonce does not match")
+	}
+	var c json.RawMessage
+	if err := idToken.Claims(&c); err != nil {
+		return nil, fmt.Errorf("parsing claims: %v", err)
+	}
+	kubectlState, err := o.claims.newLoginState(rawIDToken, []byte(c))
+	if err != nil {
+		return nil, err
+	}
+	// Someone else may have logged in at the provider meanwhile.
+	if kubectlState.UserID != ls.UserID {
+		return nil, fmt.Errorf("kubectl token subject %q does not match session subject %q", kubectlState.UserID, ls.UserID)
+	}
+
+	creds := &OIDCCredentials{
+		IssuerURL:    p.issuerURL,
+		ClientID:     o.kubectl.ClientID,
+		ClientSecret: o.kubectl.ClientSecret,
+		IDToken:      rawIDToken,
+		RefreshToken: token.RefreshToken,
+	}
+	if p.issuerCA != "" {
+		if creds.IssuerCAPEM, err = ioutil.ReadFile(p.issuerCA); err != nil {
+			return nil, fmt.Errorf("failed to read issuer CA: %v", err)
+		}
+	}
+	return creds, nil
+}
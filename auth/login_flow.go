@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// loginFlowTTL is how long a user has to complete a login at the provider.
+const loginFlowTTL = 10 * time.Minute
+
+// loginFlow is the state of a login in progress. It is kept in the state
+// cookie between LoginFunc and CallbackFunc so any replica can finish the
+// login.
+type loginFlow struct {
+	// State is sent to the provider and must come back unchanged.
+	State string `json:"state"`
+	// Verifier is the PKCE code verifier, or empty if PKCE is disabled.
+	// https://tools.ietf.org/html/rfc7636
+	Verifier string `json:"verifier,omitempty"`
+	// Nonce must match the nonce claim of the ID token, or is empty if
+	// nonces are disabled.
+	Nonce string `json:"nonce,omitempty"`
+	// Then is the path on the console to return to after logging in.
+	Then string `json:"then,omitempty"`
+	// Provider is the name of the provider the user is logging in with.
+	Provider string `json:"provider,omitempty"`
+	// ScopeProfile is the scope profile requested instead of the default
+	// scopes, if any.
+	ScopeProfile string `json:"scopeProfile,omitempty"`
+}
+
+func newLoginFlow(pkce, nonce bool) *loginFlow {
+	f := &loginFlow{State: randomURLString(32)}
+	if pkce {
+		f.Verifier = randomURLString(32)
+	}
+	if nonce {
+		f.Nonce = randomURLString(32)
+	}
+	return f
+}
+
+// authCodeOptions returns the extra authorization request parameters for f.
+func (f *loginFlow) authCodeOptions() []oauth2.AuthCodeOption {
+	var opts []oauth2.AuthCodeOption
+	if f.Verifier != "" {
+		opts = append(opts,
+			oauth2.SetAuthURLParam("code_challenge", pkceChallenge(f.Verifier)),
+			oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		)
+	}
+	if f.Nonce != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", f.Nonce))
+	}
+	return opts
+}
+
+// stateCookiePath scopes the state cookie to the provider's callback, which
+// the session cookie path usually doesn't cover.
+func (p *provider) stateCookiePath() string {
+	if p.callbackPath == "" {
+		return "/"
+	}
+	return p.callbackPath
+}
+
+func (a *Authenticator) setLoginFlowCookie(w http.ResponseWriter, f *loginFlow, cookiePath string) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Value:    base64.RawURLEncoding.EncodeToString(data),
+		MaxAge:   int(loginFlowTTL.Seconds()),
+		HttpOnly: true,
+		Path:     cookiePath,
+		Secure:   a.secureCookies,
+		SameSite: a.stateCookieSameSite,
+	})
+	return nil
+}
+
+func (a *Authenticator) getLoginFlow(r *http.Request) (*loginFlow, error) {
+	cookie, err := r.Cookie(stateCookieName)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil, fmt.Errorf("malformed state cookie: %v", err)
+	}
+	var f loginFlow
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("malformed state cookie: %v", err)
+	}
+	if f.State == "" {
+		return nil, fmt.Errorf("state cookie has no state")
+	}
+	return &f, nil
+}
+
+// clearLoginFlowCookie deletes the state cookie so it can't be replayed.
+func (a *Authenticator) clearLoginFlowCookie(w http.ResponseWriter, cookiePath string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Path:     cookiePath,
+		Secure:   a.secureCookies,
+		SameSite: a.stateCookieSameSite,
+	})
+}
+
+// returnURL checks that then is a path on the console and returns the URL to
+// send the user to after logging in. Anything else is rejected so the login
+// endpoint can't be used as an open redirect.
+func (a *Authenticator) returnURL(then string) (string, error) {
+	// Browsers treat "//host" and "/\host" as network-path references.
+	if !strings.HasPrefix(then, "/") || strings.HasPrefix(then, "//") || strings.Contains(then, "\\") {
+		return "", fmt.Errorf("not an absolute path")
+	}
+	u, err := url.Parse(then)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "" || u.Host != "" || u.User != nil {
+		return "", fmt.Errorf("not an absolute path")
+	}
+
+	basePath := a.refererURL.Path
+	if !strings.HasSuffix(basePath, "/") {
+		basePath += "/"
+	}
+	cleaned := path.Clean(u.Path)
+	if cleaned+"/" != basePath && !strings.HasPrefix(cleaned, basePath) {
+		return "", fmt.Errorf("not under %s", basePath)
+	}
+
+	ret := url.URL{
+		Scheme:   a.refererURL.Scheme,
+		Host:     a.refererURL.Host,
+		Path:     cleaned,
+		RawQuery: u.RawQuery,
+	}
+	return ret.String(), nil
+}
+
+// pkceChallenge returns the S256 code challenge for verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// withCodeVerifier returns a copy of client that adds the PKCE code verifier
+// to token requests. The vendored oauth2 package can't add parameters to
+// Exchange, so the verifier is added to the request body on its way out.
+func withCodeVerifier(client *http.Client, verifier string) *http.Client {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	c := *client
+	c.Transport = &codeVerifierTransport{base: base, verifier: verifier}
+	return &c
+}
+
+type codeVerifierTransport struct {
+	base     http.RoundTripper
+	verifier string
+}
+
+func (t *codeVerifierTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodPost || r.Body == nil ||
+		!strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return t.base.RoundTrip(r)
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	form.Set("code_verifier", t.verifier)
+	encoded := form.Encode()
+
+	// RoundTrippers must not modify the request they're given.
+	r = r.Clone(r.Context())
+	r.Body = ioutil.NopCloser(strings.NewReader(encoded))
+	r.ContentLength = int64(len(encoded))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(encoded)), nil
+	}
+	return t.base.RoundTrip(r)
+}
+
+// randomURLString returns n random bytes encoded so they're safe to use in
+// URLs and cookies, and as PKCE verifiers.
+func randomURLString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("FATAL ERROR: Unable to get random bytes: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
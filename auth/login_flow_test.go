@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// startLogin calls LoginFunc and returns the authorization request and the
+// state cookie.
+func startLogin(t *testing.T, a *Authenticator) (url.Values, *http.Cookie) {
+	return startLoginRequest(t, a, httptest.NewRequest("GET", "/auth/login", nil))
+}
+
+func startLoginRequest(t *testing.T, a *Authenticator, r *http.Request) (url.Values, *http.Cookie) {
+	w := httptest.NewRecorder()
+	a.LoginFunc(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("login status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	loc, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == stateCookieName {
+			return loc.Query(), c
+		}
+	}
+	t.Fatal("login did not set a state cookie")
+	return nil, nil
+}
+
+// finishLogin calls CallbackFunc and reports whether the login succeeded.
+func finishLogin(a *Authenticator, code, state string, cookie *http.Cookie) (*httptest.ResponseRecorder, bool) {
+	w, successURL := finishLoginURL(a, code, state, cookie)
+	return w, successURL != ""
+}
+
+// finishLoginURL calls CallbackFunc and returns the URL the user is sent to
+// after a successful login, or "" if the login failed.
+func finishLoginURL(a *Authenticator, code, state string, cookie *http.Cookie) (*httptest.ResponseRecorder, string) {
+	r := httptest.NewRequest("GET", "/auth/callback?"+url.Values{"code": {code}, "state": {state}}.Encode(), nil)
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	successURL := ""
+	a.CallbackFunc(func(_ LoginJSON, u string, _ http.ResponseWriter) { successURL = u })(w, r)
+	return w, successURL
+}
+
+func TestLoginFlow(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	defer p.Close()
+	a := newTestAuthenticator(t, testOIDCConfig(p))
+
+	q, cookie := startLogin(t, a)
+	if len(q.Get("state")) < 43 {
+		t.Errorf("state %q is too short", q.Get("state"))
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Errorf("authorization request has no S256 code challenge: %v", q)
+	}
+	if q.Get("nonce") == "" {
+		t.Error("authorization request has no nonce")
+	}
+	if cookie.Path != "/auth/callback" || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.MaxAge <= 0 {
+		t.Errorf("state cookie is not hardened: %+v", cookie)
+	}
+
+	p.codes["good-code"] = testAuthCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	w, ok := finishLogin(a, "good-code", q.Get("state"), cookie)
+	if !ok {
+		t.Fatalf("login failed, redirected to %s", w.Header().Get("Location"))
+	}
+	cleared := false
+	for _, c := range w.Result().Cookies() {
+		if c.Name == stateCookieName && c.MaxAge < 0 {
+			cleared = true
+		}
+	}
+	if !cleared {
+		t.Error("callback did not delete the state cookie")
+	}
+}
+
+func TestLoginFlowRejects(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	defer p.Close()
+	a := newTestAuthenticator(t, testOIDCConfig(p))
+
+	tests := []struct {
+		name  string
+		code  func(q url.Values) testAuthCode
+		state func(q url.Values) string
+	}{
+		{
+			name: "wrong state",
+			code: func(q url.Values) testAuthCode {
+				return testAuthCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+			},
+			state: func(q url.Values) string { return "other-state" },
+		},
+		{
+			name: "wrong code verifier",
+			code: func(q url.Values) testAuthCode {
+				return testAuthCode{challenge: pkceChallenge("other"), nonce: q.Get("nonce")}
+			},
+			state: func(q url.Values) string { return q.Get("state") },
+		},
+		{
+			name: "wrong nonce",
+			code: func(q url.Values) testAuthCode {
+				return testAuthCode{challenge: q.Get("code_challenge"), nonce: "other-nonce"}
+			},
+			state: func(q url.Values) string { return q.Get("state") },
+		},
+		{
+			name:  "missing nonce",
+			code:  func(q url.Values) testAuthCode { return testAuthCode{challenge: q.Get("code_challenge")} },
+			state: func(q url.Values) string { return q.Get("state") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, cookie := startLogin(t, a)
+			p.codes["code"] = tt.code(q)
+			if w, ok := finishLogin(a, "code", tt.state(q), cookie); ok {
+				t.Errorf("login succeeded, want failure")
+			} else if loc := w.Header().Get("Location"); loc == "" {
+				t.Error("expected redirect to the error page")
+			}
+		})
+	}
+}
+
+func TestLoginFlowDisabled(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	defer p.Close()
+	c := testOIDCConfig(p)
+	c.DisablePKCE = true
+	c.DisableNonce = true
+	c.StateCookieSameSite = http.SameSiteNoneMode
+	c.SecureCookies = true
+	a := newTestAuthenticator(t, c)
+
+	q, cookie := startLogin(t, a)
+	if q.Get("code_challenge") != "" || q.Get("nonce") != "" {
+		t.Errorf("authorization request has disabled parameters: %v", q)
+	}
+	if cookie.SameSite != http.SameSiteNoneMode {
+		t.Errorf("state cookie SameSite = %v, want None", cookie.SameSite)
+	}
+
+	p.codes["good-code"] = testAuthCode{}
+	if w, ok := finishLogin(a, "good-code", q.Get("state"), cookie); !ok {
+		t.Fatalf("login failed, redirected to %s", w.Header().Get("Location"))
+	}
+}
+
+func TestLoginReturnPath(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	defer p.Close()
+	a := newTestAuthenticator(t, testOIDCConfig(p))
+	// Serve the console from a base path.
+	a.refererURL, _ = url.Parse("http://example.com/console/")
+
+	tests := []struct {
+		then string
+		want string
+	}{
+		{"/console/k8s/ns/foo/pods/bar", "http://example.com/console/k8s/ns/foo/pods/bar"},
+		{"/console/search?kind=Pod&q=a", "http://example.com/console/search?kind=Pod&q=a"},
+		{"/console/", "http://example.com/console"},
+		{"/console", "http://example.com/console"},
+		// Rejected return paths fall back to the success URL.
+		{"", "http://example.com/"},
+		{"/other/app", "http://example.com/"},
+		{"/console-evil/", "http://example.com/"},
+		{"/console/../other", "http://example.com/"},
+		{"//evil.example.com/console/", "http://example.com/"},
+		{"/\\evil.example.com/console/", "http://example.com/"},
+		{"https://evil.example.com/console/", "http://example.com/"},
+		{"console/k8s", "http://example.com/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.then, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/auth/login?"+url.Values{"then": {tt.then}}.Encode(), nil)
+			q, cookie := startLoginRequest(t, a, r)
+			p.codes["code"] = testAuthCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+			w, got := finishLoginURL(a, "code", q.Get("state"), cookie)
+			if got == "" {
+				t.Fatalf("login failed, redirected to %s", w.Header().Get("Location"))
+			}
+			if got != tt.want {
+				t.Errorf("success URL = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package auth
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -10,24 +11,99 @@ import (
 // None of the serializable fields contain any sensitive information,
 // and should be safe to send as a non-http-only cookie.
 type loginState struct {
-	UserID       string
+	UserID string
+	// Username is the user's name in the cluster. Name is for display.
+	Username     string
 	Name         string
 	Email        string
+	Groups       []string
+	ScopeProfile string
 	exp          time.Time
 	now          nowFunc
 	sessionToken string
-	rawToken     string
+	// sessionID identifies the session without revealing sessionToken.
+	sessionID string
+	// provider is the name of the provider the user logged in with, or
+	// empty if only a single provider is configured.
+	provider string
+	// providerSID is the provider's own session ID, the sid claim of the ID
+	// token, used to match back-channel logouts.
+	providerSID string
+	rawToken    string
+	// refreshToken is kept server-side only and used to renew rawToken
+	// before it expires.
+	refreshToken string
+	// refreshExp is the time after which the session can no longer be
+	// renewed and the user has to log in again.
+	refreshExp time.Time
+	// lastActivity is when the user was last seen using the session, for
+	// the idle timeout.
+	lastActivity time.Time
+	// impersonation is who the user started impersonating, if anyone.
+	impersonation *Impersonation
 }
 
 type LoginJSON struct {
-	UserID string `json:"userID"`
-	Name   string `json:"name"`
-	Email  string `json:"email"`
-	Exp    int64  `json:"exp"`
+	UserID string   `json:"userID"`
+	Name   string   `json:"name"`
+	Email  string   `json:"email"`
+	Groups []string `json:"groups,omitempty"`
+	// ScopeProfile is the restricted scope profile the user logged in with,
+	// for example "view", or empty for the default scopes.
+	ScopeProfile string `json:"scopeProfile"`
+	Exp          int64  `json:"exp"`
 }
 
-// newLoginState unpacks a token and generates a new loginState from it.
+// ClaimMapping names the ID token claims the user's details are read from.
+// Nested claims are addressed by dot separated paths, for example
+// realm_access.roles. A claim whose own name contains dots, such as a
+// namespaced https://example.com/groups claim, is matched by its full name
+// first.
+type ClaimMapping struct {
+	// Username is the claim holding the user's name in the cluster. Defaults
+	// to sub, which, unlike name, is unique and stable, as the API server's
+	// --oidc-username-claim.
+	Username string
+	// Name is the claim holding the name displayed for the user. Defaults
+	// to name.
+	Name string
+	// Email is the claim holding the user's email address. Defaults to email.
+	Email string
+	// Groups is the claim holding the user's groups, either a list of
+	// strings or a single string. Defaults to groups.
+	Groups string
+	// RequireEmailVerified rejects ID tokens unless their email_verified
+	// claim is true.
+	RequireEmailVerified bool
+}
+
+// withDefaults returns m with the default claims filled in.
+func (m ClaimMapping) withDefaults() ClaimMapping {
+	if m.Username == "" {
+		m.Username = "sub"
+	}
+	if m.Name == "" {
+		m.Name = "name"
+	}
+	if m.Email == "" {
+		m.Email = "email"
+	}
+	if m.Groups == "" {
+		m.Groups = "groups"
+	}
+	return m
+}
+
+// newLoginState unpacks a token and generates a new loginState from it, using
+// the default claim mapping.
 func newLoginState(rawToken string, claims []byte) (*loginState, error) {
+	return ClaimMapping{}.newLoginState(rawToken, claims)
+}
+
+// newLoginState unpacks a token and generates a new loginState from it, with
+// the user's details read from the claims named by m.
+func (m ClaimMapping) newLoginState(rawToken string, claims []byte) (*loginState, error) {
+	m = m.withDefaults()
 	ls := &loginState{
 		now:      defaultNow,
 		rawToken: rawToken,
@@ -36,31 +112,131 @@ func newLoginState(rawToken string, claims []byte) (*loginState, error) {
 	var c struct {
 		Subject string   `json:"sub"`
 		Expiry  jsonTime `json:"exp"`
-		Email   string   `json:"email"`
-		Name    string   `json:"name"`
+		SID     string   `json:"sid"`
 	}
-
 	if err := json.Unmarshal(claims, &c); err != nil {
 		return nil, fmt.Errorf("error getting claims from token: %v", err)
 	}
+	var all map[string]interface{}
+	if err := json.Unmarshal(claims, &all); err != nil {
+		return nil, fmt.Errorf("error getting claims from token: %v", err)
+	}
 
 	if c.Subject == "" {
 		return nil, fmt.Errorf("token missing require claim 'sub'")
 	}
 
+	var err error
+	if ls.Username, err = stringClaim(all, m.Username); err != nil {
+		return nil, err
+	}
+	if ls.Name, err = stringClaim(all, m.Name); err != nil {
+		return nil, err
+	}
+	if ls.Email, err = stringClaim(all, m.Email); err != nil {
+		return nil, err
+	}
+	if ls.Groups, err = groupsClaim(all, m.Groups); err != nil {
+		return nil, err
+	}
+	if m.RequireEmailVerified {
+		// Some providers send the boolean as a string.
+		switch verified, _ := lookupClaim(all, "email_verified"); verified {
+		case true, "true":
+		default:
+			return nil, fmt.Errorf("email %q is not verified", ls.Email)
+		}
+	}
+
 	ls.UserID = c.Subject
-	ls.Email = c.Email
 	ls.exp = time.Time(c.Expiry)
-	ls.Name = c.Name
+	ls.providerSID = c.SID
 	return ls, nil
 }
 
+// lookupClaim returns the claim at path, see ClaimMapping.
+func lookupClaim(claims map[string]interface{}, path string) (interface{}, bool) {
+	if v, ok := claims[path]; ok {
+		return v, true
+	}
+	var v interface{} = claims
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// stringClaim returns the string claim at path, or an empty string if the
+// claim doesn't exist.
+func stringClaim(claims map[string]interface{}, path string) (string, error) {
+	v, ok := lookupClaim(claims, path)
+	if !ok || v == nil {
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("claim %q is not a string", path)
+	}
+	return s, nil
+}
+
+// groupsClaim returns the groups claim at path.
+func groupsClaim(claims map[string]interface{}, path string) ([]string, error) {
+	v, ok := lookupClaim(claims, path)
+	if !ok || v == nil {
+		return nil, nil
+	}
+	switch v := v.(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		groups := make([]string, 0, len(v))
+		for _, g := range v {
+			s, ok := g.(string)
+			if !ok {
+				return nil, fmt.Errorf("claim %q is not a list of strings", path)
+			}
+			groups = append(groups, s)
+		}
+		return groups, nil
+	default:
+		return nil, fmt.Errorf("claim %q is not a list of strings", path)
+	}
+}
+
+// sessionExp returns the time after which the session is unusable. Sessions
+// with a refresh token last until refreshExp, the maximum session age, no
+// matter when their current token expires.
+func (ls *loginState) sessionExp() time.Time {
+	if ls.refreshToken != "" && !ls.refreshExp.IsZero() {
+		return ls.refreshExp
+	}
+	return ls.exp
+}
+
+// username returns the user's username, or their name for login methods
+// without usernames, such as OpenShift.
+func (ls *loginState) username() string {
+	if ls.Username != "" {
+		return ls.Username
+	}
+	return ls.Name
+}
+
 func (ls *loginState) toLoginJSON() LoginJSON {
 	return LoginJSON{
-		UserID: ls.UserID,
-		Name:   ls.Name,
-		Email:  ls.Email,
-		Exp:    ls.exp.Unix(),
+		UserID:       ls.UserID,
+		Name:         ls.Name,
+		Email:        ls.Email,
+		Groups:       ls.Groups,
+		ScopeProfile: ls.ScopeProfile,
+		Exp:          ls.sessionExp().Unix(),
 	}
 }
 
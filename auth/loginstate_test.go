@@ -2,6 +2,7 @@ package auth
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -66,3 +67,108 @@ func TestNewLoginState(t *testing.T) {
 		}
 	}
 }
+
+func TestClaimMapping(t *testing.T) {
+	const claims = `{
+		"sub": "user-id",
+		"name": "Penny",
+		"preferred_username": "penny",
+		"email": "penny@example.com",
+		"realm_access": {"roles": ["admins", "devs"]},
+		"https://example.com/groups": "ops",
+		"count": 3
+	}`
+	tests := []struct {
+		mapping      ClaimMapping
+		claims       string
+		wantErr      bool
+		wantUsername string
+		wantName     string
+		wantGroups   []string
+	}{
+		// defaults
+		{
+			claims:       `{"sub": "user-id", "name": "Penny", "groups": ["admins"]}`,
+			wantUsername: "user-id",
+			wantName:     "Penny",
+			wantGroups:   []string{"admins"},
+		},
+		// nested groups
+		{
+			mapping:      ClaimMapping{Username: "preferred_username", Groups: "realm_access.roles"},
+			claims:       claims,
+			wantUsername: "penny",
+			wantName:     "Penny",
+			wantGroups:   []string{"admins", "devs"},
+		},
+		// claim name containing dots, holding a single group
+		{
+			mapping:      ClaimMapping{Groups: "https://example.com/groups"},
+			claims:       claims,
+			wantUsername: "user-id",
+			wantName:     "Penny",
+			wantGroups:   []string{"ops"},
+		},
+		// missing claim
+		{
+			mapping:  ClaimMapping{Username: "realm_access.missing"},
+			claims:   claims,
+			wantName: "Penny",
+		},
+		// wrong types
+		{
+			mapping: ClaimMapping{Username: "count"},
+			claims:  claims,
+			wantErr: true,
+		},
+		{
+			mapping: ClaimMapping{Groups: "realm_access"},
+			claims:  claims,
+			wantErr: true,
+		},
+		// email_verified
+		{
+			mapping: ClaimMapping{RequireEmailVerified: true},
+			claims:  claims,
+			wantErr: true,
+		},
+		{
+			mapping: ClaimMapping{RequireEmailVerified: true},
+			claims:  `{"sub": "user-id", "email_verified": false}`,
+			wantErr: true,
+		},
+		{
+			mapping:      ClaimMapping{RequireEmailVerified: true},
+			claims:       `{"sub": "user-id", "email_verified": true}`,
+			wantUsername: "user-id",
+		},
+		{
+			mapping:      ClaimMapping{RequireEmailVerified: true},
+			claims:       `{"sub": "user-id", "email_verified": "true"}`,
+			wantUsername: "user-id",
+		},
+	}
+
+	for i, tt := range tests {
+		ls, err := tt.mapping.newLoginState("rando-token-string", []byte(tt.claims))
+		if err != nil {
+			if !tt.wantErr {
+				t.Errorf("case %d: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if tt.wantErr {
+			t.Errorf("case %d: expected error", i)
+			continue
+		}
+		if ls.Username != tt.wantUsername {
+			t.Errorf("case %d: username mismatch, want: %s, got: %s", i, tt.wantUsername, ls.Username)
+		}
+		if ls.Name != tt.wantName {
+			t.Errorf("case %d: name mismatch, want: %s, got: %s", i, tt.wantName, ls.Name)
+		}
+		if !reflect.DeepEqual(ls.Groups, tt.wantGroups) {
+			t.Errorf("case %d: groups mismatch, want: %v, got: %v", i, tt.wantGroups, ls.Groups)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	openShiftUserPath = "/apis/user.openshift.io/v1/users/~"
+
+	openShiftUserCacheTTL  = 5 * time.Minute
+	openShiftUserCacheSize = 4096
+	// openShiftUserFailureTTL is how long a failed lookup is cached, so an
+	// unavailable API server isn't asked again on every request.
+	openShiftUserFailureTTL = 30 * time.Second
+)
+
+var (
+	// errTokenRejected is returned when the API server doesn't accept a token.
+	errTokenRejected = errors.New("token rejected by the API server")
+	// errUserForbidden is returned for tokens that may not read their own
+	// user, such as tokens of a scope profile without user:info. That
+	// doesn't change during the token's lifetime.
+	errUserForbidden = errors.New("token may not read its user")
+)
+
+// openShiftUser is the identity behind an OpenShift access token.
+type openShiftUser struct {
+	UID    string
+	Name   string
+	Groups []string
+}
+
+type cachedOpenShiftUser struct {
+	user *openShiftUser
+	// err is set if the lookup failed.
+	err error
+	exp time.Time
+}
+
+// openShiftUserResolver looks up the user that owns an OpenShift access token.
+// Results are cached by token hash so authenticating a request doesn't usually
+// cost a round trip to the API server. Failed lookups are cached too, and
+// logged once, except for rejected tokens.
+type openShiftUserResolver struct {
+	clientFunc func() (*http.Client, error)
+	userURL    string
+	now        nowFunc
+
+	mux   sync.Mutex
+	cache map[string]cachedOpenShiftUser
+}
+
+func newOpenShiftUserResolver(apiServerURL string, clientFunc func() (*http.Client, error)) *openShiftUserResolver {
+	return &openShiftUserResolver{
+		clientFunc: clientFunc,
+		userURL:    strings.TrimSuffix(apiServerURL, "/") + openShiftUserPath,
+		now:        defaultNow,
+		cache:      make(map[string]cachedOpenShiftUser),
+	}
+}
+
+func (u *openShiftUserResolver) resolve(ctx context.Context, token string) (*openShiftUser, error) {
+	key := sessionID(token)
+
+	u.mux.Lock()
+	cached, ok := u.cache[key]
+	u.mux.Unlock()
+	if ok && u.now().Before(cached.exp) {
+		return cached.user, cached.err
+	}
+
+	user, err := u.fetch(ctx, token)
+	ttl := openShiftUserCacheTTL
+	switch err {
+	case nil:
+	case errTokenRejected:
+		return nil, err
+	case errUserForbidden:
+		log.Infof("continuing without identity: %v", err)
+	default:
+		log.Errorf("failed to look up user, continuing without identity: %v", err)
+		ttl = openShiftUserFailureTTL
+	}
+
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	if len(u.cache) >= openShiftUserCacheSize {
+		now := u.now()
+		for k, v := range u.cache {
+			if !now.Before(v.exp) {
+				delete(u.cache, k)
+			}
+		}
+		if len(u.cache) >= openShiftUserCacheSize {
+			u.cache = make(map[string]cachedOpenShiftUser)
+		}
+	}
+	u.cache[key] = cachedOpenShiftUser{user: user, err: err, exp: u.now().Add(ttl)}
+	return user, err
+}
+
+// forget drops any cached identity for token, for example on logout.
+func (u *openShiftUserResolver) forget(token string) {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	delete(u.cache, sessionID(token))
+}
+
+func (u *openShiftUserResolver) fetch(ctx context.Context, token string) (*openShiftUser, error) {
+	client, err := u.clientFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.userURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("request to %s failed: %v", u.userURL, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return nil, errTokenRejected
+	case resp.StatusCode == http.StatusForbidden:
+		return nil, errUserForbidden
+	case resp.StatusCode/100 != 2:
+		return nil, fmt.Errorf("request to %s failed: %s", u.userURL, resp.Status)
+	}
+
+	var user struct {
+		Metadata struct {
+			Name string `json:"name"`
+			UID  string `json:"uid"`
+		} `json:"metadata"`
+		Groups []string `json:"groups"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, fmt.Errorf("failed to decode user from %s: %v", u.userURL, err)
+	}
+	if user.Metadata.Name == "" {
+		return nil, fmt.Errorf("user from %s has no name", u.userURL)
+	}
+
+	return &openShiftUser{
+		UID:    user.Metadata.UID,
+		Name:   user.Metadata.Name,
+		Groups: user.Groups,
+	}, nil
+}
@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// ProviderConfig configures one of several named OIDC providers users can
+// choose from when logging in.
+type ProviderConfig struct {
+	// Name identifies the provider in URLs, for example /auth/login/<name>.
+	Name string
+	// DisplayName is shown on the provider selection page.
+	DisplayName string
+
+	IssuerURL    string
+	IssuerCA     string
+	ClientID     string
+	ClientSecret string
+	Scope        []string
+	// RedirectURL is the provider's callback, /auth/callback/<name>.
+	RedirectURL string
+	// ClaimMapping names the ID token claims holding the user's details.
+	ClaimMapping ClaimMapping
+	// KubectlClientID and KubectlClientSecret are kubectl's OAuth2 client at
+	// the provider, if users can get credentials for kubectl.
+	KubectlClientID     string
+	KubectlClientSecret string
+}
+
+// Provider describes an identity provider users can log in with.
+type Provider struct {
+	Name        string
+	DisplayName string
+}
+
+var providerNameRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+func validateProviderConfigs(configs []ProviderConfig) error {
+	seen := make(map[string]bool)
+	for _, pc := range configs {
+		if !providerNameRegexp.MatchString(pc.Name) {
+			return fmt.Errorf("invalid provider name %q: must consist of lower case alphanumeric characters or '-'", pc.Name)
+		}
+		if seen[pc.Name] {
+			return fmt.Errorf("duplicate provider name %q", pc.Name)
+		}
+		seen[pc.Name] = true
+		if pc.IssuerURL == "" || pc.ClientID == "" || pc.RedirectURL == "" {
+			return fmt.Errorf("provider %q: issuer URL, client ID and redirect URL are required", pc.Name)
+		}
+	}
+	return nil
+}
+
+// provider is an identity provider the Authenticator can log users in with.
+type provider struct {
+	name        string
+	displayName string
+
+	issuerURL string
+	issuerCA  string
+
+	// ready is closed once connect reached the provider and set authFunc.
+	// Until then the provider mustn't be used, and connectErr is the last
+	// error reaching it.
+	ready      chan struct{}
+	connectMux sync.Mutex
+	connectErr error
+
+	authFunc   func() (*oauth2.Config, loginMethod)
+	clientFunc func() *http.Client
+	// callbackPath is the path of the provider's redirect URL. Callbacks for
+	// a login started with this provider must arrive there, so a response
+	// from one provider can't be passed off as coming from another.
+	callbackPath string
+
+	config         ProviderConfig
+	authSourceFunc func() (oauth2.Endpoint, loginMethod, error)
+}
+
+// newClientFunc returns a function that returns the HTTP client to use with
+// an issuer, picking up changes to the CA file.
+func newClientFunc(issuerCA string) (func() *http.Client, error) {
+	// make sure we get a valid starting client
+	fallbackClient, err := newHTTPClient(issuerCA, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() *http.Client {
+		currentClient, err := newHTTPClient(issuerCA, true)
+		if err != nil {
+			log.Errorf("failed to get latest http client: %v", err)
+			return fallbackClient
+		}
+		return currentClient
+	}, nil
+}
+
+// newProvider returns a provider that can't be used until connect succeeds.
+func newProvider(pc ProviderConfig, clientFunc func() *http.Client, authSourceFunc func() (oauth2.Endpoint, loginMethod, error)) *provider {
+	callbackPath := ""
+	if redirectURL, err := url.Parse(pc.RedirectURL); err == nil {
+		callbackPath = redirectURL.Path
+	}
+	return &provider{
+		name:           pc.Name,
+		displayName:    pc.DisplayName,
+		issuerURL:      pc.IssuerURL,
+		issuerCA:       pc.IssuerCA,
+		clientFunc:     clientFunc,
+		callbackPath:   callbackPath,
+		config:         pc,
+		authSourceFunc: authSourceFunc,
+		ready:          make(chan struct{}),
+	}
+}
+
+// connect contacts the provider and, if it's reachable, sets authFunc and
+// marks the provider ready.
+func (p *provider) connect() error {
+	fallbackEndpoint, fallbackLoginMethod, err := p.authSourceFunc()
+	if err != nil {
+		err = fmt.Errorf("error contacting auth provider %s: %v", p.issuerURL, err)
+		p.connectMux.Lock()
+		p.connectErr = err
+		p.connectMux.Unlock()
+		return err
+	}
+	p.authFunc = p.newAuthFunc(fallbackEndpoint, fallbackLoginMethod)
+	close(p.ready)
+	return nil
+}
+
+// isReady reports whether the provider was reached, so users can log in
+// with it.
+func (p *provider) isReady() bool {
+	select {
+	case <-p.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// lastConnectErr returns the last error reaching the provider, or nil if it
+// wasn't tried yet.
+func (p *provider) lastConnectErr() error {
+	p.connectMux.Lock()
+	defer p.connectMux.Unlock()
+	return p.connectErr
+}
+
+func (p *provider) newAuthFunc(fallbackEndpoint oauth2.Endpoint, fallbackLoginMethod loginMethod) func() (*oauth2.Config, loginMethod) {
+	pc := p.config
+	return func() (*oauth2.Config, loginMethod) {
+		// rebuild non-pointer struct each time to prevent any mutation
+		baseOAuth2Config := oauth2.Config{
+			ClientID:     pc.ClientID,
+			ClientSecret: pc.ClientSecret,
+			RedirectURL:  pc.RedirectURL,
+			Scopes:       pc.Scope,
+			Endpoint:     fallbackEndpoint,
+		}
+
+		currentEndpoint, currentLoginMethod, errAuthSource := p.authSourceFunc()
+		if errAuthSource != nil {
+			log.Errorf("failed to get latest auth source data: %v", errAuthSource)
+			return &baseOAuth2Config, fallbackLoginMethod
+		}
+
+		baseOAuth2Config.Endpoint = currentEndpoint
+		return &baseOAuth2Config, currentLoginMethod
+	}
+}
+
+// Providers returns the named identity providers users can choose from. It
+// is empty when only a single, unnamed provider is configured.
+func (a *Authenticator) Providers() []Provider {
+	var providers []Provider
+	for _, p := range a.providers {
+		if p.name == "" {
+			continue
+		}
+		displayName := p.displayName
+		if displayName == "" {
+			displayName = p.name
+		}
+		providers = append(providers, Provider{Name: p.name, DisplayName: displayName})
+	}
+	return providers
+}
+
+func (a *Authenticator) provider(name string) *provider {
+	for _, p := range a.providers {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// readyProvider returns the first provider that was reached, or nil if none
+// was yet.
+func (a *Authenticator) readyProvider() *provider {
+	for _, p := range a.providers {
+		if p.isReady() {
+			return p
+		}
+	}
+	return nil
+}
+
+// LoginWithProvider redirects to the named provider for user login.
+func (a *Authenticator) LoginWithProvider(w http.ResponseWriter, r *http.Request, name string) {
+	p := a.provider(name)
+	if p == nil || name == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !p.isReady() {
+		serviceUnavailable(w)
+		return
+	}
+	a.login(w, r, p)
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func newTestMultiProviderAuthenticator(t *testing.T, providers map[string]*testOIDCProvider) *Authenticator {
+	c := testConfig()
+	for _, name := range []string{"corp", "contractors"} {
+		p := providers[name]
+		c.Providers = append(c.Providers, ProviderConfig{
+			Name:         name,
+			IssuerURL:    p.issuer(),
+			ClientID:     p.clientID,
+			ClientSecret: "fake-secret",
+			RedirectURL:  "http://example.com/auth/callback/" + name,
+		})
+	}
+	return newTestAuthenticator(t, c)
+}
+
+func TestMultipleProviders(t *testing.T) {
+	providers := map[string]*testOIDCProvider{
+		"corp":        newTestOIDCProvider(t),
+		"contractors": newTestOIDCProvider(t),
+	}
+	for _, p := range providers {
+		defer p.Close()
+	}
+	providers["contractors"].subject = "contractor-id"
+	a := newTestMultiProviderAuthenticator(t, providers)
+
+	got := a.Providers()
+	if len(got) != 2 || got[0].Name != "corp" || got[1].Name != "contractors" || got[1].DisplayName != "contractors" {
+		t.Errorf("unexpected providers: %+v", got)
+	}
+
+	for name, p := range providers {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			a.LoginWithProvider(w, httptest.NewRequest("GET", "/auth/login/"+name, nil), name)
+			loc, err := url.Parse(w.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loc.Host != mustParseURL(t, p.issuer()).Host {
+				t.Fatalf("login redirected to %s, want provider %s", loc, p.issuer())
+			}
+			var cookie *http.Cookie
+			for _, c := range w.Result().Cookies() {
+				if c.Name == stateCookieName {
+					cookie = c
+				}
+			}
+			if cookie == nil || cookie.Path != "/auth/callback/"+name {
+				t.Fatalf("unexpected state cookie: %+v", cookie)
+			}
+
+			q := loc.Query()
+			p.codes["code"] = testAuthCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+			r := httptest.NewRequest("GET", "/auth/callback/"+name+"?"+url.Values{"code": {"code"}, "state": {q.Get("state")}}.Encode(), nil)
+			r.AddCookie(cookie)
+			w = httptest.NewRecorder()
+			ok := false
+			a.CallbackFunc(func(LoginJSON, string, http.ResponseWriter) { ok = true })(w, r)
+			if !ok {
+				t.Fatalf("login failed, redirected to %s", w.Header().Get("Location"))
+			}
+
+			r = httptest.NewRequest("GET", "/api/kubernetes/", nil)
+			for _, c := range w.Result().Cookies() {
+				r.AddCookie(c)
+			}
+			user, err := a.Authenticate(r)
+			if err != nil {
+				t.Fatalf("Authenticate error: %v", err)
+			}
+			if user.ID != p.subject {
+				t.Errorf("user ID = %q, want %q", user.ID, p.subject)
+			}
+			session, err := a.GetSession(user.SessionID)
+			if err != nil {
+				t.Fatalf("GetSession error: %v", err)
+			}
+			if session.Provider != name {
+				t.Errorf("session provider = %q, want %q", session.Provider, name)
+			}
+		})
+	}
+
+	// A response for one provider can't be delivered to another's callback.
+	w := httptest.NewRecorder()
+	a.LoginWithProvider(w, httptest.NewRequest("GET", "/auth/login/corp", nil), "corp")
+	loc, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := loc.Query()
+	providers["corp"].codes["code"] = testAuthCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	r := httptest.NewRequest("GET", "/auth/callback/contractors?"+url.Values{"code": {"code"}, "state": {q.Get("state")}}.Encode(), nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	ok := false
+	a.CallbackFunc(func(LoginJSON, string, http.ResponseWriter) { ok = true })(httptest.NewRecorder(), r)
+	if ok {
+		t.Error("callback at another provider's path succeeded")
+	}
+
+	w = httptest.NewRecorder()
+	a.LoginWithProvider(w, httptest.NewRequest("GET", "/auth/login/unknown", nil), "unknown")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown provider status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestValidateProviderConfigs(t *testing.T) {
+	valid := ProviderConfig{Name: "corp", IssuerURL: "https://issuer", ClientID: "id", RedirectURL: "https://console/auth/callback/corp"}
+	with := func(f func(*ProviderConfig)) ProviderConfig {
+		pc := valid
+		f(&pc)
+		return pc
+	}
+
+	tests := []struct {
+		name    string
+		configs []ProviderConfig
+		wantErr bool
+	}{
+		{"valid", []ProviderConfig{valid, with(func(pc *ProviderConfig) { pc.Name = "dex-2" })}, false},
+		{"duplicate", []ProviderConfig{valid, valid}, true},
+		{"empty name", []ProviderConfig{with(func(pc *ProviderConfig) { pc.Name = "" })}, true},
+		{"path in name", []ProviderConfig{with(func(pc *ProviderConfig) { pc.Name = "../corp" })}, true},
+		{"upper case name", []ProviderConfig{with(func(pc *ProviderConfig) { pc.Name = "Corp" })}, true},
+		{"missing client", []ProviderConfig{with(func(pc *ProviderConfig) { pc.ClientID = "" })}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateProviderConfigs(tt.configs); (err != nil) != tt.wantErr {
+				t.Errorf("validateProviderConfigs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func mustParseURL(t *testing.T, s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// providerBackoff and providerMaxBackoff bound the delay between attempts
+	// to reach a provider at startup.
+	providerBackoff    = time.Second
+	providerMaxBackoff = time.Minute
+
+	// retryAfter is how long the console asks browsers to wait before trying
+	// to log in again while the provider is unreachable.
+	retryAfter = 10 * time.Second
+)
+
+var errStarting = errors.New("identity provider not contacted yet")
+
+// start connects to the providers until they were all reached, or ctx is
+// done. Each provider is retried on its own, so one that's unreachable
+// doesn't keep users of the others from logging in.
+func (a *Authenticator) start(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, p := range a.providers {
+		wg.Add(1)
+		go func(p *provider) {
+			defer wg.Done()
+			p.start(ctx)
+		}(p)
+	}
+	wg.Wait()
+	if a.readyProviders() == len(a.providers) {
+		close(a.started)
+	}
+}
+
+// start connects to the provider until it's reached, or ctx is done.
+// Failures are retried with exponential backoff and jitter, so replicas
+// restarted together don't retry in lockstep.
+func (p *provider) start(ctx context.Context) {
+	for attempt := 0; ; attempt++ {
+		err := p.connect()
+		if err == nil {
+			break
+		}
+		backoff := startBackoff(attempt)
+		log.Errorf("%v (retrying in %s)", err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+	}
+	if p.name != "" {
+		log.Infof("identity provider %q reachable, logins enabled", p.name)
+	} else {
+		log.Infof("identity provider reachable, logins enabled")
+	}
+}
+
+// startBackoff returns how long to wait after the given failed attempt to
+// reach a provider: between half and all of a delay that doubles with every
+// attempt, up to providerMaxBackoff.
+func startBackoff(attempt int) time.Duration {
+	backoff := providerMaxBackoff
+	if attempt < 32 && providerBackoff<<uint(attempt) < providerMaxBackoff {
+		backoff = providerBackoff << uint(attempt)
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// Ready reports whether every provider was reached.
+func (a *Authenticator) Ready() bool {
+	select {
+	case <-a.started:
+		return true
+	default:
+		return false
+	}
+}
+
+func (a *Authenticator) readyProviders() int {
+	n := 0
+	for _, p := range a.providers {
+		if p.isReady() {
+			n++
+		}
+	}
+	return n
+}
+
+// Healthy implements health.Checkable for readiness checks. It fails until a
+// provider was reached, since no one can log in before then, and returns why
+// it wasn't. See ProviderStatuses for each provider's state.
+func (a *Authenticator) Healthy() error {
+	if len(a.providers) == 0 || a.readyProviders() > 0 {
+		return nil
+	}
+	if len(a.providers) > 1 {
+		return errors.New("no identity provider reachable")
+	}
+	if err := a.providers[0].lastConnectErr(); err != nil {
+		return fmt.Errorf("identity provider not reachable: %v", err)
+	}
+	return errStarting
+}
+
+// ProviderStatus tells whether users can log in with an identity provider.
+type ProviderStatus struct {
+	// Name is empty if only a single provider is configured.
+	Name  string `json:"name,omitempty"`
+	Ready bool   `json:"ready"`
+	// Error is the last error reaching the provider, if it wasn't reached.
+	Error string `json:"error,omitempty"`
+}
+
+// ProviderStatuses returns the state of every provider.
+func (a *Authenticator) ProviderStatuses() []ProviderStatus {
+	statuses := []ProviderStatus{}
+	for _, p := range a.providers {
+		s := ProviderStatus{Name: p.name, Ready: p.isReady()}
+		if err := p.lastConnectErr(); err != nil && !s.Ready {
+			s.Error = err.Error()
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+var unavailableTemplate = template.Must(template.New("unavailable").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.}}">
+<title>Log in unavailable</title>
+</head>
+<body>
+<h1>Log in is temporarily unavailable</h1>
+<p>The console can't reach its identity provider yet. This page will retry in {{.}} seconds.</p>
+</body>
+</html>
+`))
+
+// serviceUnavailable tells a user trying to log in before their provider was
+// reached to come back later.
+func serviceUnavailable(w http.ResponseWriter) {
+	seconds := strconv.Itoa(int(retryAfter.Seconds()))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Retry-After", seconds)
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	if err := unavailableTemplate.Execute(w, seconds); err != nil {
+		log.Errorf("failed to write service unavailable page: %v", err)
+	}
+}
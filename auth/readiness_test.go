@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStartAuthenticator(t *testing.T) {
+	defer func(backoff, max time.Duration) {
+		providerBackoff, providerMaxBackoff = backoff, max
+	}(providerBackoff, providerMaxBackoff)
+	providerBackoff, providerMaxBackoff = time.Millisecond, 10*time.Millisecond
+
+	// The provider is down until up is set.
+	var up int32
+	p := &mockOIDCProvider{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&up) == 0 {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		p.handleDiscovery(w, r)
+	}))
+	defer s.Close()
+	p.issuer = s.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, err := StartAuthenticator(ctx, &Config{
+		ClientID:     "fake-client-id",
+		ClientSecret: "fake-secret",
+		RedirectURL:  "http://example.com/callback",
+		IssuerURL:    p.issuer,
+		ErrorURL:     "http://example.com/error",
+		SuccessURL:   "http://example.com/success",
+		CookiePath:   "/",
+		RefererPath:  "http://example.com/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Ready() {
+		t.Fatal("authenticator ready before the provider is reachable")
+	}
+	w := httptest.NewRecorder()
+	a.LoginFunc(w, httptest.NewRequest("GET", "/auth/login", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("login status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+	if _, err := a.Authenticate(httptest.NewRequest("GET", "/api/kubernetes/", nil)); err == nil {
+		t.Error("request authenticated before the provider is reachable")
+	}
+	if err := a.Healthy(); err == nil {
+		t.Error("authenticator healthy before the provider is reachable")
+	}
+
+	atomic.StoreInt32(&up, 1)
+	select {
+	case <-a.started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("authenticator not ready after the provider came up: %v", a.Healthy())
+	}
+	if err := a.Healthy(); err != nil {
+		t.Errorf("authenticator unhealthy: %v", err)
+	}
+	w = httptest.NewRecorder()
+	a.LoginFunc(w, httptest.NewRequest("GET", "/auth/login", nil))
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("login status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+}
+
+func TestUnreachableProvider(t *testing.T) {
+	defer func(backoff, max time.Duration) {
+		providerBackoff, providerMaxBackoff = backoff, max
+	}(providerBackoff, providerMaxBackoff)
+	providerBackoff, providerMaxBackoff = time.Millisecond, 10*time.Millisecond
+
+	corp := newTestOIDCProvider(t)
+	defer corp.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	c := testConfig()
+	c.Providers = []ProviderConfig{
+		{Name: "corp", IssuerURL: corp.issuer(), ClientID: corp.clientID, RedirectURL: "http://example.com/auth/callback/corp"},
+		{Name: "contractors", IssuerURL: down.URL, ClientID: "fake-client-id", RedirectURL: "http://example.com/auth/callback/contractors"},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, err := StartAuthenticator(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var statuses []ProviderStatus
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		statuses = a.ProviderStatuses()
+		if statuses[0].Ready && statuses[1].Error != "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("provider statuses = %+v", statuses)
+		}
+	}
+	if statuses[1].Name != "contractors" || statuses[1].Ready {
+		t.Errorf("unreachable provider status = %+v", statuses[1])
+	}
+	if a.Ready() {
+		t.Error("authenticator ready with an unreachable provider")
+	}
+	if err := a.Healthy(); err != nil {
+		t.Errorf("authenticator unhealthy with a reachable provider: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	a.LoginWithProvider(w, httptest.NewRequest("GET", "/auth/login/contractors", nil), "contractors")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("login status of unreachable provider = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+
+	// Users of the reachable provider log in as usual.
+	w = httptest.NewRecorder()
+	a.LoginWithProvider(w, httptest.NewRequest("GET", "/auth/login/corp", nil), "corp")
+	loc, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := loc.Query()
+	corp.codes["code"] = testAuthCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	r := httptest.NewRequest("GET", "/auth/callback/corp?"+url.Values{"code": {"code"}, "state": {q.Get("state")}}.Encode(), nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	ok := false
+	a.CallbackFunc(func(LoginJSON, string, http.ResponseWriter) { ok = true })(w, r)
+	if !ok {
+		t.Fatalf("login failed, redirected to %s", w.Header().Get("Location"))
+	}
+	r = httptest.NewRequest("GET", "/api/kubernetes/", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	if _, err := a.Authenticate(r); err != nil {
+		t.Errorf("Authenticate error: %v", err)
+	}
+}
+
+func TestStartBackoff(t *testing.T) {
+	for _, tt := range []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{0, providerBackoff / 2, providerBackoff},
+		{3, 4 * providerBackoff, 8 * providerBackoff},
+		{100, providerMaxBackoff / 2, providerMaxBackoff},
+	} {
+		for i := 0; i < 10; i++ {
+			if got := startBackoff(tt.attempt); got < tt.min || got > tt.max {
+				t.Errorf("startBackoff(%d) = %s, want between %s and %s", tt.attempt, got, tt.min, tt.max)
+			}
+		}
+	}
+}
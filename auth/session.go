@@ -1,57 +1,142 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"sync"
 	"time"
 )
 
-const openshiftSessionCookieName = "openshift-session-token"
+const (
+	openshiftSessionCookieName = "openshift-session-token"
+	// openshiftScopeProfileCookieName holds the scope profile the user
+	// logged in with.
+	openshiftScopeProfileCookieName = "openshift-session-scope-profile"
+
+	// DefaultMaxSessions is the default limit on the number of sessions in a store.
+	DefaultMaxSessions = 32768
+	// DefaultMaxSessionsPerUser is the default limit on the number of sessions
+	// a single user may hold. Once reached, the user's oldest sessions are
+	// evicted so one user logging in over and over can't push everyone else out.
+	DefaultMaxSessionsPerUser = 64
+)
+
+// SessionStore holds server-side login sessions keyed by the random session
+// token handed to the browser.
+type SessionStore interface {
+	// addSession sets sessionToken to a random value and stores the loginState.
+	addSession(ls *loginState) error
+	// getSession returns the session for token, or nil if it doesn't exist.
+	getSession(token string) *loginState
+	// updateSession replaces the stored state of an existing session, for
+	// example after its tokens were refreshed.
+	updateSession(ls *loginState) error
+	deleteSession(token string) error
+	// listSessions returns every unexpired session. The returned login states
+	// have sessionID set but may not have sessionToken set.
+	listSessions() []*loginState
+	// deleteSessionByID deletes the session with the given sessionID.
+	deleteSessionByID(id string) error
+	// pruneSessions removes expired sessions and enforces the session limits.
+	pruneSessions()
+	// touchSession records at as the last activity of the session.
+	touchSession(token string, at time.Time) error
+}
+
+// sessionID derives a stable, non-secret identifier from a session token.
+func sessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
 
 type oldSession struct {
-	token string
-	exp   time.Time
+	token  string
+	userID string
+	exp    time.Time
 }
 
-type SessionStore struct {
-	byToken     map[string]*loginState
-	byAge       []oldSession
-	maxSessions int
-	now         nowFunc
-	mux         sync.Mutex
+// logEvictions logs the number of sessions evicted per user.
+func logEvictions(evicted map[string]int, reason string) {
+	for userID, n := range evicted {
+		log.Infof("Evicted %d sessions of user %q: %s", n, userID, reason)
+	}
+}
+
+// MemorySessionStore keeps sessions in process memory. Sessions are lost on
+// restart and aren't shared between replicas.
+type MemorySessionStore struct {
+	byToken            map[string]*loginState
+	byAge              []oldSession
+	maxSessions        int
+	maxSessionsPerUser int
+	now                nowFunc
+	mux                sync.Mutex
 }
 
-func NewSessionStore(maxSessions int) *SessionStore {
-	return &SessionStore{
-		byToken:     make(map[string]*loginState),
-		maxSessions: maxSessions,
-		now:         defaultNow,
+// NewMemorySessionStore creates an in-memory store holding at most
+// maxSessions sessions, and at most maxSessionsPerUser sessions per user.
+// A maxSessionsPerUser of 0 disables the per-user limit.
+func NewMemorySessionStore(maxSessions, maxSessionsPerUser int) *MemorySessionStore {
+	return &MemorySessionStore{
+		byToken:            make(map[string]*loginState),
+		maxSessions:        maxSessions,
+		maxSessionsPerUser: maxSessionsPerUser,
+		now:                defaultNow,
 	}
 }
 
-// addSession sets sessionToken to a random value and adds loginState to session data structures
-func (ss *SessionStore) addSession(ls *loginState) error {
+func (ss *MemorySessionStore) addSession(ls *loginState) error {
 	sessionToken := randomString(128)
+	ss.mux.Lock()
+	defer ss.mux.Unlock()
 	if ss.byToken[sessionToken] != nil {
-		ss.deleteSession(sessionToken)
 		return fmt.Errorf("Session token collision! THIS SHOULD NEVER HAPPEN! Token: %s", sessionToken)
 	}
 	ls.sessionToken = sessionToken
-	ss.mux.Lock()
+	ls.sessionID = sessionID(sessionToken)
 	ss.byToken[sessionToken] = ls
 	// Assume token expiration is always the same time in the future. Should be close enough for government work.
-	ss.byAge = append(ss.byAge, oldSession{sessionToken, ls.exp})
-	ss.mux.Unlock()
+	ss.byAge = append(ss.byAge, oldSession{sessionToken, ls.UserID, ls.sessionExp()})
 	return nil
 }
 
-func (ss *SessionStore) getSession(token string) *loginState {
+func (ss *MemorySessionStore) getSession(token string) *loginState {
 	ss.mux.Lock()
 	defer ss.mux.Unlock()
 	return ss.byToken[token]
 }
 
-func (ss *SessionStore) deleteSession(token string) error {
+func (ss *MemorySessionStore) touchSession(token string, at time.Time) error {
+	ss.mux.Lock()
+	defer ss.mux.Unlock()
+	ls := ss.byToken[token]
+	if ls == nil {
+		return fmt.Errorf("session store did not contain session %v", sessionID(token))
+	}
+	// Replace rather than modify the state, which callers may be reading.
+	touched := *ls
+	touched.lastActivity = at
+	ss.byToken[token] = &touched
+	return nil
+}
+
+func (ss *MemorySessionStore) updateSession(ls *loginState) error {
+	ss.mux.Lock()
+	defer ss.mux.Unlock()
+	if ss.byToken[ls.sessionToken] == nil {
+		return fmt.Errorf("session store did not contain session %v", ls.sessionID)
+	}
+	ss.byToken[ls.sessionToken] = ls
+	for i := range ss.byAge {
+		if ss.byAge[i].token == ls.sessionToken {
+			ss.byAge[i].exp = ls.sessionExp()
+		}
+	}
+	return nil
+}
+
+func (ss *MemorySessionStore) deleteSession(token string) error {
 	ss.mux.Lock()
 	defer ss.mux.Unlock()
 	delete(ss.byToken, token)
@@ -62,11 +147,43 @@ func (ss *SessionStore) deleteSession(token string) error {
 			return nil
 		}
 	}
-	log.Errorf("ss.byAge did not contain session %v", token)
-	return fmt.Errorf("ss.byAge did not contain session %v", token)
+	log.Errorf("ss.byAge did not contain session %v", sessionID(token))
+	return fmt.Errorf("ss.byAge did not contain session %v", sessionID(token))
 }
 
-func (ss *SessionStore) pruneSessions() {
+func (ss *MemorySessionStore) listSessions() []*loginState {
+	ss.mux.Lock()
+	defer ss.mux.Unlock()
+	now := ss.now()
+	sessions := make([]*loginState, 0, len(ss.byAge))
+	for _, s := range ss.byAge {
+		if s.exp.Before(now) {
+			continue
+		}
+		if ls := ss.byToken[s.token]; ls != nil {
+			sessions = append(sessions, ls)
+		}
+	}
+	return sessions
+}
+
+func (ss *MemorySessionStore) deleteSessionByID(id string) error {
+	ss.mux.Lock()
+	var token string
+	for t, ls := range ss.byToken {
+		if ls.sessionID == id {
+			token = t
+			break
+		}
+	}
+	ss.mux.Unlock()
+	if token == "" {
+		return fmt.Errorf("session store did not contain session %v", id)
+	}
+	return ss.deleteSession(token)
+}
+
+func (ss *MemorySessionStore) pruneSessions() {
 	ss.mux.Lock()
 	defer ss.mux.Unlock()
 	expired := 0
@@ -75,20 +192,48 @@ func (ss *SessionStore) pruneSessions() {
 		if s.exp.Sub(ss.now()) < 0 {
 			delete(ss.byToken, s.token)
 			ss.byAge = append(ss.byAge[:i], ss.byAge[i+1:]...)
+			i--
 			expired++
 		}
 	}
 	log.Debugf("Pruned %v expired sessions.", expired)
+
+	evicted := 0
+	if ss.maxSessionsPerUser > 0 {
+		perUser := make(map[string]int)
+		for _, s := range ss.byAge {
+			perUser[s.userID]++
+		}
+		byUser := make(map[string]int)
+		// byAge is ordered oldest first, so this keeps each user's newest sessions.
+		kept := make([]oldSession, 0, len(ss.byAge))
+		for _, s := range ss.byAge {
+			if perUser[s.userID] > ss.maxSessionsPerUser {
+				perUser[s.userID]--
+				delete(ss.byToken, s.token)
+				byUser[s.userID]++
+				evicted++
+				continue
+			}
+			kept = append(kept, s)
+		}
+		ss.byAge = kept
+		logEvictions(byUser, fmt.Sprintf("over the limit of %d sessions per user", ss.maxSessionsPerUser))
+	}
+
 	toRemove := len(ss.byAge) - ss.maxSessions
 	if toRemove > 0 {
 		log.Debugf("Still too many sessions. Pruning oldest %v sessions...", toRemove)
-		// TODO: account for user ids when pruning old sessions. Otherwise one user could log in 16k times and boot out everyone else.
+		byUser := make(map[string]int)
 		for _, s := range ss.byAge[:toRemove] {
 			delete(ss.byToken, s.token)
+			byUser[s.userID]++
 		}
 		ss.byAge = ss.byAge[toRemove:]
+		logEvictions(byUser, fmt.Sprintf("over the limit of %d sessions", ss.maxSessions))
+		evicted += toRemove
 	}
-	if expired+toRemove > 0 {
-		log.Debugf("Pruned %v old sessions.", expired+toRemove)
+	if expired+evicted > 0 {
+		log.Debugf("Pruned %v old sessions.", expired+evicted)
 	}
 }
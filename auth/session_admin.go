@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrSessionsNotSupported is returned by session administration methods when
+// the auth source doesn't keep server-side sessions.
+var ErrSessionsNotSupported = errors.New("auth source does not keep server-side sessions")
+
+// ErrSessionNotFound is returned when a session ID doesn't match an active session.
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session describes an active login session. It never includes the session
+// token or any bearer token, so it's safe to return to API clients.
+type Session struct {
+	ID      string    `json:"id"`
+	UserID  string    `json:"userID"`
+	Name    string    `json:"name"`
+	Email   string    `json:"email"`
+	Expires time.Time `json:"expires"`
+	// Provider is the identity provider the user logged in with. It's
+	// empty if only a single provider is configured.
+	Provider string `json:"provider,omitempty"`
+}
+
+func (ls *loginState) toSession() Session {
+	return Session{
+		ID:       ls.sessionID,
+		UserID:   ls.UserID,
+		Name:     ls.Name,
+		Email:    ls.Email,
+		Expires:  ls.sessionExp(),
+		Provider: ls.provider,
+	}
+}
+
+// ListSessions returns the active sessions of userID, or every active session
+// if userID is empty.
+func (a *Authenticator) ListSessions(userID string) ([]Session, error) {
+	if a.sessions == nil {
+		return nil, ErrSessionsNotSupported
+	}
+	sessions := []Session{}
+	for _, ls := range a.sessions.listSessions() {
+		if userID == "" || ls.UserID == userID {
+			sessions = append(sessions, ls.toSession())
+		}
+	}
+	return sessions, nil
+}
+
+// GetSession returns the active session with the given ID.
+func (a *Authenticator) GetSession(id string) (*Session, error) {
+	sessions, err := a.ListSessions("")
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sessions {
+		if s.ID == id {
+			return &s, nil
+		}
+	}
+	return nil, ErrSessionNotFound
+}
+
+// RevokeSession deletes a session and cancels any of its requests that are
+// still in flight.
+func (a *Authenticator) RevokeSession(id string) error {
+	if a.sessions == nil {
+		return ErrSessionsNotSupported
+	}
+	if err := a.sessions.deleteSessionByID(id); err != nil {
+		return ErrSessionNotFound
+	}
+	a.inflight.cancel(id)
+	log.Infof("revoked session %s", id)
+	return nil
+}
+
+// RevokeUserSessions revokes every session of userID and returns the number
+// of sessions revoked.
+func (a *Authenticator) RevokeUserSessions(userID string) (int, error) {
+	sessions, err := a.ListSessions(userID)
+	if err != nil {
+		return 0, err
+	}
+	revoked := 0
+	for _, s := range sessions {
+		// The session may have expired or been revoked concurrently.
+		if err := a.RevokeSession(s.ID); err == nil {
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+// TrackRequest returns a shallow copy of r whose context is canceled when the
+// user's session is revoked, so long-running requests such as watches don't
+// outlive the session. done must be called when the request completes.
+//
+// Only revocations made through this bridge instance cancel in-flight
+// requests. Other replicas reject the session on its next request.
+func (a *Authenticator) TrackRequest(user *User, r *http.Request) (tracked *http.Request, done func()) {
+	if user.SessionID == "" {
+		return r, func() {}
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	untrack := a.inflight.add(user.SessionID, cancel)
+	return r.WithContext(ctx), func() {
+		untrack()
+		cancel()
+	}
+}
+
+// inflightRequests tracks the cancel functions of in-flight requests by session ID.
+type inflightRequests struct {
+	mux       sync.Mutex
+	bySession map[string]map[*context.CancelFunc]struct{}
+}
+
+func (i *inflightRequests) add(id string, cancel context.CancelFunc) func() {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	if i.bySession == nil {
+		i.bySession = make(map[string]map[*context.CancelFunc]struct{})
+	}
+	if i.bySession[id] == nil {
+		i.bySession[id] = make(map[*context.CancelFunc]struct{})
+	}
+	key := &cancel
+	i.bySession[id][key] = struct{}{}
+
+	return func() {
+		i.mux.Lock()
+		defer i.mux.Unlock()
+		delete(i.bySession[id], key)
+		if len(i.bySession[id]) == 0 {
+			delete(i.bySession, id)
+		}
+	}
+}
+
+func (i *inflightRequests) cancel(id string) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	for cancel := range i.bySession[id] {
+		(*cancel)()
+	}
+	delete(i.bySession, id)
+}
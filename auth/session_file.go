@@ -0,0 +1,439 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileSessionStore keeps sessions as encrypted files in a directory. Pointing
+// every bridge replica at the same directory (for example a ReadWriteMany
+// volume) lets sessions survive restarts and work without sticky routing.
+//
+// Each session is stored in a file named after its sessionID, so the session
+// token itself never touches the disk. The file's modification time is set to
+// the session expiry so pruning doesn't have to decrypt every session. The
+// users directory indexes sessions by user with empty marker files, so the
+// per-user limit can be enforced the same way. The activity directory keeps
+// the last activity of sessions as the modification time of empty files, so
+// recording it doesn't rewrite the session, which could undo a refresh by
+// another replica.
+type FileSessionStore struct {
+	dir                string
+	keys               *KeySet
+	maxSessions        int
+	maxSessionsPerUser int
+	now                nowFunc
+	// mux only serializes writers within this process. Writes are atomic
+	// renames, so concurrent replicas never observe partial files.
+	mux sync.Mutex
+}
+
+// sessionRecord is the serialized form of a loginState.
+type sessionRecord struct {
+	UserID       string   `json:"userID"`
+	Username     string   `json:"username,omitempty"`
+	Name         string   `json:"name"`
+	Email        string   `json:"email"`
+	Groups       []string `json:"groups,omitempty"`
+	Exp          int64    `json:"exp"`
+	RawToken     string   `json:"rawToken"`
+	RefreshToken string   `json:"refreshToken,omitempty"`
+	RefreshExp   int64    `json:"refreshExp,omitempty"`
+	Provider     string   `json:"provider,omitempty"`
+	ProviderSID  string   `json:"providerSID,omitempty"`
+	// Impersonation is who the user started impersonating, if anyone.
+	Impersonation *Impersonation `json:"impersonation,omitempty"`
+}
+
+const (
+	usersDir    = "users"
+	activityDir = "activity"
+)
+
+// NewFileSessionStore creates a store in dir holding at most maxSessions
+// sessions, and at most maxSessionsPerUser sessions per user. A
+// maxSessionsPerUser of 0 disables the per-user limit.
+func NewFileSessionStore(dir string, keys *KeySet, maxSessions, maxSessionsPerUser int) (*FileSessionStore, error) {
+	if keys == nil {
+		return nil, fmt.Errorf("file session store requires an encryption key set")
+	}
+	for _, sub := range []string{usersDir, activityDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, fmt.Errorf("create session directory %s: %v", dir, err)
+		}
+	}
+	return &FileSessionStore{
+		dir:                dir,
+		keys:               keys,
+		maxSessions:        maxSessions,
+		maxSessionsPerUser: maxSessionsPerUser,
+		now:                defaultNow,
+	}, nil
+}
+
+func (fs *FileSessionStore) path(id string) string {
+	return filepath.Join(fs.dir, id)
+}
+
+// userDir returns the directory indexing the sessions of userID. The user ID
+// is hashed so it doesn't leak onto the disk or need escaping.
+func (fs *FileSessionStore) userDir(userID string) string {
+	sum := sha256.Sum256([]byte(userID))
+	return filepath.Join(fs.dir, usersDir, hex.EncodeToString(sum[:]))
+}
+
+func (fs *FileSessionStore) activityPath(id string) string {
+	return filepath.Join(fs.dir, activityDir, id)
+}
+
+func (fs *FileSessionStore) addSession(ls *loginState) error {
+	sessionToken := randomString(128)
+	id := sessionID(sessionToken)
+
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+	if _, err := os.Stat(fs.path(id)); err == nil {
+		return fmt.Errorf("Session token collision! THIS SHOULD NEVER HAPPEN! Session: %s", id)
+	}
+
+	if err := fs.writeSession(id, ls); err != nil {
+		return err
+	}
+	if err := fs.writeUserMarker(id, ls); err != nil {
+		fs.removeFile(id)
+		return err
+	}
+	if !ls.lastActivity.IsZero() {
+		if err := fs.writeActivity(id, ls.lastActivity); err != nil {
+			fs.removeFile(id)
+			return err
+		}
+	}
+	ls.sessionToken = sessionToken
+	ls.sessionID = id
+	return nil
+}
+
+func (fs *FileSessionStore) writeSession(id string, ls *loginState) error {
+	rec := &sessionRecord{
+		UserID:        ls.UserID,
+		Username:      ls.Username,
+		Name:          ls.Name,
+		Email:         ls.Email,
+		Groups:        ls.Groups,
+		Exp:           ls.exp.Unix(),
+		RawToken:      ls.rawToken,
+		RefreshToken:  ls.refreshToken,
+		Provider:      ls.provider,
+		ProviderSID:   ls.providerSID,
+		Impersonation: ls.impersonation,
+	}
+	if !ls.refreshExp.IsZero() {
+		rec.RefreshExp = ls.refreshExp.Unix()
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("encode session: %v", err)
+	}
+
+	// Bind the ciphertext to its file name so sessions can't be swapped on disk.
+	sealed := fs.keys.seal(data, []byte(id))
+
+	// Write to a hidden temporary file and rename it so readers never see a
+	// partially written session.
+	tmp, err := ioutil.TempFile(fs.dir, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("create session file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(sealed); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write session file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write session file: %v", err)
+	}
+	if err := os.Chtimes(tmp.Name(), ls.sessionExp(), ls.sessionExp()); err != nil {
+		return fmt.Errorf("set session expiry: %v", err)
+	}
+	return os.Rename(tmp.Name(), fs.path(id))
+}
+
+func (fs *FileSessionStore) writeUserMarker(id string, ls *loginState) error {
+	dir := fs.userDir(ls.UserID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("create user session directory: %v", err)
+	}
+	marker := filepath.Join(dir, id)
+	if err := ioutil.WriteFile(marker, nil, 0600); err != nil {
+		return fmt.Errorf("write user session marker: %v", err)
+	}
+	if err := os.Chtimes(marker, ls.sessionExp(), ls.sessionExp()); err != nil {
+		return fmt.Errorf("set user session marker expiry: %v", err)
+	}
+	return nil
+}
+
+// writeActivity records at as the last activity of session id.
+func (fs *FileSessionStore) writeActivity(id string, at time.Time) error {
+	path := fs.activityPath(id)
+	err := os.Chtimes(path, at, at)
+	if os.IsNotExist(err) {
+		if err = ioutil.WriteFile(path, nil, 0600); err == nil {
+			err = os.Chtimes(path, at, at)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("record session activity: %v", err)
+	}
+	return nil
+}
+
+func (fs *FileSessionStore) getSession(token string) *loginState {
+	ls := fs.readSession(sessionID(token))
+	if ls != nil {
+		ls.sessionToken = token
+	}
+	return ls
+}
+
+func (fs *FileSessionStore) readSession(id string) *loginState {
+	sealed, err := ioutil.ReadFile(fs.path(id))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("failed to read session %s: %v", id, err)
+		}
+		return nil
+	}
+
+	data, err := fs.keys.open(sealed, []byte(id))
+	if err != nil {
+		log.Errorf("failed to decrypt session %s: %v", id, err)
+		return nil
+	}
+
+	var rec sessionRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		log.Errorf("failed to decode session %s: %v", id, err)
+		return nil
+	}
+
+	ls := &loginState{
+		UserID:        rec.UserID,
+		Username:      rec.Username,
+		Name:          rec.Name,
+		Email:         rec.Email,
+		Groups:        rec.Groups,
+		exp:           time.Unix(rec.Exp, 0),
+		now:           fs.now,
+		sessionID:     id,
+		rawToken:      rec.RawToken,
+		refreshToken:  rec.RefreshToken,
+		provider:      rec.Provider,
+		providerSID:   rec.ProviderSID,
+		impersonation: rec.Impersonation,
+	}
+	if ls.Username == "" {
+		// Sessions stored before usernames were kept used the name.
+		ls.Username = rec.Name
+	}
+	if rec.RefreshExp != 0 {
+		ls.refreshExp = time.Unix(rec.RefreshExp, 0)
+	}
+	if info, err := os.Stat(fs.activityPath(id)); err == nil {
+		ls.lastActivity = info.ModTime()
+	}
+	return ls
+}
+
+func (fs *FileSessionStore) updateSession(ls *loginState) error {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+	if _, err := os.Stat(fs.path(ls.sessionID)); err != nil {
+		return fmt.Errorf("session store did not contain session %v", ls.sessionID)
+	}
+	return fs.writeSession(ls.sessionID, ls)
+}
+
+func (fs *FileSessionStore) touchSession(token string, at time.Time) error {
+	id := sessionID(token)
+	if _, err := os.Stat(fs.path(id)); err != nil {
+		return fmt.Errorf("session store did not contain session %v", id)
+	}
+	// An activity file left behind if the session is deleted meanwhile is
+	// pruned with the rest.
+	return fs.writeActivity(id, at)
+}
+
+func (fs *FileSessionStore) deleteSession(token string) error {
+	return fs.deleteSessionByID(sessionID(token))
+}
+
+func (fs *FileSessionStore) listSessions() []*loginState {
+	infos, err := ioutil.ReadDir(fs.dir)
+	if err != nil {
+		log.Errorf("failed to list sessions: %v", err)
+		return nil
+	}
+
+	now := fs.now()
+	var sessions []*loginState
+	for _, info := range infos {
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") || info.ModTime().Before(now) {
+			continue
+		}
+		if ls := fs.readSession(info.Name()); ls != nil {
+			sessions = append(sessions, ls)
+		}
+	}
+	return sessions
+}
+
+func (fs *FileSessionStore) deleteSessionByID(id string) error {
+	if err := os.Remove(fs.path(id)); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("session store did not contain session %v", id)
+		}
+		return fmt.Errorf("delete session %v: %v", id, err)
+	}
+	os.Remove(fs.activityPath(id))
+	return nil
+}
+
+func (fs *FileSessionStore) pruneSessions() {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+
+	now := fs.now()
+	evicted := 0
+	if fs.maxSessionsPerUser > 0 {
+		evicted = fs.prunePerUser(now)
+	}
+
+	infos, err := ioutil.ReadDir(fs.dir)
+	if err != nil {
+		log.Errorf("failed to list sessions: %v", err)
+		return
+	}
+
+	expired := 0
+	var live []os.FileInfo
+	for _, info := range infos {
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		if info.ModTime().Before(now) {
+			fs.removeFile(info.Name())
+			expired++
+			continue
+		}
+		live = append(live, info)
+	}
+	log.Debugf("Pruned %v expired sessions.", expired)
+
+	toRemove := len(live) - fs.maxSessions
+	if toRemove > 0 {
+		log.Debugf("Still too many sessions. Pruning oldest %v sessions...", toRemove)
+		// Sessions expiring first were created first, see MemorySessionStore.addSession.
+		sort.Slice(live, func(i, j int) bool {
+			return live[i].ModTime().Before(live[j].ModTime())
+		})
+		byUser := make(map[string]int)
+		for _, info := range live[:toRemove] {
+			if ls := fs.readSession(info.Name()); ls != nil {
+				byUser[ls.UserID]++
+			}
+			fs.removeFile(info.Name())
+		}
+		logEvictions(byUser, fmt.Sprintf("over the limit of %d sessions", fs.maxSessions))
+		evicted += toRemove
+	}
+	if expired+evicted > 0 {
+		log.Debugf("Pruned %v old sessions.", expired+evicted)
+	}
+	fs.pruneActivity()
+}
+
+// pruneActivity removes the activity files of sessions that no longer exist.
+func (fs *FileSessionStore) pruneActivity() {
+	infos, err := ioutil.ReadDir(filepath.Join(fs.dir, activityDir))
+	if err != nil {
+		log.Errorf("failed to list session activity: %v", err)
+		return
+	}
+	for _, info := range infos {
+		if _, err := os.Stat(fs.path(info.Name())); os.IsNotExist(err) {
+			os.Remove(fs.activityPath(info.Name()))
+		}
+	}
+}
+
+// prunePerUser evicts the oldest sessions of users over the per-user limit
+// and cleans up markers of sessions that no longer exist.
+func (fs *FileSessionStore) prunePerUser(now time.Time) int {
+	userDirs, err := ioutil.ReadDir(filepath.Join(fs.dir, usersDir))
+	if err != nil {
+		log.Errorf("failed to list user sessions: %v", err)
+		return 0
+	}
+
+	evicted := 0
+	byUser := make(map[string]int)
+	for _, userDir := range userDirs {
+		dir := filepath.Join(fs.dir, usersDir, userDir.Name())
+		markers, err := ioutil.ReadDir(dir)
+		if err != nil {
+			log.Errorf("failed to list user sessions: %v", err)
+			continue
+		}
+
+		var live []os.FileInfo
+		for _, marker := range markers {
+			if _, err := os.Stat(fs.path(marker.Name())); err != nil || marker.ModTime().Before(now) {
+				os.Remove(filepath.Join(dir, marker.Name()))
+				continue
+			}
+			live = append(live, marker)
+		}
+
+		toRemove := len(live) - fs.maxSessionsPerUser
+		if toRemove > 0 {
+			sort.Slice(live, func(i, j int) bool {
+				return live[i].ModTime().Before(live[j].ModTime())
+			})
+			for _, marker := range live[:toRemove] {
+				if ls := fs.readSession(marker.Name()); ls != nil {
+					byUser[ls.UserID]++
+				}
+				fs.removeFile(marker.Name())
+				os.Remove(filepath.Join(dir, marker.Name()))
+			}
+			evicted += toRemove
+		}
+
+		if len(live) == 0 {
+			// Fails harmlessly if another replica just added a session.
+			os.Remove(dir)
+		}
+	}
+	logEvictions(byUser, fmt.Sprintf("over the limit of %d sessions per user", fs.maxSessionsPerUser))
+	return evicted
+}
+
+func (fs *FileSessionStore) removeFile(name string) {
+	// Another replica may have pruned the file already.
+	if err := os.Remove(fs.path(name)); err != nil && !os.IsNotExist(err) {
+		log.Errorf("failed to remove session %s: %v", name, err)
+	}
+	os.Remove(fs.activityPath(name))
+}
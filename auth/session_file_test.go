@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestKeySet(t *testing.T) *KeySet {
+	ks, err := NewKeySet(bytes.Repeat([]byte{0x42}, encryptionKeySize))
+	if err != nil {
+		t.Fatalf("NewKeySet error: %v", err)
+	}
+	return ks
+}
+
+func TestFileSessions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge-sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss, err := NewFileSessionStore(dir, newTestKeySet(t), 2, 0)
+	if err != nil {
+		t.Fatalf("NewFileSessionStore error: %v", err)
+	}
+
+	notExpired := time.Now().Add(time.Hour)
+	expired := time.Now().Add(-time.Hour)
+	var tokens []string
+	for i, exp := range []time.Time{expired, notExpired, notExpired.Add(time.Minute), notExpired.Add(2 * time.Minute)} {
+		claims := fmt.Sprintf(`{"sub": "user-id-%d", "email": "user-%d@example.com", "exp": %d}`, i, i, exp.Unix())
+		ls, err := newLoginState("secret-id-token", []byte(claims))
+		if err != nil {
+			t.Fatalf("newLoginState error: %v", err)
+		}
+		if err := ss.addSession(ls); err != nil {
+			t.Fatalf("addSession error: %v", err)
+		}
+		tokens = append(tokens, ls.sessionToken)
+	}
+
+	ls := ss.getSession(tokens[1])
+	if ls == nil {
+		t.Fatal("session not found")
+	}
+	if ls.UserID != "user-id-1" || ls.rawToken != "secret-id-token" || ls.exp.Unix() != notExpired.Unix() {
+		t.Errorf("unexpected session contents: %+v", ls)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("secret-id-token")) || bytes.Contains(data, []byte("user-id")) {
+			t.Errorf("session file %s is not encrypted", f.Name())
+		}
+	}
+
+	// A second store using the same directory sees the same sessions.
+	other, err := NewFileSessionStore(dir, newTestKeySet(t), 2, 0)
+	if err != nil {
+		t.Fatalf("NewFileSessionStore error: %v", err)
+	}
+	other.pruneSessions()
+
+	if ss.getSession(tokens[0]) != nil {
+		t.Error("expired session was not pruned")
+	}
+	if ss.getSession(tokens[1]) != nil {
+		t.Error("oldest session was not pruned")
+	}
+	if ss.getSession(tokens[2]) == nil || ss.getSession(tokens[3]) == nil {
+		t.Error("newest sessions were pruned")
+	}
+
+	impersonating := *ss.getSession(tokens[3])
+	impersonating.impersonation = &Impersonation{Groups: []string{"ops"}}
+	if err := ss.updateSession(&impersonating); err != nil {
+		t.Fatalf("updateSession error: %v", err)
+	}
+	if ls := other.getSession(tokens[3]); ls == nil || !reflect.DeepEqual(ls.impersonation, impersonating.impersonation) {
+		t.Errorf("impersonation of updated session = %+v, want %+v", ls, impersonating.impersonation)
+	}
+
+	if err := ss.deleteSession(tokens[2]); err != nil {
+		t.Fatalf("deleteSession error: %v", err)
+	}
+	if other.getSession(tokens[2]) != nil {
+		t.Error("deleted session still visible")
+	}
+	if err := ss.deleteSession(tokens[2]); err == nil {
+		t.Error("expected error deleting missing session")
+	}
+}
+
+func TestFileSessionsActivity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge-sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss, err := NewFileSessionStore(dir, newTestKeySet(t), 10, 0)
+	if err != nil {
+		t.Fatalf("NewFileSessionStore error: %v", err)
+	}
+	other, err := NewFileSessionStore(dir, newTestKeySet(t), 10, 0)
+	if err != nil {
+		t.Fatalf("NewFileSessionStore error: %v", err)
+	}
+
+	claims := fmt.Sprintf(`{"sub": "user-id", "exp": %d}`, time.Now().Add(time.Hour).Unix())
+	ls, err := newLoginState("id-token-1", []byte(claims))
+	if err != nil {
+		t.Fatalf("newLoginState error: %v", err)
+	}
+	ls.refreshToken = "refresh-token-1"
+	ls.lastActivity = time.Now().Add(-time.Hour)
+	if err := ss.addSession(ls); err != nil {
+		t.Fatalf("addSession error: %v", err)
+	}
+	if got := other.getSession(ls.sessionToken).lastActivity; !got.Equal(ls.lastActivity) {
+		t.Errorf("last activity = %v, want %v", got, ls.lastActivity)
+	}
+
+	// Another replica refreshes the session while it's in use here.
+	refreshed := other.getSession(ls.sessionToken)
+	refreshed.refreshToken = "refresh-token-2"
+	if err := other.updateSession(refreshed); err != nil {
+		t.Fatalf("updateSession error: %v", err)
+	}
+	now := time.Now()
+	if err := ss.touchSession(ls.sessionToken, now); err != nil {
+		t.Fatalf("touchSession error: %v", err)
+	}
+	got := other.getSession(ls.sessionToken)
+	if got.refreshToken != "refresh-token-2" {
+		t.Errorf("refresh token = %q after recording activity, want refresh-token-2", got.refreshToken)
+	}
+	if !got.lastActivity.Equal(now) {
+		t.Errorf("last activity = %v, want %v", got.lastActivity, now)
+	}
+
+	if err := ss.deleteSession(ls.sessionToken); err != nil {
+		t.Fatalf("deleteSession error: %v", err)
+	}
+	if _, err := os.Stat(ss.activityPath(ls.sessionID)); !os.IsNotExist(err) {
+		t.Errorf("activity of deleted session kept: %v", err)
+	}
+	if err := ss.touchSession(ls.sessionToken, now); err == nil {
+		t.Error("expected error recording activity of missing session")
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{0x01}, encryptionKeySize)
+	newKey := bytes.Repeat([]byte{0x02}, encryptionKeySize)
+
+	before, err := NewKeySet(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := NewKeySet(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropped, err := NewKeySet(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed := before.seal([]byte("hello"), []byte("ad"))
+	if got, err := after.open(sealed, []byte("ad")); err != nil || string(got) != "hello" {
+		t.Errorf("rotated key set failed to open old data: %q, %v", got, err)
+	}
+	if _, err := after.open(sealed, []byte("other")); err == nil {
+		t.Error("expected error opening with wrong additional data")
+	}
+	if _, err := dropped.open(sealed, []byte("ad")); err == nil {
+		t.Error("expected error opening with a dropped key")
+	}
+	if _, err := before.open(after.seal([]byte("hello"), nil), nil); err == nil {
+		t.Error("expected new data to be sealed with the newest key")
+	}
+}
+
+func TestFileSessionsPerUserLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge-sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss, err := NewFileSessionStore(dir, newTestKeySet(t), 10, 2)
+	if err != nil {
+		t.Fatalf("NewFileSessionStore error: %v", err)
+	}
+
+	exp := time.Now().Add(time.Hour)
+	var tokens []string
+	for i, sub := range []string{"ci-bot", "alice", "ci-bot", "ci-bot"} {
+		claims := fmt.Sprintf(`{"sub": %q, "exp": %d}`, sub, exp.Add(time.Duration(i)*time.Minute).Unix())
+		ls, err := newLoginState("rando-token-string", []byte(claims))
+		if err != nil {
+			t.Fatalf("newLoginState error: %v", err)
+		}
+		if err := ss.addSession(ls); err != nil {
+			t.Fatalf("addSession error: %v", err)
+		}
+		tokens = append(tokens, ls.sessionToken)
+	}
+
+	ss.pruneSessions()
+
+	for i, wantKept := range []bool{false, true, true, true} {
+		if kept := ss.getSession(tokens[i]) != nil; kept != wantKept {
+			t.Errorf("session %d: kept = %v, want %v", i, kept, wantKept)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+)
+
+// SessionStatus describes how long the user's login lasts. It never includes
+// a token, so it's safe to return to API clients.
+type SessionStatus struct {
+	// AuthSource is "oidc", "openshift", "x509" or "request-header".
+	AuthSource string `json:"authSource"`
+	// Provider is the identity provider the user logged in with. It's empty
+	// if only a single provider is configured.
+	Provider string `json:"provider,omitempty"`
+	// Expires is when the session ends and the user has to log in again. It's
+	// nil if the console can't tell, as with OpenShift, where the expiry of
+	// the access token is only known to the browser's cookie.
+	Expires *time.Time `json:"expires,omitempty"`
+	// TokenExpires is when the session's current ID token expires. Sessions
+	// with a refresh token are renewed before then, until Expires.
+	TokenExpires *time.Time `json:"tokenExpires,omitempty"`
+	// IdleExpires is when the session ends unless the user is active before
+	// then. It's nil if there's no idle timeout.
+	IdleExpires *time.Time `json:"idleExpires,omitempty"`
+}
+
+// SessionStatus returns the status of the session r authenticates with, or
+// ErrSessionNotFound if it has ended or was revoked. Unlike Authenticate, it
+// never renews the session.
+func (a *Authenticator) SessionStatus(r *http.Request) (*SessionStatus, error) {
+	if a.impersonator != nil {
+		id, err := a.impersonator.identify(r)
+		if err != nil {
+			return nil, ErrSessionNotFound
+		}
+		return &SessionStatus{AuthSource: a.impersonator.source, Expires: id.expires}, nil
+	}
+	if a.sessions == nil {
+		// The API server is the only one that knows whether an OpenShift
+		// token is still valid.
+		if _, err := a.userFunc(r); err != nil {
+			return nil, ErrSessionNotFound
+		}
+		status := &SessionStatus{AuthSource: "openshift"}
+		if o := a.openShiftAuth(); a.idleTimeout > 0 && o != nil {
+			session, err := r.Cookie(openshiftSessionCookieName)
+			if err != nil {
+				return nil, ErrSessionNotFound
+			}
+			last, err := o.lastActivity(r, session.Value)
+			if err != nil {
+				return nil, ErrSessionNotFound
+			}
+			if status.IdleExpires = a.idleExpiry(last); status.IdleExpires == nil {
+				return nil, ErrSessionNotFound
+			}
+		}
+		return status, nil
+	}
+
+	ls, err := getLoginState(a.sessions, r)
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+	expires, tokenExpires := ls.sessionExp(), ls.exp
+	status := &SessionStatus{
+		AuthSource:   "oidc",
+		Provider:     ls.provider,
+		Expires:      &expires,
+		TokenExpires: &tokenExpires,
+	}
+	if a.idleTimeout > 0 {
+		if status.IdleExpires = a.idleExpiry(ls.lastActivity); status.IdleExpires == nil {
+			return nil, ErrSessionNotFound
+		}
+	}
+	return status, nil
+}
+
+// idleExpiry returns when a session last active at last idles out, or nil if
+// it already has.
+func (a *Authenticator) idleExpiry(last time.Time) *time.Time {
+	expiry := last.Add(a.idleTimeout)
+	if !time.Now().Before(expiry) {
+		return nil
+	}
+	return &expiry
+}
@@ -2,11 +2,12 @@ package auth
 
 import (
 	"fmt"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
 
-func checkSessions(t *testing.T, ss *SessionStore) {
+func checkSessions(t *testing.T, ss *MemorySessionStore) {
 	if len(ss.byAge) != len(ss.byToken) {
 		t.Fatalf("age: %v != token %v", len(ss.byAge), len(ss.byToken))
 	}
@@ -19,7 +20,7 @@ func checkSessions(t *testing.T, ss *SessionStore) {
 }
 
 func TestSessions(t *testing.T) {
-	ss := NewSessionStore(3)
+	ss := NewMemorySessionStore(3, 0)
 	notExpired := time.Now().Add(time.Duration(3600) * time.Second)
 	expired := time.Now().Add(time.Duration(3600) * time.Second * -1)
 	fakeTokens := []struct {
@@ -73,3 +74,91 @@ func TestSessions(t *testing.T) {
 		t.Fatal("ss.byAge != 2")
 	}
 }
+
+func TestRevokeSessions(t *testing.T) {
+	a, err := makeAuthenticator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.ListSessions(""); err != ErrSessionsNotSupported {
+		t.Fatalf("expected ErrSessionsNotSupported, got %v", err)
+	}
+
+	a.sessions = NewMemorySessionStore(10, 0)
+	exp := time.Now().Add(time.Hour).Unix()
+	var states []*loginState
+	for _, sub := range []string{"alice", "alice", "bob"} {
+		ls, err := newLoginState("rando-token-string", []byte(fmt.Sprintf(`{"sub": %q, "exp": %d}`, sub, exp)))
+		if err != nil {
+			t.Fatalf("newLoginState error: %v", err)
+		}
+		if err := a.sessions.addSession(ls); err != nil {
+			t.Fatalf("addSession error: %v", err)
+		}
+		states = append(states, ls)
+	}
+
+	all, err := a.ListSessions("")
+	if err != nil || len(all) != 3 {
+		t.Fatalf("ListSessions(\"\") = %v, %v; want 3 sessions", all, err)
+	}
+	alice, err := a.ListSessions("alice")
+	if err != nil || len(alice) != 2 {
+		t.Fatalf("ListSessions(alice) = %v, %v; want 2 sessions", alice, err)
+	}
+
+	// Revoking a session cancels its in-flight requests only.
+	r := httptest.NewRequest("GET", "/api/kubernetes/", nil)
+	aliceReq, aliceDone := a.TrackRequest(&User{ID: "alice", SessionID: states[0].sessionID}, r)
+	defer aliceDone()
+	bobReq, bobDone := a.TrackRequest(&User{ID: "bob", SessionID: states[2].sessionID}, r)
+	defer bobDone()
+
+	revoked, err := a.RevokeUserSessions("alice")
+	if err != nil || revoked != 2 {
+		t.Fatalf("RevokeUserSessions(alice) = %d, %v; want 2", revoked, err)
+	}
+	select {
+	case <-aliceReq.Context().Done():
+	default:
+		t.Error("in-flight request of revoked session was not canceled")
+	}
+	if bobReq.Context().Err() != nil {
+		t.Error("in-flight request of another session was canceled")
+	}
+
+	if a.sessions.getSession(states[0].sessionToken) != nil {
+		t.Error("revoked session still in store")
+	}
+	if err := a.RevokeSession(states[0].sessionID); err != ErrSessionNotFound {
+		t.Errorf("expected ErrSessionNotFound, got %v", err)
+	}
+}
+
+func TestSessionsPerUserLimit(t *testing.T) {
+	ss := NewMemorySessionStore(10, 2)
+	exp := time.Now().Add(time.Hour).Unix()
+	var tokens []string
+	for _, sub := range []string{"ci-bot", "alice", "ci-bot", "ci-bot", "ci-bot"} {
+		ls, err := newLoginState("rando-token-string", []byte(fmt.Sprintf(`{"sub": %q, "exp": %d}`, sub, exp)))
+		if err != nil {
+			t.Fatalf("newLoginState error: %v", err)
+		}
+		if err := ss.addSession(ls); err != nil {
+			t.Fatalf("addSession error: %v", err)
+		}
+		tokens = append(tokens, ls.sessionToken)
+	}
+
+	ss.pruneSessions()
+	checkSessions(t, ss)
+
+	if len(ss.byAge) != 3 {
+		t.Fatalf("ss.byAge = %d, want 3", len(ss.byAge))
+	}
+	for i, wantKept := range []bool{false, true, false, true, true} {
+		if kept := ss.getSession(tokens[i]) != nil; kept != wantKept {
+			t.Errorf("session %d: kept = %v, want %v", i, kept, wantKept)
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"sort"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 )
@@ -24,11 +26,12 @@ type ServingInfo struct {
 	BindAddress string `yaml:"bindAddress"`
 	CertFile    string `yaml:"certFile"`
 	KeyFile     string `yaml:"keyFile"`
+	// ClientCA is a CA bundle client certificates are verified against.
+	ClientCA string `yaml:"clientCA"`
 
 	// These fields are defined in `HTTPServingInfo`, but are not supported for console. Fail if any are specified.
 	// https://github.com/openshift/api/blob/0cb4131a7636e1ada6b2769edc9118f0fe6844c8/config/v1/types.go#L7-L38
 	BindNetwork           string        `yaml:"bindNetwork"`
-	ClientCA              string        `yaml:"clientCA"`
 	NamedCertificates     []interface{} `yaml:"namedCertificates"`
 	MinTLSVersion         string        `yaml:"minTLSVersion"`
 	CipherSuites          []string      `yaml:"cipherSuites"`
@@ -49,6 +52,30 @@ type Auth struct {
 	ClientSecretFile    string `yaml:"clientSecretFile"`
 	OAuthEndpointCAFile string `yaml:"oauthEndpointCAFile"`
 	LogoutRedirect      string `yaml:"logoutRedirect"`
+	CookieKeyFile       string `yaml:"cookieKeyFile"`
+	CSRFKeyFile         string `yaml:"csrfKeyFile"`
+	// ScopeProfiles are restricted OAuth scope sets users can log in with,
+	// for example a view-only profile for auditors.
+	ScopeProfiles map[string][]string `yaml:"scopeProfiles"`
+	// Admission decides which users may log in.
+	Admission Admission `yaml:"admission"`
+	// IdleTimeout is how long users may be inactive before they're logged
+	// out, as a duration such as 15m.
+	IdleTimeout string `yaml:"idleTimeout"`
+	// EventLog is the file authentication events are appended to, or - for
+	// stdout.
+	EventLog string `yaml:"eventLog"`
+}
+
+// Admission holds the rules deciding which users may log in. Deny rules take
+// precedence. If any allow rule is set, users must match one of them.
+type Admission struct {
+	AllowedUsers        []string `yaml:"allowedUsers"`
+	DeniedUsers         []string `yaml:"deniedUsers"`
+	AllowedGroups       []string `yaml:"allowedGroups"`
+	DeniedGroups        []string `yaml:"deniedGroups"`
+	AllowedEmailDomains []string `yaml:"allowedEmailDomains"`
+	DeniedEmailDomains  []string `yaml:"deniedEmailDomains"`
 }
 
 // Customization holds configuration such as what logo to use.
@@ -99,15 +126,15 @@ func addServingInfo(fs *flag.FlagSet, servingInfo *ServingInfo) (err error) {
 		fs.Set("tls-key-file", servingInfo.KeyFile)
 	}
 
+	if servingInfo.ClientCA != "" {
+		fs.Set("tls-client-ca-file", servingInfo.ClientCA)
+	}
+
 	// Test for fields specified in HTTPServingInfo that we don't currently support in the console.
 	if servingInfo.BindNetwork != "" {
 		return errors.New("servingInfo.bindNetwork is not supported")
 	}
 
-	if servingInfo.ClientCA != "" {
-		return errors.New("servingInfo.clientCA is not supported")
-	}
-
 	if len(servingInfo.NamedCertificates) > 0 {
 		return errors.New("servingInfo.namedCertificates are not supported")
 	}
@@ -165,6 +192,58 @@ func addAuth(fs *flag.FlagSet, auth *Auth) {
 	if auth.LogoutRedirect != "" {
 		fs.Set("user-auth-logout-redirect", auth.LogoutRedirect)
 	}
+
+	if auth.CookieKeyFile != "" {
+		fs.Set("user-auth-cookie-key-file", auth.CookieKeyFile)
+	}
+
+	if auth.CSRFKeyFile != "" {
+		fs.Set("user-auth-csrf-key-file", auth.CSRFKeyFile)
+	}
+
+	if auth.ScopeProfiles != nil {
+		fs.Set("user-auth-scope-profiles", formatScopeProfiles(auth.ScopeProfiles))
+	}
+
+	if auth.IdleTimeout != "" {
+		fs.Set("user-auth-idle-timeout", auth.IdleTimeout)
+	}
+
+	if auth.EventLog != "" {
+		fs.Set("user-auth-event-log", auth.EventLog)
+	}
+
+	addAdmission(fs, &auth.Admission)
+}
+
+func addAdmission(fs *flag.FlagSet, admission *Admission) {
+	for name, values := range map[string][]string{
+		"user-auth-allowed-users":         admission.AllowedUsers,
+		"user-auth-denied-users":          admission.DeniedUsers,
+		"user-auth-allowed-groups":        admission.AllowedGroups,
+		"user-auth-denied-groups":         admission.DeniedGroups,
+		"user-auth-allowed-email-domains": admission.AllowedEmailDomains,
+		"user-auth-denied-email-domains":  admission.DeniedEmailDomains,
+	} {
+		if len(values) > 0 {
+			fs.Set(name, strings.Join(values, ","))
+		}
+	}
+}
+
+// formatScopeProfiles formats profiles as a --user-auth-scope-profiles value.
+func formatScopeProfiles(profiles map[string][]string) string {
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var values []string
+	for _, name := range names {
+		values = append(values, name+"="+strings.Join(profiles[name], " "))
+	}
+	return strings.Join(values, ",")
 }
 
 func addCustomization(fs *flag.FlagSet, customization *Customization) {
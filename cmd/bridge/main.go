@@ -3,14 +3,15 @@ package main
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/coreos/pkg/capnslog"
 	"github.com/coreos/pkg/flagutil"
@@ -52,13 +53,45 @@ func main() {
 	// See https://github.com/openshift/service-serving-cert-signer
 	fServiceCAFile := fs.String("service-ca-file", "", "CA bundle for OpenShift services signed with the service signing certificates.")
 
-	fUserAuth := fs.String("user-auth", "disabled", "disabled | oidc | openshift")
+	fUserAuth := fs.String("user-auth", "disabled", "disabled | oidc | openshift | x509 | request-header. x509 authenticates users by TLS client certificate, verified against --tls-client-ca-file. request-header trusts the user set in request headers by an authenticating front proxy. Both impersonate users with the --k8s-auth token.")
 	fUserAuthOIDCIssuerURL := fs.String("user-auth-oidc-issuer-url", "", "The OIDC/OAuth2 issuer URL.")
 	fUserAuthOIDCCAFile := fs.String("user-auth-oidc-ca-file", "", "PEM file for the OIDC/OAuth2 issuer.")
 	fUserAuthOIDCClientID := fs.String("user-auth-oidc-client-id", "", "The OIDC OAuth2 Client ID.")
 	fUserAuthOIDCClientSecret := fs.String("user-auth-oidc-client-secret", "", "The OIDC OAuth2 Client Secret.")
 	fUserAuthOIDCClientSecretFile := fs.String("user-auth-oidc-client-secret-file", "", "File containing the OIDC OAuth2 Client Secret.")
-	fUserAuthLogoutRedirect := fs.String("user-auth-logout-redirect", "", "Optional redirect URL on logout needed for some single sign-on identity providers.")
+	fUserAuthOIDCMaxSessionAge := fs.Duration("user-auth-oidc-max-session-age", 24*time.Hour, "How long an OIDC session may be silently renewed with the provider's refresh token before the user has to log in again. 0 disables renewal, ending sessions when the ID token expires.")
+	fUserAuthOIDCOfflineAccess := fs.Bool("user-auth-oidc-offline-access", false, "Request the offline_access scope, which some providers such as Dex require before issuing refresh tokens.")
+	fUserAuthOIDCUsernameClaim := fs.String("user-auth-oidc-username-claim", "sub", "ID token claim holding the user's name in the cluster, for example preferred_username. Nested claims are addressed by dot separated paths.")
+	fUserAuthOIDCNameClaim := fs.String("user-auth-oidc-name-claim", "name", "ID token claim holding the name displayed for the user.")
+	fUserAuthOIDCEmailClaim := fs.String("user-auth-oidc-email-claim", "email", "ID token claim holding the user's email address.")
+	fUserAuthOIDCGroupsClaim := fs.String("user-auth-oidc-groups-claim", "groups", "ID token claim holding the user's groups, for example realm_access.roles.")
+	fUserAuthOIDCRequireEmailVerified := fs.Bool("user-auth-oidc-require-email-verified", false, "Reject logins unless the ID token's email_verified claim is true.")
+	fUserAuthOIDCProvidersFile := fs.String("user-auth-oidc-providers-file", "", "YAML file listing several OIDC providers users can choose from when logging in with --user-auth=oidc. Replaces --user-auth-oidc-issuer-url and the related client flags.")
+	fUserAuthScopeProfiles := fs.String("user-auth-scope-profiles", "view=user:info user:check-access role:view:*", "Restricted OAuth scope sets users can log in with when --user-auth=openshift, as comma separated name=scopes pairs with space separated scopes. Users pick one with /auth/login?scopeProfile=<name>.")
+	fUserAuthPKCE := fs.Bool("user-auth-pkce", true, "Use PKCE (RFC 7636) when logging in. Disable for providers that reject the code_challenge parameter.")
+	fUserAuthOIDCNonce := fs.Bool("user-auth-oidc-nonce", true, "Send a nonce when logging in with --user-auth=oidc and require it in the ID token. Disable for providers that don't return it.")
+	fUserAuthStateCookieSameSite := fs.String("user-auth-state-cookie-samesite", "lax", "lax | none. SameSite attribute of the cookie holding the login state. Use none for providers that POST back to the callback, which requires TLS.")
+	fUserAuthLogoutRedirect := fs.String("user-auth-logout-redirect", "", "Optional redirect URL on logout needed for some single sign-on identity providers. With --user-auth=oidc, providers supporting RP-initiated logout are asked to redirect here, or to --base-address if not set, after ending their session.")
+	fUserAuthCookieKeyFile := fs.String("user-auth-cookie-key-file", "", "File containing base64 encoded 256-bit keys, one per line, used to encrypt the session cookie with --user-auth=openshift. The first key encrypts, every key decrypts. If not set, the access token is stored in the cookie in plain text.")
+	fUserAuthCSRFKeyFile := fs.String("user-auth-csrf-key-file", "", "File containing base64 encoded 256-bit keys, one per line, used to sign CSRF tokens. The first key signs, every key verifies. Defaults to --user-auth-cookie-key-file, or keys derived from --user-auth-session-key-file with --user-auth-session-store=file. Otherwise a random key is used, and tokens only work with the console replica that issued them until it restarts.")
+	fUserAuthEventLog := fs.String("user-auth-event-log", "", "File to append authentication events to as JSON, one per line, for auditing. Use - for stdout. Events include logins, logouts and rejected requests.")
+	fUserAuthGroupImpersonationUser := fs.String("user-auth-group-impersonation-user", auth.DefaultGroupImpersonationUser, "The user the API server is asked to impersonate when a user of --user-auth=oidc or openshift impersonates groups only. The API server requires a user along with groups, so impersonating groups also requires RBAC permission to impersonate this user. It shouldn't exist or have roles of its own.")
+	fUserAuthIdleTimeout := fs.Duration("user-auth-idle-timeout", 0, "Log users out after this long without activity, however long their token is valid. Websockets and watches don't count as activity. With --user-auth=openshift it requires --user-auth-cookie-key-file. 0 disables the idle timeout.")
+	fUserAuthSessionStore := fs.String("user-auth-session-store", "memory", "memory | file. Where OIDC login sessions are kept. Use file with a directory shared by all replicas to keep sessions across restarts and replicas.")
+	fUserAuthSessionDir := fs.String("user-auth-session-dir", "", "Directory holding OIDC login sessions when --user-auth-session-store=file.")
+	fUserAuthSessionKeyFile := fs.String("user-auth-session-key-file", "", "File containing base64 encoded 256-bit keys, one per line, used to encrypt stored sessions. The first key encrypts, every key decrypts.")
+	fUserAuthMaxSessions := fs.Int("user-auth-max-sessions", auth.DefaultMaxSessions, "Maximum number of OIDC login sessions. Once reached, the oldest sessions are evicted.")
+	fUserAuthRequestHeaderCAFile := fs.String("user-auth-request-header-ca-file", "", "PEM encoded CA bundle the front proxy's client certificate must be signed by when --user-auth=request-header. Identity headers are ignored on other connections.")
+	fUserAuthRequestHeaderAllowedNames := fs.String("user-auth-request-header-allowed-names", "", "Comma separated common names the front proxy's client certificate may have. Any name is allowed if empty.")
+	fUserAuthRequestHeaderUsernameHeaders := fs.String("user-auth-request-header-username-headers", "X-Remote-User", "Comma separated request headers checked in order for the username when --user-auth=request-header.")
+	fUserAuthRequestHeaderGroupHeaders := fs.String("user-auth-request-header-group-headers", "X-Remote-Group", "Comma separated request headers holding the user's groups when --user-auth=request-header, one group per header value.")
+	fUserAuthAllowedUsers := fs.String("user-auth-allowed-users", "", "Comma separated users allowed to log in with --user-auth=openshift or oidc. If any allow list is set, users must match one of them.")
+	fUserAuthDeniedUsers := fs.String("user-auth-denied-users", "", "Comma separated users denied login. Deny lists take precedence over allow lists.")
+	fUserAuthAllowedGroups := fs.String("user-auth-allowed-groups", "", "Comma separated groups whose members are allowed to log in.")
+	fUserAuthDeniedGroups := fs.String("user-auth-denied-groups", "", "Comma separated groups whose members are denied login.")
+	fUserAuthAllowedEmailDomains := fs.String("user-auth-allowed-email-domains", "", "Comma separated email domains, for example example.com, whose users are allowed to log in.")
+	fUserAuthDeniedEmailDomains := fs.String("user-auth-denied-email-domains", "", "Comma separated email domains whose users are denied login.")
+	fUserAuthMaxSessionsPerUser := fs.Int("user-auth-max-sessions-per-user", auth.DefaultMaxSessionsPerUser, "Maximum number of OIDC login sessions per user. Once reached, the user's oldest sessions are evicted. 0 means no limit.")
 
 	fK8sMode := fs.String("k8s-mode", "in-cluster", "in-cluster | off-cluster")
 	fK8sModeOffClusterEndpoint := fs.String("k8s-mode-off-cluster-endpoint", "", "URL of the Kubernetes API server.")
@@ -71,13 +104,14 @@ func main() {
 	fPublicDir := fs.String("public-dir", "./frontend/public/dist", "directory containing static web assets.")
 	fTlSCertFile := fs.String("tls-cert-file", "", "TLS certificate. If the certificate is signed by a certificate authority, the certFile should be the concatenation of the server's certificate followed by the CA's certificate.")
 	fTlSKeyFile := fs.String("tls-key-file", "", "The TLS certificate key.")
-	fCAFile := fs.String("ca-file", "", "PEM File containing trusted certificates of trusted CAs. If not present, the system's Root CAs will be used. Not required for in-cluster clients to determine the expiration date for /tectonic/certs endpoint.")
+	fTLSClientCAFile := fs.String("tls-client-ca-file", "", "PEM encoded CA bundle. If set, clients are asked for a certificate, which is verified against the bundle.")
+	fCAFile := fs.String("ca-file", "", "PEM File containing trusted certificates of trusted CAs. If not present, the system's Root CAs will be used. Verifies the API server with --k8s-mode=off-cluster. Not required for in-cluster clients to determine the expiration date for /tectonic/certs endpoint.")
 	fTectonicVersion := fs.String("tectonic-version", "UNKNOWN", "The current tectonic system version, served at /version")
 	fDexClientCertFile := fs.String("dex-client-cert-file", "", "PEM File containing certificates of dex client.")
 	fDexClientKeyFile := fs.String("dex-client-key-file", "", "PEM File containing certificate key of the dex client.")
 	fDexClientCAFile := fs.String("dex-client-ca-file", "", "PEM File containing trusted CAs for Dex client configuration. If blank, defaults to value of ca-file argument")
 
-	fKubectlClientID := fs.String("kubectl-client-id", "", "The OAuth2 client_id of kubectl.")
+	fKubectlClientID := fs.String("kubectl-client-id", "", "The OAuth2 client_id of kubectl. Users log in with it to download a kubeconfig, so its redirect URIs must include /api/console/kubeconfig/callback under --base-address.")
 	fKubectlClientSecret := fs.String("kubectl-client-secret", "", "The OAuth2 client_secret of kubectl.")
 	fKubectlClientSecretFile := fs.String("kubectl-client-secret-file", "", "File containing the OAuth2 client_secret of kubectl.")
 	fK8sPublicEndpoint := fs.String("k8s-public-endpoint", "", "Endpoint to use when rendering kubeconfigs for clients. Useful for when bridge uses an internal endpoint clients can't access for communicating with the API server.")
@@ -191,14 +225,26 @@ func main() {
 
 	var (
 		k8sAuthServiceAccountBearerToken string
+		// Read again when rotated, see watchFile.
+		k8sAuthServiceAccountBearerTokenFile []byte
+		serviceCertPEM                       []byte
+		dexCAPEM                             []byte
+		k8sTransport                         *proxy.Transport
+		monitoringTransport                  *proxy.Transport
+		dexConn                              io.Closer
 	)
 
 	if *fDexClientCertFile != "" && *fDexClientKeyFile != "" && *fDexAPIHost != "" {
 		var err error
 
-		if srv.DexClient, err = auth.NewDexClient(*fDexAPIHost, *fDexClientCAFile, *fDexClientCertFile, *fDexClientKeyFile); err != nil {
+		if srv.DexClient, dexConn, err = auth.NewDexClient(*fDexAPIHost, *fDexClientCAFile, *fDexClientCertFile, *fDexClientKeyFile); err != nil {
 			log.Fatalf("Failed to create a Dex API client: %v", err)
 		}
+		if *fDexClientCAFile != "" {
+			if dexCAPEM, err = ioutil.ReadFile(*fDexClientCAFile); err != nil {
+				log.Fatalf("failed to read Dex client CA file: %v", err)
+			}
+		}
 	}
 
 	var secureCookies bool
@@ -224,48 +270,48 @@ func main() {
 		if err != nil {
 			log.Fatalf("Error inferring Kubernetes config from environment: %v", err)
 		}
-		rootCAs := x509.NewCertPool()
-		if !rootCAs.AppendCertsFromPEM(k8sCertPEM) {
+		rootCAs, err := newCertPool(k8sCertPEM)
+		if err != nil {
 			log.Fatalf("No CA found for the API server")
 		}
-		tlsConfig := &tls.Config{RootCAs: rootCAs}
+		k8sTransport = proxy.NewTransport(&tls.Config{RootCAs: rootCAs})
 
-		bearerToken, err := ioutil.ReadFile(k8sInClusterBearerToken)
+		k8sAuthServiceAccountBearerTokenFile, err = ioutil.ReadFile(k8sInClusterBearerToken)
 		if err != nil {
 			log.Fatalf("failed to read bearer token: %v", err)
 		}
 
 		srv.K8sProxyConfig = &proxy.Config{
-			TLSClientConfig: tlsConfig,
+			Transport:       k8sTransport,
 			HeaderBlacklist: []string{"Cookie", "X-CSRFToken"},
 			Endpoint:        k8sEndpoint,
 		}
 
-		k8sAuthServiceAccountBearerToken = string(bearerToken)
+		k8sAuthServiceAccountBearerToken = string(k8sAuthServiceAccountBearerTokenFile)
 
 		// If running in an OpenShift cluster, set up a proxy to the prometheus-k8s serivce running in the openshift-monitoring namespace.
 		if *fServiceCAFile != "" {
-			serviceCertPEM, err := ioutil.ReadFile(*fServiceCAFile)
+			serviceCertPEM, err = ioutil.ReadFile(*fServiceCAFile)
 			if err != nil {
 				log.Fatalf("failed to read service-ca.crt file: %v", err)
 			}
-			monitoringProxyRootCAs := x509.NewCertPool()
-			if !monitoringProxyRootCAs.AppendCertsFromPEM(serviceCertPEM) {
+			monitoringProxyRootCAs, err := newCertPool(serviceCertPEM)
+			if err != nil {
 				log.Fatalf("no CA found for Kubernetes services")
 			}
-			monitoringProxyTLSConfig := &tls.Config{RootCAs: monitoringProxyRootCAs}
+			monitoringTransport = proxy.NewTransport(&tls.Config{RootCAs: monitoringProxyRootCAs})
 			srv.PrometheusProxyConfig = &proxy.Config{
-				TLSClientConfig: monitoringProxyTLSConfig,
+				Transport:       monitoringTransport,
 				HeaderBlacklist: []string{"Cookie", "X-CSRFToken"},
 				Endpoint:        &url.URL{Scheme: "https", Host: openshiftPrometheusHost, Path: "/api"},
 			}
 			srv.PrometheusTenancyProxyConfig = &proxy.Config{
-				TLSClientConfig: monitoringProxyTLSConfig,
+				Transport:       monitoringTransport,
 				HeaderBlacklist: []string{"Cookie", "X-CSRFToken"},
 				Endpoint:        &url.URL{Scheme: "https", Host: openshiftPrometheusTenancyHost, Path: "/api"},
 			}
 			srv.AlertManagerProxyConfig = &proxy.Config{
-				TLSClientConfig: monitoringProxyTLSConfig,
+				Transport:       monitoringTransport,
 				HeaderBlacklist: []string{"Cookie", "X-CSRFToken"},
 				Endpoint:        &url.URL{Scheme: "https", Host: openshiftAlertManagerHost, Path: "/api"},
 			}
@@ -274,10 +320,22 @@ func main() {
 	case "off-cluster":
 		k8sEndpoint = validateFlagIsURL("k8s-mode-off-cluster-endpoint", *fK8sModeOffClusterEndpoint)
 
+		k8sTLSConfig := &tls.Config{
+			InsecureSkipVerify: *fK8sModeOffClusterSkipVerifyTLS,
+		}
+		if *fCAFile != "" {
+			var err error
+			k8sCertPEM, err = ioutil.ReadFile(*fCAFile)
+			if err != nil {
+				log.Fatalf("failed to read CA file: %v", err)
+			}
+			if k8sTLSConfig.RootCAs, err = newCertPool(k8sCertPEM); err != nil {
+				flagFatalf("ca-file", "no CA found in %s", *fCAFile)
+			}
+		}
+		k8sTransport = proxy.NewTransport(k8sTLSConfig)
 		srv.K8sProxyConfig = &proxy.Config{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: *fK8sModeOffClusterSkipVerifyTLS,
-			},
+			Transport:       k8sTransport,
 			HeaderBlacklist: []string{"Cookie", "X-CSRFToken"},
 			Endpoint:        k8sEndpoint,
 		}
@@ -290,18 +348,49 @@ func main() {
 		apiServerEndpoint = srv.K8sProxyConfig.Endpoint.String()
 	}
 	srv.KubeAPIServerURL = apiServerEndpoint
+	srv.KubeAPIServerCA = k8sCertPEM
 	srv.K8sClient = &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: srv.K8sProxyConfig.TLSClientConfig,
-		},
+		Transport: k8sTransport,
+	}
+
+	var authEventLog *auth.EventLog
+	switch *fUserAuthEventLog {
+	case "":
+	case "-":
+		authEventLog = auth.NewEventLog(os.Stdout)
+	default:
+		f, err := os.OpenFile(*fUserAuthEventLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			log.Fatalf("Failed to open auth event log: %v", err)
+		}
+		authEventLog = auth.NewEventLog(f)
+	}
+
+	var csrfKeys *auth.KeySet
+	if *fUserAuthCSRFKeyFile != "" {
+		var err error
+		if csrfKeys, err = auth.LoadKeySet(*fUserAuthCSRFKeyFile); err != nil {
+			log.Fatalf("Failed to load CSRF keys: %v", err)
+		}
+	} else if (*fUserAuth == "openshift" && *fUserAuthCookieKeyFile == "") || (*fUserAuth == "oidc" && *fUserAuthSessionStore != "file") {
+		log.Warning("CSRF tokens are signed with a random key because user-auth-csrf-key-file is not set, so they only work with this replica until it restarts")
 	}
 
 	switch *fUserAuth {
 	case "oidc", "openshift":
 		validateFlagNotEmpty("base-address", *fBaseAddress)
-		validateFlagNotEmpty("user-auth-oidc-client-id", *fUserAuthOIDCClientID)
+		if *fUserAuthOIDCProvidersFile != "" {
+			if *fUserAuth != "oidc" {
+				flagFatalf("user-auth-oidc-providers-file", "can only be used with --user-auth=\"oidc\"")
+			}
+			if *fUserAuthOIDCIssuerURL != "" || *fUserAuthOIDCClientID != "" || *fKubectlClientID != "" {
+				flagFatalf("user-auth-oidc-providers-file", "cannot be used with --user-auth-oidc-issuer-url, --user-auth-oidc-client-id or --kubectl-client-id")
+			}
+		} else {
+			validateFlagNotEmpty("user-auth-oidc-client-id", *fUserAuthOIDCClientID)
+		}
 
-		if *fUserAuthOIDCClientSecret == "" && *fUserAuthOIDCClientSecretFile == "" {
+		if *fUserAuthOIDCProvidersFile == "" && *fUserAuthOIDCClientSecret == "" && *fUserAuthOIDCClientSecretFile == "" {
 			fmt.Fprintln(os.Stderr, "Must provide either --user-auth-oidc-client-secret or --user-auth-oidc-client-secret-file")
 			os.Exit(1)
 		}
@@ -328,8 +417,6 @@ func main() {
 		if *fUserAuth == "openshift" {
 			// Scopes come from OpenShift documentation
 			// https://docs.openshift.com/container-platform/3.9/architecture/additional_concepts/authentication.html#service-accounts-as-oauth-clients
-			//
-			// TODO(ericchiang): Support other scopes like view only permissions.
 			scopes = []string{"user:full"}
 			authSource = auth.AuthSourceOpenShift
 			if *fUserAuthOIDCIssuerURL != "" {
@@ -337,7 +424,15 @@ func main() {
 			}
 			userAuthOIDCIssuerURL = k8sEndpoint
 		} else {
-			userAuthOIDCIssuerURL = validateFlagIsURL("user-auth-oidc-issuer-url", *fUserAuthOIDCIssuerURL)
+			if *fUserAuthOIDCProvidersFile != "" {
+				// Each provider has its own issuer.
+				userAuthOIDCIssuerURL = &url.URL{}
+			} else {
+				userAuthOIDCIssuerURL = validateFlagIsURL("user-auth-oidc-issuer-url", *fUserAuthOIDCIssuerURL)
+			}
+			if *fUserAuthOIDCOfflineAccess {
+				scopes = append(scopes, "offline_access")
+			}
 		}
 
 		if *fUserAuthOIDCClientSecretFile != "" {
@@ -368,12 +463,129 @@ func main() {
 			CookiePath:    cookiePath,
 			RefererPath:   refererPath,
 			SecureCookies: secureCookies,
+
+			MaxSessionAge: *fUserAuthOIDCMaxSessionAge,
+			IdleTimeout:   *fUserAuthIdleTimeout,
+			EventLog:      authEventLog,
+			CSRFKeys:      csrfKeys,
+
+			PostLogoutRedirectURL: srv.BaseURL.String(),
+
+			DisablePKCE:  !*fUserAuthPKCE,
+			DisableNonce: !*fUserAuthOIDCNonce,
+
+			GroupImpersonationUser: *fUserAuthGroupImpersonationUser,
+		}
+
+		if *fUserAuth == "oidc" {
+			oidcClientConfig.ClaimMapping = auth.ClaimMapping{
+				Username:             *fUserAuthOIDCUsernameClaim,
+				Name:                 *fUserAuthOIDCNameClaim,
+				Email:                *fUserAuthOIDCEmailClaim,
+				Groups:               *fUserAuthOIDCGroupsClaim,
+				RequireEmailVerified: *fUserAuthOIDCRequireEmailVerified,
+			}
+		}
+
+		// Without any rules everyone is admitted.
+		oidcClientConfig.Admission = &auth.AdmissionRules{
+			AllowedUsers:        splitList(*fUserAuthAllowedUsers),
+			DeniedUsers:         splitList(*fUserAuthDeniedUsers),
+			AllowedGroups:       splitList(*fUserAuthAllowedGroups),
+			DeniedGroups:        splitList(*fUserAuthDeniedGroups),
+			AllowedEmailDomains: splitList(*fUserAuthAllowedEmailDomains),
+			DeniedEmailDomains:  splitList(*fUserAuthDeniedEmailDomains),
+		}
+
+		if *fUserAuth == "openshift" && *fUserAuthScopeProfiles != "" {
+			if oidcClientConfig.ScopeProfiles, err = parseScopeProfiles(*fUserAuthScopeProfiles); err != nil {
+				flagFatalf("user-auth-scope-profiles", "%v", err)
+			}
+		}
+
+		if *fUserAuthLogoutRedirect != "" {
+			oidcClientConfig.PostLogoutRedirectURL = logoutRedirect.String()
+		}
+
+		if *fUserAuthOIDCProvidersFile != "" {
+			if oidcClientConfig.Providers, err = loadOIDCProviders(*fUserAuthOIDCProvidersFile, srv.BaseURL, scopes, oidcClientConfig.ClaimMapping); err != nil {
+				log.Fatalf("Failed to load OIDC providers: %v", err)
+			}
+		}
+
+		switch *fUserAuthStateCookieSameSite {
+		case "lax":
+			oidcClientConfig.StateCookieSameSite = http.SameSiteLaxMode
+		case "none":
+			if !secureCookies {
+				flagFatalf("user-auth-state-cookie-samesite", "none requires TLS")
+			}
+			oidcClientConfig.StateCookieSameSite = http.SameSiteNoneMode
+		default:
+			validateFlagIs("user-auth-state-cookie-samesite", *fUserAuthStateCookieSameSite, "lax", "none")
+		}
+
+		if *fUserAuthIdleTimeout < 0 {
+			flagFatalf("user-auth-idle-timeout", "value must not be negative")
+		}
+
+		if *fUserAuth == "openshift" {
+			if *fUserAuthCookieKeyFile != "" {
+				if oidcClientConfig.CookieKeys, err = auth.LoadKeySet(*fUserAuthCookieKeyFile); err != nil {
+					log.Fatalf("Failed to load cookie keys: %v", err)
+				}
+			} else {
+				if *fUserAuthIdleTimeout > 0 {
+					flagFatalf("user-auth-idle-timeout", "requires --user-auth-cookie-key-file with --user-auth=openshift")
+				}
+				log.Warning("session cookies are not encrypted because user-auth-cookie-key-file is not set!")
+			}
+		}
+
+		if *fUserAuth == "oidc" {
+			if *fUserAuthMaxSessions <= 0 {
+				flagFatalf("user-auth-max-sessions", "value must be positive")
+			}
+			if *fUserAuthMaxSessionsPerUser < 0 {
+				flagFatalf("user-auth-max-sessions-per-user", "value must not be negative")
+			}
+
+			switch *fUserAuthSessionStore {
+			case "memory":
+				oidcClientConfig.SessionStore = auth.NewMemorySessionStore(*fUserAuthMaxSessions, *fUserAuthMaxSessionsPerUser)
+			case "file":
+				validateFlagNotEmpty("user-auth-session-dir", *fUserAuthSessionDir)
+				validateFlagNotEmpty("user-auth-session-key-file", *fUserAuthSessionKeyFile)
+				sessionKeys, err := auth.LoadKeySet(*fUserAuthSessionKeyFile)
+				if err != nil {
+					log.Fatalf("Failed to load session keys: %v", err)
+				}
+				if oidcClientConfig.SessionStore, err = auth.NewFileSessionStore(*fUserAuthSessionDir, sessionKeys, *fUserAuthMaxSessions, *fUserAuthMaxSessionsPerUser); err != nil {
+					log.Fatalf("Failed to create session store: %v", err)
+				}
+			default:
+				flagFatalf("user-auth-session-store", "must be one of: memory, file")
+			}
 		}
 
 		// NOTE: This won't work when using the OpenShift auth mode.
 		if *fKubectlClientID != "" {
 			srv.KubectlClientID = *fKubectlClientID
 
+			kubectlClientSecret := *fKubectlClientSecret
+			if *fKubectlClientSecretFile != "" {
+				buf, err := ioutil.ReadFile(*fKubectlClientSecretFile)
+				if err != nil {
+					log.Fatalf("Failed to read kubectl client secret file: %v", err)
+				}
+				kubectlClientSecret = string(buf)
+			}
+			// Users log in with kubectl's client to get a refresh token of
+			// kubectl's own for their kubeconfig.
+			oidcClientConfig.KubectlClientID = *fKubectlClientID
+			oidcClientConfig.KubectlClientSecret = kubectlClientSecret
+			oidcClientConfig.KubectlRedirectURL = proxy.SingleJoiningSlash(srv.BaseURL.String(), server.KubeconfigCallbackEndpoint)
+
 			// Assume kubectl is the client ID trusted by kubernetes, not bridge.
 			// These additional flags causes Dex to issue an ID token valid for
 			// both bridge and kubernetes.
@@ -387,13 +599,58 @@ func main() {
 
 		}
 
-		if srv.Auther, err = auth.NewAuthenticator(context.Background(), oidcClientConfig); err != nil {
+		// Don't wait for the identity provider: it may only come up after the
+		// console. Logins are refused until it's reachable, see /readiness.
+		if srv.Auther, err = auth.StartAuthenticator(context.Background(), oidcClientConfig); err != nil {
+			log.Fatalf("Error initializing authenticator: %v", err)
+		}
+	case "x509", "request-header":
+		validateFlagNotEmpty("base-address", *fBaseAddress)
+		authSource := auth.AuthSourceX509
+		if *fUserAuth == "request-header" {
+			validateFlagNotEmpty("user-auth-request-header-ca-file", *fUserAuthRequestHeaderCAFile)
+			validateFlagNotEmpty("user-auth-request-header-username-headers", *fUserAuthRequestHeaderUsernameHeaders)
+			authSource = auth.AuthSourceRequestHeader
+		} else {
+			validateFlagNotEmpty("tls-client-ca-file", *fTLSClientCAFile)
+		}
+
+		var impersonatorToken string
+		switch *fK8sAuth {
+		case "service-account":
+			validateFlagIs("k8s-mode", *fK8sMode, "in-cluster")
+			impersonatorToken = k8sAuthServiceAccountBearerToken
+		case "bearer-token":
+			validateFlagNotEmpty("k8s-auth-bearer-token", *fK8sAuthBearerToken)
+			impersonatorToken = *fK8sAuthBearerToken
+		default:
+			flagFatalf("k8s-auth", "must be one of: service-account, bearer-token when --user-auth=%s", *fUserAuth)
+		}
+
+		var err error
+		if srv.Auther, err = auth.NewAuthenticator(context.Background(), &auth.Config{
+			AuthSource:        authSource,
+			ImpersonatorToken: impersonatorToken,
+			RedirectURL:       proxy.SingleJoiningSlash(srv.BaseURL.String(), server.AuthLoginCallbackEndpoint),
+			ErrorURL:          proxy.SingleJoiningSlash(srv.BaseURL.String(), server.AuthLoginErrorEndpoint),
+			SuccessURL:        proxy.SingleJoiningSlash(srv.BaseURL.String(), server.AuthLoginSuccessEndpoint),
+			CookiePath:        proxy.SingleJoiningSlash(srv.BaseURL.Path, "/api/"),
+			RefererPath:       srv.BaseURL.String(),
+			SecureCookies:     secureCookies,
+			EventLog:          authEventLog,
+			CSRFKeys:          csrfKeys,
+
+			RequestHeaderClientCA:        *fUserAuthRequestHeaderCAFile,
+			RequestHeaderAllowedNames:    splitList(*fUserAuthRequestHeaderAllowedNames),
+			RequestHeaderUsernameHeaders: splitList(*fUserAuthRequestHeaderUsernameHeaders),
+			RequestHeaderGroupHeaders:    splitList(*fUserAuthRequestHeaderGroupHeaders),
+		}); err != nil {
 			log.Fatalf("Error initializing authenticator: %v", err)
 		}
 	case "disabled":
 		log.Warningf("running with AUTHENTICATION DISABLED!")
 	default:
-		flagFatalf("user-auth", "must be one of: oidc, disabled")
+		flagFatalf("user-auth", "must be one of: oidc, openshift, x509, request-header, disabled")
 	}
 
 	switch *fK8sAuth {
@@ -413,6 +670,61 @@ func main() {
 		flagFatalf("k8s-mode", "must be one of: service-account, bearer-token, oidc")
 	}
 
+	// Pick up the rotated service account token and CA bundles without
+	// restarting. Requests in flight finish with the previous ones.
+	if *fK8sMode == "in-cluster" {
+		watchFile(k8sInClusterBearerToken, k8sAuthServiceAccountBearerTokenFile, func(data []byte) error {
+			token, err := parseBearerToken(data)
+			if err != nil {
+				return err
+			}
+			if *fK8sAuth == "service-account" {
+				srv.SetStaticUser(&auth.User{Token: token})
+				if srv.Auther != nil {
+					srv.Auther.SetImpersonatorToken(token)
+				}
+			}
+			return nil
+		})
+	}
+	if k8sCertPEM != nil {
+		watchFile(caCertFilePath, k8sCertPEM, func(data []byte) error {
+			if err := reloadRootCAs(k8sTransport, data); err != nil {
+				return err
+			}
+			srv.SetKubeAPIServerCA(data)
+			return nil
+		})
+	}
+	if monitoringTransport != nil {
+		watchFile(*fServiceCAFile, serviceCertPEM, func(data []byte) error {
+			return reloadRootCAs(monitoringTransport, data)
+		})
+	}
+	if *fUserAuth == "request-header" {
+		// The listener reloads it too, see clientCAPool.
+		frontProxyCAPEM, err := ioutil.ReadFile(*fUserAuthRequestHeaderCAFile)
+		if err != nil {
+			log.Fatalf("failed to read front proxy CA: %v", err)
+		}
+		watchFile(*fUserAuthRequestHeaderCAFile, frontProxyCAPEM, srv.Auther.SetRequestHeaderClientCA)
+	}
+	if dexCAPEM != nil {
+		watchFile(*fDexClientCAFile, dexCAPEM, func([]byte) error {
+			client, conn, err := auth.NewDexClient(*fDexAPIHost, *fDexClientCAFile, *fDexClientCertFile, *fDexClientKeyFile)
+			if err != nil {
+				return err
+			}
+			srv.SetDexClient(client)
+			// Calls in flight time out long before the previous connection
+			// is closed.
+			previous := dexConn
+			dexConn = conn
+			time.AfterFunc(time.Minute, func() { previous.Close() })
+			return nil
+		})
+	}
+
 	listenURL := validateFlagIsURL("listen", *fListen)
 	switch listenURL.Scheme {
 	case "http":
@@ -428,6 +740,33 @@ func main() {
 		Handler: srv.HTTPHandler(),
 	}
 
+	var clientCAFiles []string
+	if *fTLSClientCAFile != "" {
+		clientCAFiles = append(clientCAFiles, *fTLSClientCAFile)
+	}
+	if *fUserAuth == "request-header" {
+		// The front proxy authenticates with a client certificate.
+		clientCAFiles = append(clientCAFiles, *fUserAuthRequestHeaderCAFile)
+	}
+	if len(clientCAFiles) > 0 {
+		validateFlagIs("listen", listenURL.Scheme, "https")
+		clientCAs := &clientCAPool{files: clientCAFiles}
+		if err := clientCAs.load(); err != nil {
+			log.Fatalf("failed to load client CAs: %v", err)
+		}
+		clientCAs.watch()
+		cert, err := tls.LoadX509KeyPair(*fTlSCertFile, *fTlSKeyFile)
+		if err != nil {
+			log.Fatalf("failed to load TLS certificate: %v", err)
+		}
+		// Don't require certificates, so health checks and users of other
+		// auth modes can connect without one.
+		httpsrv.TLSConfig = clientCAs.tlsConfig(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+		})
+	}
+
 	log.Infof("Binding to %s...", httpsrv.Addr)
 	if listenURL.Scheme == "https" {
 		log.Info("using TLS")
@@ -438,6 +777,39 @@ func main() {
 	}
 }
 
+// parseScopeProfiles parses scope profiles in the format of
+// --user-auth-scope-profiles, for example "view=user:info role:view:*".
+func parseScopeProfiles(value string) (map[string][]string, error) {
+	profiles := make(map[string][]string)
+	for _, profile := range strings.Split(value, ",") {
+		parts := strings.SplitN(profile, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("invalid scope profile %q, expected name=scopes", profile)
+		}
+		scopes := strings.Fields(parts[1])
+		if len(scopes) == 0 {
+			return nil, fmt.Errorf("scope profile %q has no scopes", name)
+		}
+		if _, ok := profiles[name]; ok {
+			return nil, fmt.Errorf("duplicate scope profile %q", name)
+		}
+		profiles[name] = scopes
+	}
+	return profiles, nil
+}
+
+// splitList splits a comma separated flag value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func validateFlagIsURL(name string, value string) *url.URL {
 	validateFlagNotEmpty(name, value)
 
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/openshift/console/auth"
+	"github.com/openshift/console/pkg/proxy"
+	"github.com/openshift/console/server"
+)
+
+// OIDCProviders is the format of the file passed to --user-auth-oidc-providers-file.
+type OIDCProviders struct {
+	Providers []OIDCProvider `yaml:"providers"`
+}
+
+// OIDCProvider configures one of the OIDC providers users can log in with.
+type OIDCProvider struct {
+	// Name identifies the provider in URLs. The provider's redirect URI is
+	// <base-address>/auth/callback/<name>.
+	Name             string   `yaml:"name"`
+	DisplayName      string   `yaml:"displayName"`
+	IssuerURL        string   `yaml:"issuerURL"`
+	CAFile           string   `yaml:"caFile"`
+	ClientID         string   `yaml:"clientID"`
+	ClientSecretFile string   `yaml:"clientSecretFile"`
+	Scopes           []string `yaml:"scopes"`
+	// Claims overrides the claims set by the --user-auth-oidc-*-claim flags.
+	Claims OIDCClaims `yaml:"claims"`
+}
+
+// OIDCClaims names the ID token claims holding the user's details. Nested
+// claims are addressed by dot separated paths, for example realm_access.roles.
+type OIDCClaims struct {
+	Username             string `yaml:"username"`
+	Name                 string `yaml:"name"`
+	Email                string `yaml:"email"`
+	Groups               string `yaml:"groups"`
+	RequireEmailVerified *bool  `yaml:"requireEmailVerified"`
+}
+
+// claimMapping returns the claim mapping of c, with claims it doesn't set
+// taken from defaults.
+func (c OIDCClaims) claimMapping(defaults auth.ClaimMapping) auth.ClaimMapping {
+	m := defaults
+	if c.Username != "" {
+		m.Username = c.Username
+	}
+	if c.Name != "" {
+		m.Name = c.Name
+	}
+	if c.Email != "" {
+		m.Email = c.Email
+	}
+	if c.Groups != "" {
+		m.Groups = c.Groups
+	}
+	if c.RequireEmailVerified != nil {
+		m.RequireEmailVerified = *c.RequireEmailVerified
+	}
+	return m
+}
+
+// loadOIDCProviders reads the providers in filename. Providers without scopes
+// use defaultScopes, and claims they don't set are taken from defaultClaims.
+func loadOIDCProviders(filename string, baseURL *url.URL, defaultScopes []string, defaultClaims auth.ClaimMapping) ([]auth.ProviderConfig, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var file OIDCProviders
+	if err := yaml.UnmarshalStrict(content, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if len(file.Providers) == 0 {
+		return nil, fmt.Errorf("%s: no providers", filename)
+	}
+
+	var providers []auth.ProviderConfig
+	for _, p := range file.Providers {
+		if _, err := url.Parse(p.IssuerURL); err != nil || p.IssuerURL == "" {
+			return nil, fmt.Errorf("%s: provider %q: invalid issuerURL %q", filename, p.Name, p.IssuerURL)
+		}
+		if p.ClientID == "" || p.ClientSecretFile == "" {
+			return nil, fmt.Errorf("%s: provider %q: clientID and clientSecretFile are required", filename, p.Name)
+		}
+		secret, err := ioutil.ReadFile(p.ClientSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: provider %q: failed to read client secret: %v", filename, p.Name, err)
+		}
+
+		scopes := p.Scopes
+		if len(scopes) == 0 {
+			scopes = defaultScopes
+		}
+		providers = append(providers, auth.ProviderConfig{
+			Name:         p.Name,
+			DisplayName:  p.DisplayName,
+			IssuerURL:    p.IssuerURL,
+			IssuerCA:     p.CAFile,
+			ClientID:     p.ClientID,
+			ClientSecret: strings.TrimSpace(string(secret)),
+			Scope:        scopes,
+			RedirectURL:  proxy.SingleJoiningSlash(baseURL.String(), server.AuthLoginCallbackEndpoint+"/"+p.Name),
+			ClaimMapping: p.Claims.claimMapping(defaultClaims),
+		})
+	}
+	return providers, nil
+}
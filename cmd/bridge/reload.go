@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/openshift/console/pkg/proxy"
+)
+
+// fileReloadInterval is how often watched files are checked for changes.
+var fileReloadInterval = time.Minute
+
+// watchFile calls reload with the contents of path whenever they no longer
+// match current. The kubelet rotates projected service account tokens and
+// updates mounted secrets and config maps by swapping symlinks, so the file
+// is polled rather than watched with inotify. If reload fails, the previous
+// contents stay in use and it's retried on the next check.
+func watchFile(path string, current []byte, reload func([]byte) error) {
+	go func() {
+		ticker := time.NewTicker(fileReloadInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				log.Errorf("failed to read %s: %v", path, err)
+				continue
+			}
+			if bytes.Equal(data, current) {
+				continue
+			}
+			if err := reload(data); err != nil {
+				log.Errorf("failed to reload %s: %v", path, err)
+				continue
+			}
+			log.Infof("reloaded %s", path)
+			current = data
+		}
+	}()
+}
+
+// newCertPool returns a pool of the certificates in caPEM.
+func newCertPool(caPEM []byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("no CA found")
+	}
+	return pool, nil
+}
+
+// reloadRootCAs makes transport trust the CAs in caPEM instead of the ones
+// it trusted so far.
+func reloadRootCAs(transport *proxy.Transport, caPEM []byte) error {
+	rootCAs, err := newCertPool(caPEM)
+	if err != nil {
+		return err
+	}
+	tlsConfig := transport.TLSClientConfig().Clone()
+	tlsConfig.RootCAs = rootCAs
+	transport.SetTLSClientConfig(tlsConfig)
+	return nil
+}
+
+// clientCAPool holds the CAs client certificates are verified against,
+// from several files. It's rebuilt when any of them changes.
+type clientCAPool struct {
+	files []string
+	mu    sync.RWMutex
+	pool  *x509.CertPool
+}
+
+// load rebuilds the pool from the files.
+func (c *clientCAPool) load() error {
+	pool := x509.NewCertPool()
+	for _, file := range c.files {
+		caPEM, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("no CA found in %s", file)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pool = pool
+	return nil
+}
+
+// watch reloads the pool whenever one of the files changes.
+func (c *clientCAPool) watch() {
+	for _, file := range c.files {
+		current, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Errorf("failed to read %s: %v", file, err)
+		}
+		watchFile(file, current, func([]byte) error {
+			return c.load()
+		})
+	}
+}
+
+// tlsConfig returns a copy of config that verifies client certificates
+// against the pool at the time of the handshake.
+func (c *clientCAPool) tlsConfig(config *tls.Config) *tls.Config {
+	serverConfig := config.Clone()
+	if len(serverConfig.NextProtos) == 0 {
+		// http.Server only offers HTTP/2 with its own copy of the config,
+		// not with the ones returned by GetConfigForClient.
+		serverConfig.NextProtos = []string{"h2", "http/1.1"}
+	}
+	serverConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		handshakeConfig := serverConfig.Clone()
+		handshakeConfig.GetConfigForClient = nil
+		handshakeConfig.ClientCAs = c.pool
+		return handshakeConfig, nil
+	}
+	return serverConfig
+}
+
+// parseBearerToken checks a token read from a file isn't empty, which it can
+// briefly be while the file is rewritten.
+func parseBearerToken(data []byte) (string, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return "", errors.New("token is empty")
+	}
+	return string(data), nil
+}
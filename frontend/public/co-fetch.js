@@ -3,7 +3,6 @@ import 'whatwg-fetch';
 
 import { analyticsSvc } from './module/analytics';
 import { authSvc } from './module/auth';
-import store from './redux';
 
 const initDefaults = {
   headers: {},
@@ -35,7 +34,7 @@ const validateStatus = (response, url) => {
   }
 
   if (response.status === 401 && shouldLogout(url)) {
-    authSvc.logout(window.location.pathname);
+    authSvc.logout(window.location.pathname + window.location.search);
   }
 
   const contentType = response.headers.get('content-type');
@@ -90,6 +89,11 @@ export const getCSRFToken = () => document && document.cookie && document.cookie
   .filter(c => c.startsWith(cookiePrefix))
   .map(c => c.slice(cookiePrefix.length)).pop();
 
+// Options for requests the console makes on its own, such as polling, rather
+// than for something the user did. Bridge doesn't count them as activity for
+// the session idle timeout.
+export const backgroundRequestOptions = {headers: {'X-Console-Background': 'true'}};
+
 export const coFetch = (url, options = {}, timeout=20000) => {
   const allOptions = _.defaultsDeep({}, initDefaults, options);
   allOptions.headers['X-CSRFToken'] = getCSRFToken();
@@ -115,15 +119,8 @@ export const coFetchUtils = {
 };
 
 export const coFetchJSON = (url, method = 'GET', options = {}) => {
+  // Impersonation headers are sent by bridge, see UIActions.startImpersonate.
   const headers = {Accept: 'application/json'};
-  const {kind, name} = store.getState().UI.get('impersonate', {});
-  if ((kind === 'User' || kind === 'Group') && name) {
-    // Even if we are impersonating a group, we still need to set Impersonate-User to something or k8s will complain
-    headers['Impersonate-User'] = name;
-    if (kind === 'Group') {
-      headers['Impersonate-Group'] = name;
-    }
-  }
   // Pass headers last to let callers to override Accept.
   const allOptions = _.defaultsDeep({method}, options, {headers});
   return coFetch(url, allOptions).then(response => {
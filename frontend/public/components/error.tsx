@@ -17,6 +17,9 @@ const messages = {
     'missing_state': 'There was an error parsing your state cookie',
     'invalid_code': 'There was an error logging you in. Please log out and try again.',
     'invalid_state': 'There was an error verifying your session. Please log out and try again.',
+    'client_certificate_error': 'No valid client certificate was presented. Please check that your browser has a client certificate installed and try again.',
+    'request_header_error': 'The console could not identify you from the headers of the authenticating proxy. Please try again or contact support.',
+    'access_denied': 'You are not allowed to log in to this console. Please contact your administrator if you need access.',
     'default': 'There was an authentication error with the system. Please try again or contact support.',
     'logout_error': 'There was an error logging you out. Please try again.',
   },
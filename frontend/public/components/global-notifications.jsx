@@ -2,8 +2,10 @@ import * as React from 'react';
 
 import { ImpersonateNotifier } from './impersonate-notifier';
 import { KubeAdminNotifier } from './kube-admin-notifier';
+import { SessionNotifier } from './session-notifier';
 
 export const GlobalNotifications = () => <div className="co-global-notifications">
   <KubeAdminNotifier />
   <ImpersonateNotifier />
+  <SessionNotifier />
 </div>;
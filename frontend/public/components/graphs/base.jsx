@@ -4,7 +4,7 @@ import * as PropTypes from 'prop-types';
 import { plot, Plots } from 'plotly.js/lib/core';
 import * as classNames from 'classnames';
 
-import { coFetchJSON } from '../../co-fetch';
+import { backgroundRequestOptions, coFetchJSON } from '../../co-fetch';
 import { SafetyFirst } from '../safety-first';
 
 import { prometheusBasePath, prometheusTenancyBasePath } from './index';
@@ -32,7 +32,7 @@ export class BaseGraph extends SafetyFirst {
     }
   }
 
-  fetch(enablePolling = true) {
+  fetch(enablePolling = true, options = {}) {
     const timeSpan = this.end - this.start || this.timeSpan;
     const end = this.end || Date.now();
     const start = this.start || (end - timeSpan);
@@ -53,7 +53,7 @@ export class BaseGraph extends SafetyFirst {
       const url = this.timeSpan
         ? `${basePath}/api/v1/query_range?query=${encodeURIComponent(q.query)}&start=${start / 1000}&end=${end / 1000}&step=${stepSize}${nsParam}${timeoutParam}`
         : `${basePath}/api/v1/query?query=${encodeURIComponent(q.query)}${nsParam}${timeoutParam}`;
-      return coFetchJSON(url);
+      return coFetchJSON(url, 'GET', options);
     });
     Promise.all(promises)
       .then(data => {
@@ -69,7 +69,7 @@ export class BaseGraph extends SafetyFirst {
         if (enablePolling) {
           this.interval = setTimeout(() => {
             if (this.isMounted_) {
-              this.fetch();
+              this.fetch(true, backgroundRequestOptions);
             }
           }, pollInterval);
         }
@@ -9,7 +9,7 @@ import { k8sBasePath } from '../../module/k8s';
 // Use the shorter 'OpenShift Console' instead of 'OpenShift Container Platform Console' since the title appears in the chart.
 const consoleName = window.SERVER_FLAGS.branding === 'okd' ? 'OKD Console' : 'OpenShift Console';
 
-const fetchHealth = () => coFetch(`${k8sBasePath}/healthz`)
+const fetchHealth = options => coFetch(`${k8sBasePath}/healthz`, options)
   .then(response => response.text())
   .then(body => {
     if (body === 'ok') {
@@ -19,7 +19,7 @@ const fetchHealth = () => coFetch(`${k8sBasePath}/healthz`)
   })
   .catch(errorStatus);
 
-const fetchConsoleHealth = () => coFetchJSON('health')
+const fetchConsoleHealth = options => coFetchJSON('health', 'GET', options)
   .then(() => ({short: 'UP', long: 'All good', status: 'OK'}))
   .catch(() => ({short: 'ERROR', long: 'The console service cannot be reached', status: 'ERROR'}));
 
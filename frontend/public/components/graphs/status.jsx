@@ -3,7 +3,7 @@ import * as React from 'react';
 import { Link } from 'react-router-dom';
 
 import { SafetyFirst } from '../safety-first';
-import { coFetchJSON } from '../../co-fetch';
+import { backgroundRequestOptions, coFetchJSON } from '../../co-fetch';
 import { prometheusBasePath, prometheusTenancyBasePath } from './index';
 
 const colors = {
@@ -29,10 +29,10 @@ export const errorStatus = err => {
   };
 };
 
-const fetchQuery = (q, long, namespace) => {
+const fetchQuery = (q, long, namespace, options) => {
   const nsParam = namespace ? `&namespace=${encodeURIComponent(namespace)}` : '';
   const basePath = namespace ? prometheusTenancyBasePath : prometheusBasePath;
-  return coFetchJSON(`${basePath}/api/v1/query?query=${encodeURIComponent(q)}${nsParam}`)
+  return coFetchJSON(`${basePath}/api/v1/query?query=${encodeURIComponent(q)}${nsParam}`, 'GET', options)
     .then(res => {
       const short = parseInt(_.get(res, 'data.result[0].value[1]'), 10) || 0;
       return {
@@ -55,9 +55,9 @@ export class Status extends SafetyFirst {
     this.clock = 0;
   }
 
-  fetch(props=this.props) {
+  fetch(props=this.props, options = {}) {
     const clock = this.clock;
-    const promise = props.query ? fetchQuery(props.query, props.name, props.namespace) : props.fetch();
+    const promise = props.query ? fetchQuery(props.query, props.name, props.namespace, options) : props.fetch(options);
 
     const ignorePromise = cb => (...args) => {
       if (clock !== this.clock) {
@@ -70,7 +70,7 @@ export class Status extends SafetyFirst {
       .catch(ignorePromise(() => this.setState({short: 'BAD', long: 'Error', status: 'ERROR'})))
       .then(ignorePromise(() => this.interval = setTimeout(() => {
         if (this.isMounted_) {
-          this.fetch();
+          this.fetch(this.props, backgroundRequestOptions);
         }
       }, 30000)));
   }
@@ -9,6 +9,7 @@ import { authSvc } from '../module/auth';
 import { history, Firehose } from './utils';
 import { openshiftHelpBase } from './utils/documentation';
 import { AboutModal } from './about-modal';
+import { errorModal } from './modals';
 import { getAvailableClusterUpdates, clusterVersionReference } from '../module/k8s/cluster-settings';
 
 const UpdatesAvailableButton = ({obj, onClick}) => {
@@ -182,7 +183,7 @@ class MastheadToolbar_ extends React.Component {
       const logout = e => {
         e.preventDefault();
         if (flags[FLAGS.OPENSHIFT]) {
-          authSvc.logoutOpenShift(this.state.isKubeAdmin);
+          authSvc.logoutOpenShift(this.state.isKubeAdmin).catch(err => errorModal({error: err.message}));
         } else {
           authSvc.logout();
         }
@@ -6,7 +6,7 @@ import { Helmet } from 'react-helmet';
 import { connect } from 'react-redux';
 import { Link, Redirect, Route, Switch } from 'react-router-dom';
 
-import { coFetchJSON } from '../co-fetch';
+import { backgroundRequestOptions, coFetchJSON } from '../co-fetch';
 import k8sActions from '../module/k8s/k8s-actions';
 import { alertState, AlertStates, connectToURLs, MonitoringRoutes, silenceState, SilenceStates } from '../monitoring';
 import store from '../redux';
@@ -912,12 +912,12 @@ export class MonitoringUI extends React.Component<null, null> {
   componentDidMount() {
     const poll = (url: string, key: string, dataHandler: (data: any[]) => any): void => {
       store.dispatch(UIActions.monitoringLoading(key));
-      const poller = (): void => {
-        coFetchJSON(url)
+      const poller = (options = {}): void => {
+        coFetchJSON(url, 'GET', options)
           .then(({data}) => dataHandler(data))
           .then(data => store.dispatch(UIActions.monitoringLoaded(key, data)))
           .catch(e => store.dispatch(UIActions.monitoringErrored(key, e)))
-          .then(() => pollerTimeouts[key] = setTimeout(poller, 15 * 1000));
+          .then(() => pollerTimeouts[key] = setTimeout(() => poller(backgroundRequestOptions), 15 * 1000));
       };
       pollers[key] = poller;
       poller();
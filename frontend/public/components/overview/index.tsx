@@ -10,7 +10,7 @@ import { Helmet } from 'react-helmet';
 import { Link } from 'react-router-dom';
 import { Toolbar, EmptyState } from 'patternfly-react';
 
-import { coFetchJSON } from '../../co-fetch';
+import { backgroundRequestOptions, coFetchJSON } from '../../co-fetch';
 import { getBuildNumber } from '../../module/k8s/builds';
 import { prometheusTenancyBasePath } from '../graphs';
 import { TextFilter } from '../factory';
@@ -503,7 +503,7 @@ class OverviewMainContent_ extends React.Component<OverviewMainContentProps, Ove
     }
   }
 
-  fetchMetrics = (): void => {
+  fetchMetrics = (options = {}): void => {
     if (!prometheusTenancyBasePath) {
       return;
     }
@@ -516,7 +516,7 @@ class OverviewMainContent_ extends React.Component<OverviewMainContentProps, Ove
 
     const promises = _.map(queries, (query, name) => {
       const url = `${prometheusTenancyBasePath}/api/v1/query?namespace=${namespace}&query=${encodeURIComponent(query)}`;
-      return coFetchJSON(url).then(({ data: {result} }) => {
+      return coFetchJSON(url, 'GET', options).then(({ data: {result} }) => {
         const byPod: MetricValuesByPod = result.reduce((acc, { metric, value }) => {
           acc[metric.pod_name] = Number(value[1]);
           return acc;
@@ -537,7 +537,7 @@ class OverviewMainContent_ extends React.Component<OverviewMainContentProps, Ove
         throw new Error(`Could not fetch metrics, status: ${status}`);
       }
     }).then(() => {
-      this.metricsInterval = setTimeout(this.fetchMetrics, METRICS_POLL_INTERVAL);
+      this.metricsInterval = setTimeout(() => this.fetchMetrics(backgroundRequestOptions), METRICS_POLL_INTERVAL);
     });
   }
 
@@ -1,7 +1,6 @@
 import * as React from 'react';
 import * as _ from 'lodash-es';
 
-import store from '../redux';
 import { LoadingBox, LoadingInline, Dropdown, ResourceIcon } from './utils';
 import { connectToFlags, FLAGS } from '../features';
 import { Terminal } from './terminal';
@@ -59,16 +58,13 @@ export const PodExec = connectToFlags(FLAGS.OPENSHIFT)(class PodExec extends Rea
       current && current.onConnectionClosed(`connecting to ${activeContainer}`);
     }
 
-    const impersonate = store.getState().UI.get('impersonate', {});
-    const subprotocols = (impersonate.subprotocols || []).concat('base64.channel.k8s.io');
-
     let previous;
     this.ws = new WSFactory(`${metadata.name}-terminal`, {
       host: 'auto',
       reconnect: true,
       path: resourceURL(PodModel, params),
       jsonParse: false,
-      subprotocols,
+      subprotocols: ['base64.channel.k8s.io'],
     })
       .onmessage(raw => {
         const { current } = this.terminal;
@@ -0,0 +1,65 @@
+import * as React from 'react';
+
+import { authSvc } from '../module/auth';
+
+const sessionEventsURL = `${window.SERVER_FLAGS.basePath}api/console/session/events`;
+
+const formatExpiresIn = seconds => {
+  const minutes = Math.ceil(seconds / 60);
+  return minutes > 1 ? `${minutes} minutes` : 'a minute';
+};
+
+// Warns before the session ends, so users can save their changes, for example
+// to a YAML they're editing, before they're logged out.
+export class SessionNotifier extends React.Component {
+  constructor(props) {
+    super(props);
+    this.state = {event: null, expiresIn: null};
+    this.onEvent = this.onEvent.bind(this);
+  }
+
+  componentDidMount() {
+    if (window.SERVER_FLAGS.authDisabled || !window.EventSource) {
+      return;
+    }
+    this.eventSource = new EventSource(sessionEventsURL);
+    ['expiring', 'renewed', 'expired', 'revoked'].forEach(event => this.eventSource.addEventListener(event, this.onEvent));
+  }
+
+  componentWillUnmount() {
+    if (this.eventSource) {
+      this.eventSource.close();
+    }
+  }
+
+  onEvent({type, data}) {
+    if (type === 'expired' || type === 'revoked') {
+      // The stream ends with the session, so don't reconnect.
+      this.eventSource.close();
+    }
+    let expiresIn = null;
+    try {
+      expiresIn = JSON.parse(data).expiresIn;
+    } catch (e) {
+      // The warning doesn't need the remaining time.
+    }
+    this.setState({event: type === 'renewed' ? null : type, expiresIn});
+  }
+
+  render() {
+    const {event, expiresIn} = this.state;
+    if (!event) {
+      return null;
+    }
+    const login = <a onClick={() => authSvc.login(window.location.pathname)}>log in again</a>;
+    return <div className="co-global-notification co-global-notification--warning">
+      <div className="co-global-notification__content">
+        <p className="co-global-notification__text">
+          {event === 'expiring'
+            ? <React.Fragment>Your session ends in {formatExpiresIn(expiresIn || 0)}. Save your changes before then.</React.Fragment>
+            : <React.Fragment>Your session has {event === 'expired' ? 'expired' : 'ended'}. Copy any unsaved changes, then {login} to continue.</React.Fragment>}
+        </p>
+      </div>
+    </div>;
+  }
+}
@@ -21,7 +21,7 @@ import { k8sCreate } from './module/k8s/resource';
 import { types } from './module/k8s/k8s-actions';
 import { coFetchJSON } from './co-fetch';
 import { MonitoringRoutes, setMonitoringURL } from './monitoring';
-import { UIActions } from './ui/ui-actions';
+import { UIActions, impersonationPath, types as uiTypes } from './ui/ui-actions';
 
 /* global
   FLAGS: false,
@@ -194,6 +194,28 @@ const detectUser = dispatch => coFetchJSON('api/kubernetes/apis/user.openshift.i
     },
   );
 
+// Restores who the user impersonates after a reload, bridge keeps it for the session.
+const detectImpersonation = dispatch => coFetchJSON(impersonationPath)
+  .then(
+    ({impersonation}) => {
+      if (!impersonation) {
+        return;
+      }
+      // Groups are impersonated without a user.
+      const isGroup = !impersonation.user && !_.isEmpty(impersonation.groups);
+      dispatch({
+        type: uiTypes.startImpersonate,
+        kind: isGroup ? 'Group' : 'User',
+        name: isGroup ? impersonation.groups[0] : impersonation.user,
+      });
+    },
+    err => {
+      if (!_.includes([401, 403, 404, 500, 501], _.get(err, 'response.status'))) {
+        setTimeout(() => detectImpersonation(dispatch), 15000);
+      }
+    },
+  );
+
 const devopsConsolePath = `${k8sBasePath}/apis/apiextensions.k8s.io/v1beta1/customresourcedefinitions/gitsources.devopsconsole.openshift.io`;
 const detectDevConsole = dispatch => {
   coFetchJSON(devopsConsolePath)
@@ -211,6 +233,7 @@ export const featureActions = [
   detectMonitoringURLs,
   detectClusterVersion,
   detectUser,
+  detectImpersonation,
   detectLoggingURL,
   detectDevConsole,
 ];
@@ -1,5 +1,4 @@
 import { coFetch } from '../co-fetch';
-import { stripBasePath } from '../components/utils/link';
 
 const loginState = key => localStorage.getItem(key);
 
@@ -8,21 +7,10 @@ const loginStateItem = key => loginState(key);
 const userID = 'userID';
 const name = 'name';
 const email = 'email';
-
-const setNext = next => {
-  if (!next) {
-    return;
-  }
-  try {
-    localStorage.setItem('next', stripBasePath(next));
-  } catch (e) {
-    // eslint-disable-next-line no-console
-    console.error(e);
-  }
-};
+const scopeProfile = 'scopeProfile';
 
 const clearLocalStorage = () => {
-  [userID, name, email].forEach(key => {
+  [userID, name, email, scopeProfile].forEach(key => {
     try {
       localStorage.removeItem(key);
     } catch (e) {
@@ -45,26 +33,37 @@ export const authSvc = {
   },
   name: () => loginStateItem(name),
   email: () => loginStateItem(email),
+  // The restricted scope profile the user logged in with, such as 'view', or
+  // empty if they have the default scopes.
+  scopeProfile: () => loginStateItem(scopeProfile) || '',
 
   logout: (next) => {
-    setNext(next);
     clearLocalStorage();
     coFetch(window.SERVER_FLAGS.logoutURL, { method: 'POST' })
-      // eslint-disable-next-line no-console
-      .catch(e => console.error('Error logging out', e))
-      .then(() => {
-        if (window.SERVER_FLAGS.logoutRedirect && !next) {
+      // OIDC providers supporting RP-initiated logout return the URL that ends
+      // the provider's session too.
+      .then(response => response.status === 200 ? response.json() : {})
+      .catch(e => {
+        // eslint-disable-next-line no-console
+        console.error('Error logging out', e);
+        return {};
+      })
+      .then(({endSessionURL}) => {
+        if (endSessionURL && !next) {
+          window.location = endSessionURL;
+        } else if (window.SERVER_FLAGS.logoutRedirect && !next) {
           window.location = window.SERVER_FLAGS.logoutRedirect;
         } else {
-          authSvc.login();
+          authSvc.login(next);
         }
       });
   },
 
-  // Extra steps are needed if this is OpenShift to delete the user's access
-  // token and logout the kube:admin user.
+  // On OpenShift, logging out also revokes the user's access token. If that
+  // fails the user stays logged in and the returned promise is rejected, so
+  // they can try again. The kube:admin user needs an extra step.
   logoutOpenShift: (isKubeAdmin = false) => {
-    return authSvc.deleteOpenShiftToken().then(() => {
+    return coFetch(window.SERVER_FLAGS.logoutURL, { method: 'POST' }).then(() => {
       if (isKubeAdmin) {
         authSvc.logoutKubeAdmin();
       } else {
@@ -73,12 +72,6 @@ export const authSvc = {
     });
   },
 
-  deleteOpenShiftToken: () => {
-    return coFetch('/api/openshift/delete-token', { method: 'POST' })
-      // eslint-disable-next-line no-console
-      .catch(e => console.error('Error deleting token', e));
-  },
-
   // The kube:admin user has a special logout flow. The OAuth server has a
   // session cookie that must be cleared by POSTing to the kube:admin logout
   // endpoint, otherwise the user will be logged in again immediately after
@@ -109,7 +102,10 @@ export const authSvc = {
       });
   },
 
-  login: () => {
-    window.location = window.SERVER_FLAGS.loginURL;
+  // `next` is a path on the console to return to after logging in.
+  login: (next) => {
+    window.location = next
+      ? `${window.SERVER_FLAGS.loginURL}?then=${encodeURIComponent(next)}`
+      : window.SERVER_FLAGS.loginURL;
   },
 };
@@ -6,7 +6,7 @@ import { cacheResources, getResources as getResources_ } from './get-resources';
 import { k8sList, k8sWatch, k8sGet } from './resource';
 import { makeReduxID } from '../../components/utils/k8s-watcher';
 import { APIServiceModel } from '../../models';
-import { coFetchJSON } from '../../co-fetch';
+import { backgroundRequestOptions, coFetchJSON } from '../../co-fetch';
 import { referenceForModel } from './k8s';
 
 const types = {
@@ -58,14 +58,14 @@ const actions = {
     k8sList(APIServiceModel, {})
       .then(() => dispatch(actions.watchK8sList(makeReduxID(APIServiceModel, {}), {}, APIServiceModel, actions.getResources)))
       .catch((e) => {
-        const poller = () => coFetchJSON('api/kubernetes/apis').then(d => {
+        const poller = (options = {}) => coFetchJSON('api/kubernetes/apis', 'GET', options).then(d => {
           if (d.groups.length !== getState().k8s.getIn(['RESOURCES', apiGroups], 0)) {
             dispatch(actions.getResources());
           }
           dispatch({type: types.setAPIGroups, value: d.groups.length});
         });
 
-        POLLs[apiGroups] = setInterval(poller, 30 * 1000);
+        POLLs[apiGroups] = setInterval(() => poller(backgroundRequestOptions), 30 * 1000);
         poller();
       });
   },
@@ -86,7 +86,7 @@ const actions = {
     return {id, name, value, type: types.filterList};
   },
 
-  watchK8sObject: (id, name, namespace, query, k8sType) => (dispatch, getState) => {
+  watchK8sObject: (id, name, namespace, query, k8sType) => dispatch => {
     if (id in REF_COUNTS) {
       REF_COUNTS[id] += 1;
       return nop;
@@ -99,14 +99,14 @@ const actions = {
       delete query.name;
     }
 
-    const poller = () => {
-      k8sGet(k8sType, name, namespace)
+    const poller = (options = {}) => {
+      k8sGet(k8sType, name, namespace, undefined, options)
         .then(
           o => dispatch(actions.modifyObject(id, o)),
           e => dispatch(actions.errored(id, e))
         );
     };
-    POLLs[id] = setInterval(poller, 30 * 1000);
+    POLLs[id] = setInterval(() => poller(backgroundRequestOptions), 30 * 1000);
     poller();
 
     if (!_.get(k8sType, 'verbs', ['watch']).includes('watch')) {
@@ -115,9 +115,7 @@ const actions = {
       return;
     }
 
-    const {subprotocols} = getState().UI.get('impersonate', {});
-
-    WS[id] = k8sWatch(k8sType, {...query, subprotocols}).onbulkmessage(events =>
+    WS[id] = k8sWatch(k8sType, query).onbulkmessage(events =>
       events.forEach(e => dispatch(actions.modifyObject(id, e.object)))
     );
   },
@@ -140,7 +138,7 @@ const actions = {
     return {type: types.stopK8sWatch, id};
   },
 
-  watchK8sList: (id, query, k8skind, extraAction) => (dispatch, getState) => {
+  watchK8sList: (id, query, k8skind, extraAction) => dispatch => {
     // Only one watch per unique list ID
     if (id in REF_COUNTS) {
       REF_COUNTS[id] += 1;
@@ -150,14 +148,14 @@ const actions = {
     dispatch({type: types.watchK8sList, id, query});
     REF_COUNTS[id] = 1;
 
-    const incrementallyLoad = async(continueToken = ''): Promise<string> => {
+    const incrementallyLoad = async(options: object, continueToken = ''): Promise<string> => {
       // the list may not still be around...
       if (!REF_COUNTS[id]) {
         // let .then handle the cleanup
         return;
       }
 
-      const response = await k8sList(k8skind, {...query, limit: paginationLimit, ...(continueToken ? {continue: continueToken} : {})}, true);
+      const response = await k8sList(k8skind, {...query, limit: paginationLimit, ...(continueToken ? {continue: continueToken} : {})}, true, options);
 
       if (!REF_COUNTS[id]) {
         return;
@@ -170,7 +168,7 @@ const actions = {
       }
 
       if (response.metadata.continue) {
-        return incrementallyLoad(response.metadata.continue);
+        return incrementallyLoad(options, response.metadata.continue);
       }
       return response.metadata.resourceVersion;
     };
@@ -181,11 +179,11 @@ const actions = {
      *   1. the WS closes abnormally
      *   2. the WS can not establish a connection within $TIMEOUT
      */
-    const pollAndWatch = async() => {
+    const pollAndWatch = async(options = {}) => {
       delete POLLs[id];
 
       try {
-        const resourceVersion = await incrementallyLoad();
+        const resourceVersion = await incrementallyLoad(options);
         // ensure this watch should still exist because pollAndWatch is recursiveish
         if (!REF_COUNTS[id]) {
           // eslint-disable-next-line no-console
@@ -207,8 +205,7 @@ const actions = {
           return;
         }
 
-        const {subprotocols} = getState().UI.get('impersonate', {});
-        WS[id] = k8sWatch(k8skind, {...query, resourceVersion}, {subprotocols, timeout: 60 * 1000});
+        WS[id] = k8sWatch(k8skind, {...query, resourceVersion}, {timeout: 60 * 1000});
       } catch (e) {
         if (!REF_COUNTS[id]) {
           // eslint-disable-next-line no-console
@@ -221,7 +218,7 @@ const actions = {
         dispatch(actions.errored(id, e));
 
         if (!POLLs[id]) {
-          POLLs[id] = setTimeout(pollAndWatch, 15 * 1000);
+          POLLs[id] = setTimeout(() => pollAndWatch(backgroundRequestOptions), 15 * 1000);
         }
         return;
       }
@@ -253,7 +250,7 @@ const actions = {
             return;
           }
 
-          POLLs[id] = setTimeout(pollAndWatch, 15 * 1000);
+          POLLs[id] = setTimeout(() => pollAndWatch(backgroundRequestOptions), 15 * 1000);
         })
         .onbulkmessage(events => [actions.updateListFromWS, extraAction].forEach(f => f && dispatch(f(id, events))));
     };
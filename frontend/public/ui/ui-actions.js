@@ -1,6 +1,5 @@
-import { Base64 } from 'js-base64';
-
 import store from '../redux';
+import { coFetchJSON } from '../co-fetch';
 import { history } from '../components/utils/router';
 import {
   ALL_NAMESPACES_KEY,
@@ -25,6 +24,8 @@ allModels().forEach((v, k) => {
   namespacedResources.add(v.plural);
 });
 
+export const impersonationPath = 'api/console/impersonation';
+
 export const getActiveNamespace = () => store.getState().UI.get('activeNamespace');
 
 export const formatNamespacedRouteForResource = (resource, activeNamespace=getActiveNamespace()) => {
@@ -132,18 +133,6 @@ export const UIActions = {
   },
 
   [types.startImpersonate]: (kind, name) => async(dispatch, getState) => {
-    let textEncoder;
-    try {
-      textEncoder = new TextEncoder('utf-8');
-    } catch (e) {
-      // eslint-disable-next-line no-console
-      console.info('Browser lacks TextEncoder. Falling back to polyfill.', e);
-    }
-
-    if (!textEncoder) {
-      textEncoder = await import('text-encoding').then(module => new module.TextEncoder('utf-8'));
-    }
-
     const imp = getState().UI.get('impersonate', {});
     if ((imp.name && imp.name !== name) || (imp.kin && imp.kind !== kind)) {
       // eslint-disable-next-line no-console
@@ -151,26 +140,15 @@ export const UIActions = {
       return;
     }
 
-    /* Subprotocols are comma-separated, so commas aren't allowed. Also "="
-     * and "/" aren't allowed, so base64 but replace illegal chars.
-     */
-    let encodedName = textEncoder.encode(name);
-    encodedName = Base64.encode(String.fromCharCode.apply(String, encodedName));
-    encodedName = encodedName.replace(/=/g, '_').replace(/\//g, '-');
-
-    let subprotocols;
-    if (kind === 'User' ) {
-      subprotocols = [`Impersonate-User.${encodedName}`];
-    }
-    if (kind === 'Group') {
-      subprotocols = [`Impersonate-Group.${encodedName}`];
-    }
+    // Bridge sends the impersonation headers for every request from now on.
+    await coFetchJSON.post(impersonationPath, kind === 'Group' ? {groups: [name]} : {user: name});
 
-    dispatch({kind, name, subprotocols, type: types.startImpersonate});
+    dispatch({kind, name, type: types.startImpersonate});
     history.push(window.SERVER_FLAGS.basePath);
   },
 
-  [types.stopImpersonate]: () => dispatch => {
+  [types.stopImpersonate]: () => async dispatch => {
+    await coFetchJSON.delete(impersonationPath);
     dispatch({type: types.stopImpersonate});
     history.push(window.SERVER_FLAGS.basePath);
   },
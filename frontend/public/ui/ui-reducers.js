@@ -68,7 +68,7 @@ export default (state, action) => {
     }
 
     case types.startImpersonate:
-      return state.set('impersonate', {kind: action.kind, name: action.name, subprotocols: action.subprotocols});
+      return state.set('impersonate', {kind: action.kind, name: action.name});
 
     case types.stopImpersonate:
       return state.delete('impersonate');
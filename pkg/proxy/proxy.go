@@ -2,10 +2,8 @@ package proxy
 
 import (
 	"crypto/tls"
-	"encoding/base64"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -23,11 +21,15 @@ type Config struct {
 	HeaderBlacklist []string
 	Endpoint        *url.URL
 	TLSClientConfig *tls.Config
-	Origin          string
+	// Transport, if set, is used instead of TLSClientConfig so the TLS
+	// config can be replaced while the proxy is running.
+	Transport *Transport
+	Origin    string
 }
 
 type Proxy struct {
 	reverseProxy *httputil.ReverseProxy
+	transport    *Transport
 	config       *Config
 }
 
@@ -40,15 +42,9 @@ func filterHeaders(r *http.Response) error {
 }
 
 func NewProxy(cfg *Config) *Proxy {
-	// Copy of http.DefaultTransport with TLSClientConfig added
-	transport := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		Dial: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-		}).Dial,
-		TLSClientConfig:     cfg.TLSClientConfig,
-		TLSHandshakeTimeout: 10 * time.Second,
+	transport := cfg.Transport
+	if transport == nil {
+		transport = NewTransport(cfg.TLSClientConfig)
 	}
 
 	reverseProxy := httputil.NewSingleHostReverseProxy(cfg.Endpoint)
@@ -58,6 +54,7 @@ func NewProxy(cfg *Config) *Proxy {
 
 	proxy := &Proxy{
 		reverseProxy: reverseProxy,
+		transport:    transport,
 		config:       cfg,
 	}
 
@@ -76,15 +73,6 @@ func SingleJoiningSlash(a, b string) string {
 	return a + b
 }
 
-// decodeSubprotocol decodes the impersonation "headers" on a websocket.
-// Subprotocols don't allow '=' or '/'
-func decodeSubprotocol(encodedProtocol string) (string, error) {
-	encodedProtocol = strings.Replace(encodedProtocol, "_", "=", -1)
-	encodedProtocol = strings.Replace(encodedProtocol, "-", "/", -1)
-	decodedProtocol, err := base64.StdEncoding.DecodeString(encodedProtocol)
-	return string(decodedProtocol), err
-}
-
 var headerBlacklist = []string{"Cookie", "X-CSRFToken"}
 
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -125,41 +113,18 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	proxiedHeader := make(http.Header, len(r.Header))
 	for key, value := range r.Header {
 		if key != "Sec-Websocket-Protocol" {
-			// Do not proxy the subprotocol to the API server because k8s does not understand what we're sending
-			proxiedHeader.Set(key, r.Header.Get(key))
+			// Keep every value, there may be several Impersonate-Group headers.
+			proxiedHeader[key] = value
 			continue
 		}
 
+		// Impersonation subprotocols were replaced with headers by
+		// auth.User.SetAuthHeaders, so only k8s subprotocols are left.
 		for _, protocols := range value {
 			for _, protocol := range strings.Split(protocols, ",") {
 				protocol = strings.TrimSpace(protocol)
-				// TODO: secure by stripping newlines & other invalid stuff
-				// "Impersonate-User" and "Impersonate-Group" and bridge specific (not a k8s thing)
-				if strings.HasPrefix(protocol, "Impersonate-User.") {
-					encodedProtocol := strings.TrimPrefix(protocol, "Impersonate-User.")
-					decodedProtocol, err := decodeSubprotocol(encodedProtocol)
-					if err != nil {
-						errMsg := fmt.Sprintf("Error decoding Impersonate-User subprotocol: %v", err)
-						http.Error(w, errMsg, http.StatusBadRequest)
-						return
-					}
-					proxiedHeader.Set("Impersonate-User", decodedProtocol)
-					subProtocol = protocol
-				} else if strings.HasPrefix(protocol, "Impersonate-Group.") {
-					encodedProtocol := strings.TrimPrefix(protocol, "Impersonate-Group.")
-					decodedProtocol, err := decodeSubprotocol(encodedProtocol)
-					if err != nil {
-						errMsg := fmt.Sprintf("Error decoding Impersonate-Group subprotocol: %v", err)
-						http.Error(w, errMsg, http.StatusBadRequest)
-						return
-					}
-					proxiedHeader.Set("Impersonate-User", string(decodedProtocol))
-					proxiedHeader.Set("Impersonate-Group", string(decodedProtocol))
-					subProtocol = protocol
-				} else {
-					proxiedHeader.Set("Sec-Websocket-Protocol", protocol)
-					subProtocol = protocol
-				}
+				proxiedHeader.Set("Sec-Websocket-Protocol", protocol)
+				subProtocol = protocol
 			}
 		}
 	}
@@ -182,7 +147,7 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	proxiedHeader.Add("Origin", "http://localhost")
 
 	dialer := &websocket.Dialer{
-		TLSClientConfig: p.config.TLSClientConfig,
+		TLSClientConfig: p.transport.TLSClientConfig(),
 	}
 
 	backend, resp, err := dialer.Dial(r.URL.String(), proxiedHeader)
@@ -248,6 +213,9 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		case <-errc:
 			// Only wait for a single error and let the defers close both connections.
 			return
+		case <-r.Context().Done():
+			// The request was canceled, for example because the session was revoked.
+			return
 		case <-ticker.C:
 			writeMutex.Lock()
 			// Send pings to client to prevent load balancers and other middlemen from closing the connection early
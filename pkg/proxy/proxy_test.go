@@ -1,6 +1,8 @@
 package proxy
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -63,32 +65,26 @@ func TestProxyHTTP(t *testing.T) {
 
 }
 
-func TestProxyDecodeSubprotocol(t *testing.T) {
-	tests := []struct {
-		encoded string
-		decoded string
-	}{
-		{
-			encoded: "8J2SnPCdkpzwnZKc8J2SnA__",
-			decoded: "𝒜𝒜𝒜𝒜",
-		},
-		{
-			encoded: "bm9uLXByaXY_",
-			decoded: "non-priv",
-		},
-		{
-			encoded: "8J2SnPCfjYbwn42RQPCfjYbwn42R4oiGw6XLhs+GzrHOtc+Czr-Ouc6xz4nOp86oz4zOufCdkpzOm86azqPOs8+BzrvOus+DzpLOps6+4oiC",
-			decoded: "𝒜🍆🍑@🍆🍑∆åˆφαεςοιαωΧΨόι𝒜ΛΚΣγρλκσΒΦξ∂",
-		},
+func TestTransportSetTLSClientConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(staticServer))
+	defer server.Close()
+
+	transport := NewTransport(&tls.Config{RootCAs: x509.NewCertPool()})
+	client := &http.Client{Transport: transport}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Fatal("server certificate accepted without its CA")
 	}
-	for _, test := range tests {
-		decoded, err := decodeSubprotocol(test.encoded)
-		if err != nil {
-			t.Fatalf("err decoding subprotocol %v: %v", test.encoded, err)
-		}
-		if decoded != test.decoded {
-			t.Errorf("decoded %v as %v but expected %v", test.encoded, decoded, test.decoded)
-		}
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+	transport.SetTLSClientConfig(&tls.Config{RootCAs: rootCAs})
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("server certificate rejected after reloading its CA: %v", err)
+	}
+	res.Body.Close()
+	if transport.TLSClientConfig().RootCAs != rootCAs {
+		t.Error("TLSClientConfig wasn't replaced")
 	}
 }
 
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Transport is an http.RoundTripper whose TLS config can be replaced while
+// it's in use, for example when a rotated CA bundle is reloaded.
+type Transport struct {
+	mu        sync.RWMutex
+	tlsConfig *tls.Config
+	transport *http.Transport
+}
+
+// NewTransport returns a Transport using tlsConfig.
+func NewTransport(tlsConfig *tls.Config) *Transport {
+	return &Transport{
+		tlsConfig: tlsConfig,
+		transport: newHTTPTransport(tlsConfig),
+	}
+}
+
+// Copy of http.DefaultTransport with TLSClientConfig added
+func newHTTPTransport(tlsConfig *tls.Config) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+		// Also closes the connections replaced transports leave idle.
+		IdleConnTimeout: 90 * time.Second,
+	}
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.mu.RLock()
+	transport := t.transport
+	t.mu.RUnlock()
+	return transport.RoundTrip(r)
+}
+
+// TLSClientConfig returns the TLS config new connections are made with.
+func (t *Transport) TLSClientConfig() *tls.Config {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tlsConfig
+}
+
+// SetTLSClientConfig makes new requests use tlsConfig. Requests in flight
+// finish on the connections they started on.
+func (t *Transport) SetTLSClientConfig(tlsConfig *tls.Config) {
+	t.mu.Lock()
+	old := t.transport
+	t.tlsConfig = tlsConfig
+	t.transport = newHTTPTransport(tlsConfig)
+	t.mu.Unlock()
+	old.CloseIdleConnections()
+}
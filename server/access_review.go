@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/openshift/console/auth"
+	"github.com/openshift/console/pkg/proxy"
+)
+
+const selfSubjectAccessReviewPath = "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews"
+
+type resourceAttributes struct {
+	Namespace   string `json:"namespace,omitempty"`
+	Verb        string `json:"verb"`
+	Group       string `json:"group"`
+	Resource    string `json:"resource"`
+	Subresource string `json:"subresource,omitempty"`
+	Name        string `json:"name,omitempty"`
+}
+
+// canI asks the API server, as user, whether user may perform the action
+// described by attrs.
+func (s *Server) canI(user *auth.User, attrs resourceAttributes) (bool, error) {
+	review := struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+		Spec       struct {
+			ResourceAttributes resourceAttributes `json:"resourceAttributes"`
+		} `json:"spec"`
+	}{
+		APIVersion: "authorization.k8s.io/v1",
+		Kind:       "SelfSubjectAccessReview",
+	}
+	review.Spec.ResourceAttributes = attrs
+
+	body, err := json.Marshal(review)
+	if err != nil {
+		return false, err
+	}
+
+	url := proxy.SingleJoiningSlash(s.K8sProxyConfig.Endpoint.String(), selfSubjectAccessReviewPath)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create access review request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	user.SetAuthHeaders(req.Header)
+
+	resp, err := s.K8sClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("access review request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("access review request failed: %s", resp.Status)
+	}
+
+	var result struct {
+		Status struct {
+			Allowed bool `json:"allowed"`
+		} `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("failed to decode access review response: %v", err)
+	}
+	return result.Status.Allowed, nil
+}
+
+// isClusterAdmin reports whether user may perform any verb on any resource.
+func (s *Server) isClusterAdmin(user *auth.User) (bool, error) {
+	return s.canI(user, resourceAttributes{Verb: "*", Group: "*", Resource: "*"})
+}
+
+// clusterAdminHandler wraps a handler so it's only called for cluster admins.
+func (s *Server) clusterAdminHandler(hf func(*auth.User, http.ResponseWriter, *http.Request)) func(*auth.User, http.ResponseWriter, *http.Request) {
+	return func(user *auth.User, w http.ResponseWriter, r *http.Request) {
+		allowed, err := s.isClusterAdmin(user)
+		if err != nil {
+			plog.Errorf("failed to check cluster admin access for %q: %v", user.Username, err)
+			sendResponse(w, http.StatusBadGateway, apiError{"Failed to verify access"})
+			return
+		}
+		if !allowed {
+			sendResponse(w, http.StatusForbidden, apiError{"Access denied: cluster admin required"})
+			return
+		}
+		hf(user, w, r)
+	}
+}
+
+// directUserHandler wraps a handler that sends the user's token to a service
+// that doesn't honor impersonation, such as Prometheus behind an OAuth proxy.
+// Impersonated users are refused there, since the console's own token would
+// be checked instead of theirs.
+func (s *Server) directUserHandler(hf func(*auth.User, http.ResponseWriter, *http.Request)) func(*auth.User, http.ResponseWriter, *http.Request) {
+	return func(user *auth.User, w http.ResponseWriter, r *http.Request) {
+		if user.Impersonate {
+			sendResponse(w, http.StatusForbidden, apiError{"Not available to users authenticated by the console"})
+			return
+		}
+		hf(user, w, r)
+	}
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/dex/api"
+
+	"github.com/openshift/console/auth"
+)
+
+const (
+	dexPasswordsEndpoint  = "/api/dex/passwords"
+	dexClientsEndpoint    = "/api/dex/clients"
+	dexConnectorsEndpoint = "/api/dex/connectors"
+	dexVersionEndpoint    = "/api/dex/version"
+
+	// dexAPIGroup is the API group Dex stores its resources in on
+	// Kubernetes. Access to the Dex API is authorized against it.
+	dexAPIGroup = "dex.coreos.com"
+
+	dexTimeout = 10 * time.Second
+)
+
+// dexPassword is a Dex static password. The hash is accepted when creating
+// and updating passwords, but never returned.
+type dexPassword struct {
+	Email    string `json:"email"`
+	Username string `json:"username"`
+	UserID   string `json:"userID"`
+	// Hash is a bcrypt hash of the password. Dex doesn't accept plain text
+	// passwords.
+	Hash string `json:"hash,omitempty"`
+}
+
+// dexClient is a Dex OAuth2 client.
+type dexClient struct {
+	ID           string   `json:"id"`
+	Secret       string   `json:"secret,omitempty"`
+	RedirectURIs []string `json:"redirectURIs"`
+	TrustedPeers []string `json:"trustedPeers"`
+	Public       bool     `json:"public"`
+	Name         string   `json:"name"`
+	LogoURL      string   `json:"logoURL"`
+}
+
+type dexVersion struct {
+	Server string `json:"server"`
+	API    int32  `json:"api"`
+}
+
+// dexHandler wraps a Dex API handler so it's only called for users allowed to
+// manage resource in dexAPIGroup. The verb is derived from the request method.
+func (s *Server) dexHandler(resource string, hf func(*auth.User, http.ResponseWriter, *http.Request)) func(*auth.User, http.ResponseWriter, *http.Request) {
+	return func(user *auth.User, w http.ResponseWriter, r *http.Request) {
+		verb := map[string]string{
+			"GET":    "list",
+			"POST":   "create",
+			"PUT":    "update",
+			"DELETE": "delete",
+		}[r.Method]
+		if verb == "" {
+			sendResponse(w, http.StatusMethodNotAllowed, apiError{"Invalid method: only GET, POST, PUT and DELETE are allowed"})
+			return
+		}
+
+		allowed, err := s.canI(user, resourceAttributes{Verb: verb, Group: dexAPIGroup, Resource: resource})
+		if err != nil {
+			plog.Errorf("failed to check Dex %s access for %q: %v", resource, user.Username, err)
+			sendResponse(w, http.StatusBadGateway, apiError{"Failed to verify access"})
+			return
+		}
+		if !allowed {
+			sendResponse(w, http.StatusForbidden, apiError{"Access denied: cannot " + verb + " " + resource + "." + dexAPIGroup})
+			return
+		}
+		hf(user, w, r)
+	}
+}
+
+func sendDexError(w http.ResponseWriter, err error) {
+	plog.Errorf("Dex API request failed: %v", err)
+	sendResponse(w, http.StatusBadGateway, apiError{"Dex API request failed: " + err.Error()})
+}
+
+func decodeDexRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		sendResponse(w, http.StatusBadRequest, apiError{"Invalid request body: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+// isBcryptHash reports whether hash looks like a bcrypt hash. Dex rejects
+// anything else, but with a less helpful error.
+func isBcryptHash(hash string) bool {
+	return len(hash) == 60 &&
+		(strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$"))
+}
+
+// handleDexPasswords manages Dex static passwords.
+//
+//	GET    /api/dex/passwords          lists passwords, without hashes
+//	POST   /api/dex/passwords          creates a password
+//	PUT    /api/dex/passwords/<email>  changes the hash or username of a password
+//	DELETE /api/dex/passwords/<email>  deletes a password
+func (s *Server) handleDexPasswords(user *auth.User, w http.ResponseWriter, r *http.Request) {
+	email := s.pathID(r, dexPasswordsEndpoint)
+	ctx, cancel := context.WithTimeout(r.Context(), dexTimeout)
+	defer cancel()
+
+	switch {
+	case r.Method == "GET" && email == "":
+		resp, err := s.dexClient().ListPasswords(ctx, &api.ListPasswordReq{})
+		if err != nil {
+			sendDexError(w, err)
+			return
+		}
+		passwords := make([]dexPassword, 0, len(resp.Passwords))
+		for _, p := range resp.Passwords {
+			passwords = append(passwords, dexPassword{Email: p.Email, Username: p.Username, UserID: p.UserId})
+		}
+		sendResponse(w, http.StatusOK, passwords)
+	case r.Method == "POST" && email == "":
+		var p dexPassword
+		if !decodeDexRequest(w, r, &p) {
+			return
+		}
+		if p.Email == "" || p.UserID == "" {
+			sendResponse(w, http.StatusBadRequest, apiError{"email and userID are required"})
+			return
+		}
+		if !isBcryptHash(p.Hash) {
+			sendResponse(w, http.StatusBadRequest, apiError{"hash must be a bcrypt hash"})
+			return
+		}
+		resp, err := s.dexClient().CreatePassword(ctx, &api.CreatePasswordReq{Password: &api.Password{
+			Email:    p.Email,
+			Hash:     []byte(p.Hash),
+			Username: p.Username,
+			UserId:   p.UserID,
+		}})
+		if err != nil {
+			sendDexError(w, err)
+			return
+		}
+		if resp.AlreadyExists {
+			sendResponse(w, http.StatusConflict, apiError{"A password for " + p.Email + " already exists"})
+			return
+		}
+		plog.Infof("user %q created Dex password for %q", user.Username, p.Email)
+		p.Hash = ""
+		sendResponse(w, http.StatusCreated, p)
+	case r.Method == "PUT" && email != "":
+		var p dexPassword
+		if !decodeDexRequest(w, r, &p) {
+			return
+		}
+		if p.Hash == "" && p.Username == "" {
+			sendResponse(w, http.StatusBadRequest, apiError{"hash or username is required"})
+			return
+		}
+		if p.Hash != "" && !isBcryptHash(p.Hash) {
+			sendResponse(w, http.StatusBadRequest, apiError{"hash must be a bcrypt hash"})
+			return
+		}
+		req := &api.UpdatePasswordReq{Email: email, NewUsername: p.Username}
+		if p.Hash != "" {
+			req.NewHash = []byte(p.Hash)
+		}
+		resp, err := s.dexClient().UpdatePassword(ctx, req)
+		if err != nil {
+			sendDexError(w, err)
+			return
+		}
+		if resp.NotFound {
+			sendResponse(w, http.StatusNotFound, apiError{"No password for " + email})
+			return
+		}
+		plog.Infof("user %q updated Dex password for %q", user.Username, email)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "DELETE" && email != "":
+		resp, err := s.dexClient().DeletePassword(ctx, &api.DeletePasswordReq{Email: email})
+		if err != nil {
+			sendDexError(w, err)
+			return
+		}
+		if resp.NotFound {
+			sendResponse(w, http.StatusNotFound, apiError{"No password for " + email})
+			return
+		}
+		plog.Infof("user %q deleted Dex password for %q", user.Username, email)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		sendResponse(w, http.StatusMethodNotAllowed, apiError{"Invalid method for " + r.URL.Path})
+	}
+}
+
+// handleDexClients manages Dex OAuth2 clients.
+//
+//	POST   /api/dex/clients       creates a client and returns it, including its secret
+//	DELETE /api/dex/clients/<id>  deletes a client
+//
+// The Dex API has no calls to list or update clients, so those requests fail
+// with 501 Not Implemented.
+func (s *Server) handleDexClients(user *auth.User, w http.ResponseWriter, r *http.Request) {
+	id := s.pathID(r, dexClientsEndpoint)
+	ctx, cancel := context.WithTimeout(r.Context(), dexTimeout)
+	defer cancel()
+
+	switch {
+	case r.Method == "GET" || r.Method == "PUT":
+		sendResponse(w, http.StatusNotImplemented, apiError{"The Dex API does not support listing or updating clients"})
+	case r.Method == "POST" && id == "":
+		var c dexClient
+		if !decodeDexRequest(w, r, &c) {
+			return
+		}
+		if c.ID == "" {
+			sendResponse(w, http.StatusBadRequest, apiError{"id is required"})
+			return
+		}
+		resp, err := s.dexClient().CreateClient(ctx, &api.CreateClientReq{Client: &api.Client{
+			Id:           c.ID,
+			Secret:       c.Secret,
+			RedirectUris: c.RedirectURIs,
+			TrustedPeers: c.TrustedPeers,
+			Public:       c.Public,
+			Name:         c.Name,
+			LogoUrl:      c.LogoURL,
+		}})
+		if err != nil {
+			sendDexError(w, err)
+			return
+		}
+		if resp.AlreadyExists {
+			sendResponse(w, http.StatusConflict, apiError{"Client " + c.ID + " already exists"})
+			return
+		}
+		plog.Infof("user %q created Dex client %q", user.Username, c.ID)
+		if created := resp.Client; created != nil {
+			// Dex generates a secret if none was given.
+			c = dexClient{
+				ID:           created.Id,
+				Secret:       created.Secret,
+				RedirectURIs: created.RedirectUris,
+				TrustedPeers: created.TrustedPeers,
+				Public:       created.Public,
+				Name:         created.Name,
+				LogoURL:      created.LogoUrl,
+			}
+		}
+		sendResponse(w, http.StatusCreated, c)
+	case r.Method == "DELETE" && id != "":
+		resp, err := s.dexClient().DeleteClient(ctx, &api.DeleteClientReq{Id: id})
+		if err != nil {
+			sendDexError(w, err)
+			return
+		}
+		if resp.NotFound {
+			sendResponse(w, http.StatusNotFound, apiError{"Client " + id + " not found"})
+			return
+		}
+		plog.Infof("user %q deleted Dex client %q", user.Username, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		sendResponse(w, http.StatusMethodNotAllowed, apiError{"Invalid method for " + r.URL.Path})
+	}
+}
+
+// handleDexConnectors would list the connectors configured in Dex, but the
+// Dex API has no call to read them yet.
+func (s *Server) handleDexConnectors(user *auth.User, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		sendResponse(w, http.StatusMethodNotAllowed, apiError{"Invalid method: only GET is allowed"})
+		return
+	}
+	sendResponse(w, http.StatusNotImplemented, apiError{"The Dex API does not support listing connectors"})
+}
+
+// handleDexVersion returns the version of Dex and its API, so clients can
+// tell which calls are supported.
+func (s *Server) handleDexVersion(user *auth.User, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		sendResponse(w, http.StatusMethodNotAllowed, apiError{"Invalid method: only GET is allowed"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), dexTimeout)
+	defer cancel()
+	resp, err := s.dexClient().GetVersion(ctx, &api.VersionReq{})
+	if err != nil {
+		sendDexError(w, err)
+		return
+	}
+	sendResponse(w, http.StatusOK, dexVersion{Server: resp.Server, API: resp.Api})
+}
@@ -0,0 +1,319 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/coreos/dex/api"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/openshift/console/auth"
+	"github.com/openshift/console/pkg/proxy"
+)
+
+// fakeDex is an in-memory Dex API server.
+type fakeDex struct {
+	mux       sync.Mutex
+	passwords map[string]api.Password
+	clients   map[string]api.Client
+}
+
+func (d *fakeDex) CreateClient(ctx context.Context, req *api.CreateClientReq) (*api.CreateClientResp, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if _, ok := d.clients[req.Client.Id]; ok {
+		return &api.CreateClientResp{AlreadyExists: true}, nil
+	}
+	c := *req.Client
+	if c.Secret == "" {
+		c.Secret = "generated-secret"
+	}
+	d.clients[c.Id] = c
+	return &api.CreateClientResp{Client: &c}, nil
+}
+
+func (d *fakeDex) DeleteClient(ctx context.Context, req *api.DeleteClientReq) (*api.DeleteClientResp, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if _, ok := d.clients[req.Id]; !ok {
+		return &api.DeleteClientResp{NotFound: true}, nil
+	}
+	delete(d.clients, req.Id)
+	return &api.DeleteClientResp{}, nil
+}
+
+func (d *fakeDex) CreatePassword(ctx context.Context, req *api.CreatePasswordReq) (*api.CreatePasswordResp, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if _, ok := d.passwords[req.Password.Email]; ok {
+		return &api.CreatePasswordResp{AlreadyExists: true}, nil
+	}
+	d.passwords[req.Password.Email] = *req.Password
+	return &api.CreatePasswordResp{}, nil
+}
+
+func (d *fakeDex) UpdatePassword(ctx context.Context, req *api.UpdatePasswordReq) (*api.UpdatePasswordResp, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	p, ok := d.passwords[req.Email]
+	if !ok {
+		return &api.UpdatePasswordResp{NotFound: true}, nil
+	}
+	if req.NewHash != nil {
+		p.Hash = req.NewHash
+	}
+	if req.NewUsername != "" {
+		p.Username = req.NewUsername
+	}
+	d.passwords[req.Email] = p
+	return &api.UpdatePasswordResp{}, nil
+}
+
+func (d *fakeDex) DeletePassword(ctx context.Context, req *api.DeletePasswordReq) (*api.DeletePasswordResp, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if _, ok := d.passwords[req.Email]; !ok {
+		return &api.DeletePasswordResp{NotFound: true}, nil
+	}
+	delete(d.passwords, req.Email)
+	return &api.DeletePasswordResp{}, nil
+}
+
+func (d *fakeDex) ListPasswords(ctx context.Context, req *api.ListPasswordReq) (*api.ListPasswordResp, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	resp := &api.ListPasswordResp{}
+	for email := range d.passwords {
+		p := d.passwords[email]
+		resp.Passwords = append(resp.Passwords, &p)
+	}
+	return resp, nil
+}
+
+func (d *fakeDex) password(email string) (api.Password, bool) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	p, ok := d.passwords[email]
+	return p, ok
+}
+
+func (d *fakeDex) client(id string) (api.Client, bool) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	c, ok := d.clients[id]
+	return c, ok
+}
+
+func (d *fakeDex) GetVersion(ctx context.Context, req *api.VersionReq) (*api.VersionResp, error) {
+	return &api.VersionResp{Server: "v2.10.0", Api: 2}, nil
+}
+
+func (d *fakeDex) ListRefresh(ctx context.Context, req *api.ListRefreshReq) (*api.ListRefreshResp, error) {
+	return &api.ListRefreshResp{}, nil
+}
+
+func (d *fakeDex) RevokeRefresh(ctx context.Context, req *api.RevokeRefreshReq) (*api.RevokeRefreshResp, error) {
+	return &api.RevokeRefreshResp{}, nil
+}
+
+// newTestDexServer starts a Dex API server and a fake API server that allows
+// the "admin-token" user to do anything with Dex resources. The returned func
+// stops both.
+func newTestDexServer(t *testing.T) (*Server, *fakeDex, func()) {
+	dex := &fakeDex{
+		passwords: make(map[string]api.Password),
+		clients:   make(map[string]api.Client),
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs := grpc.NewServer()
+	api.RegisterDexServer(gs, dex)
+	go gs.Serve(l)
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		gs.Stop()
+		t.Fatal(err)
+	}
+
+	k8s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var review struct {
+			Spec struct {
+				ResourceAttributes resourceAttributes `json:"resourceAttributes"`
+			} `json:"spec"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		allowed := r.Header.Get("Authorization") == "Bearer admin-token" &&
+			review.Spec.ResourceAttributes.Group == dexAPIGroup
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": map[string]bool{"allowed": allowed},
+		})
+	}))
+	closer := func() {
+		k8s.Close()
+		conn.Close()
+		gs.Stop()
+	}
+	endpoint, err := url.Parse(k8s.URL)
+	if err != nil {
+		closer()
+		t.Fatal(err)
+	}
+
+	return &Server{
+		BaseURL:        &url.URL{Path: "/"},
+		K8sProxyConfig: &proxy.Config{Endpoint: endpoint},
+		K8sClient:      http.DefaultClient,
+		DexClient:      api.NewDexClient(conn),
+	}, dex, closer
+}
+
+func dexRequest(s *Server, user *auth.User, resource string, hf func(*auth.User, http.ResponseWriter, *http.Request), method, path string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	w := httptest.NewRecorder()
+	s.dexHandler(resource, hf)(user, w, httptest.NewRequest(method, path, &buf))
+	return w
+}
+
+func TestDexPasswords(t *testing.T) {
+	s, dex, closer := newTestDexServer(t)
+	defer closer()
+	admin := &auth.User{Username: "kube:admin", Token: "admin-token"}
+	hash := "$2a$10$" + strings.Repeat("x", 53)
+
+	w := dexRequest(s, admin, "passwords", s.handleDexPasswords, "POST", dexPasswordsEndpoint,
+		dexPassword{Email: "jane@example.com", Username: "jane", UserID: "1", Hash: hash})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	if strings.Contains(w.Body.String(), hash) {
+		t.Error("create: response contains the password hash")
+	}
+	if p, _ := dex.password("jane@example.com"); string(p.Hash) != hash {
+		t.Errorf("create: stored hash = %q, want %q", p.Hash, hash)
+	}
+
+	w = dexRequest(s, admin, "passwords", s.handleDexPasswords, "POST", dexPasswordsEndpoint,
+		dexPassword{Email: "jane@example.com", UserID: "1", Hash: hash})
+	if w.Code != http.StatusConflict {
+		t.Errorf("create again: status = %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	w = dexRequest(s, admin, "passwords", s.handleDexPasswords, "POST", dexPasswordsEndpoint,
+		dexPassword{Email: "joe@example.com", UserID: "2", Hash: "hunter2"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("create with plain text password: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w = dexRequest(s, admin, "passwords", s.handleDexPasswords, "PUT", dexPasswordsEndpoint+"/jane@example.com",
+		dexPassword{Username: "janedoe"})
+	if w.Code != http.StatusNoContent {
+		t.Errorf("update: status = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+	}
+
+	w = dexRequest(s, admin, "passwords", s.handleDexPasswords, "GET", dexPasswordsEndpoint, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("list: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if strings.Contains(w.Body.String(), hash) {
+		t.Error("list: response contains the password hash")
+	}
+	var passwords []dexPassword
+	if err := json.Unmarshal(w.Body.Bytes(), &passwords); err != nil {
+		t.Fatal(err)
+	}
+	want := []dexPassword{{Email: "jane@example.com", Username: "janedoe", UserID: "1"}}
+	if !reflect.DeepEqual(passwords, want) {
+		t.Errorf("list = %+v, want %+v", passwords, want)
+	}
+
+	w = dexRequest(s, admin, "passwords", s.handleDexPasswords, "DELETE", dexPasswordsEndpoint+"/jane@example.com", nil)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("delete: status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	w = dexRequest(s, admin, "passwords", s.handleDexPasswords, "DELETE", dexPasswordsEndpoint+"/jane@example.com", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("delete again: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestDexClients(t *testing.T) {
+	s, dex, closer := newTestDexServer(t)
+	defer closer()
+	admin := &auth.User{Username: "kube:admin", Token: "admin-token"}
+
+	w := dexRequest(s, admin, "oauth2clients", s.handleDexClients, "POST", dexClientsEndpoint,
+		dexClient{ID: "grafana", Name: "Grafana", RedirectURIs: []string{"https://grafana.example.com/login"}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	var created dexClient
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Secret != "generated-secret" {
+		t.Errorf("create: secret = %q, want the secret Dex generated", created.Secret)
+	}
+	if _, ok := dex.client("grafana"); !ok {
+		t.Error("create: client not stored in Dex")
+	}
+
+	for _, method := range []string{"GET", "PUT"} {
+		w = dexRequest(s, admin, "oauth2clients", s.handleDexClients, method, dexClientsEndpoint+"/grafana", dexClient{})
+		if w.Code != http.StatusNotImplemented {
+			t.Errorf("%s: status = %d, want %d", method, w.Code, http.StatusNotImplemented)
+		}
+	}
+
+	w = dexRequest(s, admin, "oauth2clients", s.handleDexClients, "DELETE", dexClientsEndpoint+"/grafana", nil)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("delete: status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if _, ok := dex.client("grafana"); ok {
+		t.Error("delete: client still stored in Dex")
+	}
+
+	w = dexRequest(s, admin, "connectors", s.handleDexConnectors, "GET", dexConnectorsEndpoint, nil)
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("connectors: status = %d, want %d", w.Code, http.StatusNotImplemented)
+	}
+}
+
+func TestDexAccessDenied(t *testing.T) {
+	s, dex, closer := newTestDexServer(t)
+	defer closer()
+	dex.mux.Lock()
+	dex.passwords["jane@example.com"] = api.Password{Email: "jane@example.com"}
+	dex.mux.Unlock()
+	developer := &auth.User{Username: "developer", Token: "developer-token"}
+
+	w := dexRequest(s, developer, "passwords", s.handleDexPasswords, "GET", dexPasswordsEndpoint, nil)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("list: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	w = dexRequest(s, developer, "passwords", s.handleDexPasswords, "DELETE", dexPasswordsEndpoint+"/jane@example.com", nil)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("delete: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if _, ok := dex.password("jane@example.com"); !ok {
+		t.Error("password deleted without access")
+	}
+}
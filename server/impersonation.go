@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/openshift/console/auth"
+)
+
+const impersonationEndpoint = "/api/console/impersonation"
+
+type impersonationResponse struct {
+	// Impersonation is null if the user isn't impersonating anyone.
+	Impersonation *auth.Impersonation `json:"impersonation"`
+}
+
+// impersonator starts and stops impersonations, see auth.Authenticator.
+type impersonator interface {
+	StartImpersonation(w http.ResponseWriter, r *http.Request, user *auth.User, imp *auth.Impersonation) error
+	StopImpersonation(w http.ResponseWriter, r *http.Request, user *auth.User)
+	GroupImpersonationUser() string
+}
+
+// handleImpersonation lets users act as another user or group. The console
+// sends the impersonation headers itself, and the API server checks that the
+// user may impersonate. Impersonating groups only also means impersonating
+// the user set by --user-auth-group-impersonation-user
+// (system:console:group-member by default), because the API server requires
+// a user along with groups. Users must be allowed to impersonate that user as
+// well as the groups. Impersonations the user isn't allowed are refused with
+// 403 Forbidden when they're started.
+//
+//	GET    /api/console/impersonation  returns who the user impersonates
+//	POST   /api/console/impersonation  starts impersonating the posted user, groups and extras
+//	DELETE /api/console/impersonation  stops impersonating
+func (s *Server) handleImpersonation(impersonator impersonator) func(*auth.User, http.ResponseWriter, *http.Request) {
+	return func(user *auth.User, w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			sendResponse(w, http.StatusOK, impersonationResponse{user.Impersonating})
+		case "POST":
+			var imp auth.Impersonation
+			if err := json.NewDecoder(r.Body).Decode(&imp); err != nil {
+				sendResponse(w, http.StatusBadRequest, apiError{"Invalid impersonation: " + err.Error()})
+				return
+			}
+			if err := imp.Validate(); err != nil {
+				sendResponse(w, http.StatusBadRequest, apiError{"Invalid impersonation: " + err.Error()})
+				return
+			}
+			if user.Impersonate {
+				sendResponse(w, http.StatusNotImplemented, apiError{auth.ErrImpersonationNotSupported.Error()})
+				return
+			}
+			if denied, err := s.impersonationDenied(user, &imp, impersonator.GroupImpersonationUser()); err != nil {
+				plog.Errorf("failed to check impersonation access for %q: %v", user.Username, err)
+				sendResponse(w, http.StatusBadGateway, apiError{"Failed to verify access"})
+				return
+			} else if denied != "" {
+				sendResponse(w, http.StatusForbidden, apiError{"Access denied: not allowed to impersonate " + denied})
+				return
+			}
+			if err := impersonator.StartImpersonation(w, r, user, &imp); err != nil {
+				code := http.StatusInternalServerError
+				if err == auth.ErrImpersonationNotSupported {
+					code = http.StatusNotImplemented
+				}
+				sendResponse(w, code, apiError{err.Error()})
+				return
+			}
+			sendResponse(w, http.StatusOK, impersonationResponse{&imp})
+		case "DELETE":
+			impersonator.StopImpersonation(w, r, user)
+			sendResponse(w, http.StatusOK, impersonationResponse{})
+		default:
+			sendResponse(w, http.StatusMethodNotAllowed, apiError{"Invalid method: only GET, POST and DELETE are allowed"})
+		}
+	}
+}
+
+// impersonationCheck is an access review for part of an impersonation, and
+// what's denied if it fails.
+type impersonationCheck struct {
+	attrs  resourceAttributes
+	denied string
+}
+
+// impersonationDenied asks the API server whether user may impersonate
+// everything imp impersonates, the same checks the API server makes on every
+// request. It returns what the user may not impersonate, or "" if they may
+// impersonate all of it.
+func (s *Server) impersonationDenied(user *auth.User, imp *auth.Impersonation, groupUser string) (string, error) {
+	// Ask as the user themselves, not as whoever they impersonate now.
+	self := *user
+	self.Impersonating = nil
+
+	impersonatedUser := imp.ImpersonatedUser(groupUser)
+	checks := []impersonationCheck{{
+		attrs:  resourceAttributes{Verb: "impersonate", Resource: "users", Name: impersonatedUser},
+		denied: fmt.Sprintf("user %q", impersonatedUser),
+	}}
+	if imp.User == "" {
+		checks[0].denied = fmt.Sprintf("user %q, which is required to impersonate groups", impersonatedUser)
+	}
+	for _, group := range imp.Groups {
+		checks = append(checks, impersonationCheck{
+			attrs:  resourceAttributes{Verb: "impersonate", Resource: "groups", Name: group},
+			denied: fmt.Sprintf("group %q", group),
+		})
+	}
+	for key, values := range imp.Extra {
+		for _, v := range values {
+			checks = append(checks, impersonationCheck{
+				attrs:  resourceAttributes{Verb: "impersonate", Group: "authentication.k8s.io", Resource: "userextras", Subresource: key, Name: v},
+				denied: fmt.Sprintf("value %q of extra %q", v, key),
+			})
+		}
+	}
+
+	for _, check := range checks {
+		allowed, err := s.canI(&self, check.attrs)
+		if err != nil {
+			return "", err
+		}
+		if !allowed {
+			return check.denied, nil
+		}
+	}
+	return "", nil
+}
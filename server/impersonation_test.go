@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/openshift/console/auth"
+	"github.com/openshift/console/pkg/proxy"
+)
+
+// fakeImpersonator records the impersonation started last.
+type fakeImpersonator struct {
+	started *auth.Impersonation
+}
+
+func (f *fakeImpersonator) StartImpersonation(w http.ResponseWriter, r *http.Request, user *auth.User, imp *auth.Impersonation) error {
+	f.started = imp
+	return nil
+}
+
+func (f *fakeImpersonator) StopImpersonation(w http.ResponseWriter, r *http.Request, user *auth.User) {
+	f.started = nil
+}
+
+func (f *fakeImpersonator) GroupImpersonationUser() string {
+	return "system:console:groups"
+}
+
+func TestHandleImpersonation(t *testing.T) {
+	// The API server lets the user impersonate the listed users, groups and
+	// extras.
+	allowed := map[string]bool{
+		"users/bob":                   true,
+		"users/system:console:groups": true,
+		"groups/ops":                  true,
+		"userextras/scopes/view":      true,
+	}
+	k8s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var review struct {
+			Spec struct {
+				ResourceAttributes resourceAttributes `json:"resourceAttributes"`
+			} `json:"spec"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		attrs := review.Spec.ResourceAttributes
+		if r.Header.Get("Impersonate-User") != "" {
+			t.Errorf("access review sent with the current impersonation: %v", r.Header)
+		}
+		key := strings.Join([]string{attrs.Resource, attrs.Name}, "/")
+		if attrs.Subresource != "" {
+			key = strings.Join([]string{attrs.Resource, attrs.Subresource, attrs.Name}, "/")
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": map[string]bool{"allowed": attrs.Verb == "impersonate" && allowed[key]},
+		})
+	}))
+	defer k8s.Close()
+	endpoint, err := url.Parse(k8s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		BaseURL:        &url.URL{Path: "/"},
+		K8sProxyConfig: &proxy.Config{Endpoint: endpoint},
+		K8sClient:      http.DefaultClient,
+	}
+	user := &auth.User{Username: "developer", Token: "token", Impersonating: &auth.Impersonation{User: "carol"}}
+
+	for _, tt := range []struct {
+		body string
+		code int
+	}{
+		{`{"user": "bob", "groups": ["ops"], "extra": {"scopes": ["view"]}}`, http.StatusOK},
+		{`{"groups": ["ops"]}`, http.StatusOK},
+		{`{"user": "alice"}`, http.StatusForbidden},
+		{`{"user": "bob", "groups": ["admins"]}`, http.StatusForbidden},
+		{`{"user": "bob", "extra": {"scopes": ["admin"]}}`, http.StatusForbidden},
+		{`{}`, http.StatusBadRequest},
+	} {
+		impersonator := &fakeImpersonator{}
+		w := httptest.NewRecorder()
+		s.handleImpersonation(impersonator)(user, w, httptest.NewRequest("POST", impersonationEndpoint, strings.NewReader(tt.body)))
+		if w.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d: %s", tt.body, w.Code, tt.code, w.Body)
+		}
+		if (impersonator.started != nil) != (tt.code == http.StatusOK) {
+			t.Errorf("%s: started impersonation = %+v", tt.body, impersonator.started)
+		}
+	}
+
+	// Impersonating groups needs the group impersonation user too.
+	allowed["users/system:console:groups"] = false
+	w := httptest.NewRecorder()
+	s.handleImpersonation(&fakeImpersonator{})(user, w, httptest.NewRequest("POST", impersonationEndpoint, strings.NewReader(`{"groups": ["ops"]}`)))
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "system:console:groups") {
+		t.Errorf("without access to the group impersonation user: status = %d, body = %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/openshift/console/auth"
+)
+
+const kubeconfigEndpoint = "/api/console/kubeconfig"
+
+// KubeconfigCallbackEndpoint is where the provider sends users back to after
+// logging in with kubectl's client. It must be registered as a redirect URL of
+// that client.
+const KubeconfigCallbackEndpoint = kubeconfigEndpoint + "/callback"
+
+// kubeconfig is the subset of a kubectl config file the console renders.
+type kubeconfig struct {
+	APIVersion     string              `yaml:"apiVersion"`
+	Kind           string              `yaml:"kind"`
+	Clusters       []kubeconfigCluster `yaml:"clusters"`
+	Users          []kubeconfigUser    `yaml:"users"`
+	Contexts       []kubeconfigContext `yaml:"contexts"`
+	CurrentContext string              `yaml:"current-context"`
+}
+
+type kubeconfigCluster struct {
+	Name    string `yaml:"name"`
+	Cluster struct {
+		Server                   string `yaml:"server"`
+		CertificateAuthorityData string `yaml:"certificate-authority-data,omitempty"`
+	} `yaml:"cluster"`
+}
+
+type kubeconfigUser struct {
+	Name string `yaml:"name"`
+	User struct {
+		Token        string                  `yaml:"token,omitempty"`
+		AuthProvider *kubeconfigAuthProvider `yaml:"auth-provider,omitempty"`
+	} `yaml:"user"`
+}
+
+type kubeconfigAuthProvider struct {
+	Name   string            `yaml:"name"`
+	Config map[string]string `yaml:"config"`
+}
+
+type kubeconfigContext struct {
+	Name    string `yaml:"name"`
+	Context struct {
+		Cluster string `yaml:"cluster"`
+		User    string `yaml:"user"`
+	} `yaml:"context"`
+}
+
+// kubectlAuthenticator gets kubectl credentials of its own for OIDC
+// sessions, see auth.Authenticator.KubectlLogin.
+type kubectlAuthenticator interface {
+	KubectlLogin(w http.ResponseWriter, r *http.Request) error
+	KubectlCredentials(w http.ResponseWriter, r *http.Request) (*auth.OIDCCredentials, error)
+}
+
+// handleKubeconfig renders a kubeconfig for the current user. In OIDC mode
+// with a kubectl client ID, the user is sent to log in with kubectl's client
+// first, and handleKubeconfigCallback renders the kubeconfig once the
+// provider sends them back. Otherwise the user's bearer token is embedded.
+func (s *Server) handleKubeconfig(kubectl kubectlAuthenticator) func(*auth.User, http.ResponseWriter, *http.Request) {
+	return func(user *auth.User, w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			sendResponse(w, http.StatusMethodNotAllowed, apiError{"Invalid method: only GET is allowed"})
+			return
+		}
+
+		if user.Impersonate {
+			// The only token available is the console's own.
+			sendResponse(w, http.StatusNotFound, apiError{"Kubeconfigs are not available to users authenticated by the console"})
+			return
+		}
+
+		if s.KubectlClientID != "" && kubectl != nil {
+			switch err := kubectl.KubectlLogin(w, r); err {
+			case nil:
+				// Redirected to the provider.
+				return
+			case auth.ErrSessionsNotSupported:
+				// Not an OIDC session, use the bearer token.
+			default:
+				plog.Errorf("failed to start kubectl login: %v", err)
+				sendResponse(w, http.StatusUnauthorized, apiError{"Session not found"})
+				return
+			}
+		}
+
+		kubeUser := kubeconfigUser{Name: kubeconfigUserName(user)}
+		kubeUser.User.Token = user.Token
+		s.writeKubeconfig(w, kubeUser)
+	}
+}
+
+// handleKubeconfigCallback renders a kubeconfig with the credentials issued
+// to kubectl's client after handleKubeconfig. kubectl renews the ID token
+// with the refresh token and client credentials itself.
+func (s *Server) handleKubeconfigCallback(kubectl kubectlAuthenticator) func(*auth.User, http.ResponseWriter, *http.Request) {
+	return func(user *auth.User, w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			sendResponse(w, http.StatusMethodNotAllowed, apiError{"Invalid method: only GET is allowed"})
+			return
+		}
+
+		creds, err := kubectl.KubectlCredentials(w, r)
+		if err != nil {
+			plog.Errorf("failed to get kubectl credentials: %v", err)
+			sendResponse(w, http.StatusUnauthorized, apiError{"Failed to get kubectl credentials"})
+			return
+		}
+
+		config := map[string]string{
+			"idp-issuer-url": creds.IssuerURL,
+			"client-id":      creds.ClientID,
+			"id-token":       creds.IDToken,
+		}
+		if creds.ClientSecret != "" {
+			config["client-secret"] = creds.ClientSecret
+		}
+		if creds.RefreshToken != "" {
+			config["refresh-token"] = creds.RefreshToken
+		}
+		if len(creds.IssuerCAPEM) > 0 {
+			config["idp-certificate-authority-data"] = base64.StdEncoding.EncodeToString(creds.IssuerCAPEM)
+		}
+		kubeUser := kubeconfigUser{Name: kubeconfigUserName(user)}
+		kubeUser.User.AuthProvider = &kubeconfigAuthProvider{Name: "oidc", Config: config}
+		s.writeKubeconfig(w, kubeUser)
+	}
+}
+
+func kubeconfigUserName(user *auth.User) string {
+	if user.Username == "" {
+		return "user"
+	}
+	return user.Username
+}
+
+// writeKubeconfig sends a kubeconfig for the API server with kubeUser as its
+// only user.
+func (s *Server) writeKubeconfig(w http.ResponseWriter, kubeUser kubeconfigUser) {
+	clusterName := "cluster"
+	if u, err := url.Parse(s.KubeAPIServerURL); err == nil && u.Host != "" {
+		clusterName = u.Host
+	}
+	contextName := kubeUser.Name + "@" + clusterName
+
+	cluster := kubeconfigCluster{Name: clusterName}
+	cluster.Cluster.Server = s.KubeAPIServerURL
+	if ca := s.kubeAPIServerCA(); len(ca) > 0 {
+		cluster.Cluster.CertificateAuthorityData = base64.StdEncoding.EncodeToString(ca)
+	}
+
+	kc := kubeconfig{
+		APIVersion:     "v1",
+		Kind:           "Config",
+		Clusters:       []kubeconfigCluster{cluster},
+		Users:          []kubeconfigUser{kubeUser},
+		CurrentContext: contextName,
+	}
+	kctx := kubeconfigContext{Name: contextName}
+	kctx.Context.Cluster = clusterName
+	kctx.Context.User = kubeUser.Name
+	kc.Contexts = []kubeconfigContext{kctx}
+
+	data, err := yaml.Marshal(kc)
+	if err != nil {
+		plog.Errorf("failed to marshal kubeconfig: %v", err)
+		sendResponse(w, http.StatusInternalServerError, apiError{"Failed to render kubeconfig"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Header().Set("Content-Disposition", `attachment; filename="kubeconfig"`)
+	// The kubeconfig holds the user's credentials.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
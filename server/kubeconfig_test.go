@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/openshift/console/auth"
+)
+
+func TestHandleKubeconfig(t *testing.T) {
+	s := &Server{
+		KubeAPIServerURL: "https://api.example.com:6443",
+		KubeAPIServerCA:  []byte("-----BEGIN CERTIFICATE-----\n"),
+	}
+	user := &auth.User{Username: "developer", Token: "secret-access-token"}
+
+	w := httptest.NewRecorder()
+	s.handleKubeconfig(nil)(user, w, httptest.NewRequest("GET", kubeconfigEndpoint, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", got)
+	}
+
+	var kc kubeconfig
+	if err := yaml.UnmarshalStrict(w.Body.Bytes(), &kc); err != nil {
+		t.Fatal(err)
+	}
+	if kc.CurrentContext != "developer@api.example.com:6443" || len(kc.Contexts) != 1 ||
+		kc.Contexts[0].Context.Cluster != "api.example.com:6443" || kc.Contexts[0].Context.User != "developer" {
+		t.Errorf("unexpected contexts: %q %+v", kc.CurrentContext, kc.Contexts)
+	}
+	if len(kc.Clusters) != 1 || kc.Clusters[0].Cluster.Server != s.KubeAPIServerURL ||
+		kc.Clusters[0].Cluster.CertificateAuthorityData != base64.StdEncoding.EncodeToString(s.KubeAPIServerCA) {
+		t.Errorf("unexpected clusters: %+v", kc.Clusters)
+	}
+	if len(kc.Users) != 1 || kc.Users[0].User.Token != user.Token || kc.Users[0].User.AuthProvider != nil {
+		t.Errorf("unexpected users: %+v", kc.Users)
+	}
+
+	w = httptest.NewRecorder()
+	s.handleKubeconfig(nil)(user, w, httptest.NewRequest("POST", kubeconfigEndpoint, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+// fakeKubectlAuthenticator starts kubectl logins by redirecting to the
+// provider, or fails with err.
+type fakeKubectlAuthenticator struct {
+	creds *auth.OIDCCredentials
+	err   error
+}
+
+func (f *fakeKubectlAuthenticator) KubectlLogin(w http.ResponseWriter, r *http.Request) error {
+	if f.err != nil {
+		return f.err
+	}
+	http.Redirect(w, r, "https://dex.example.com/auth", http.StatusSeeOther)
+	return nil
+}
+
+func (f *fakeKubectlAuthenticator) KubectlCredentials(w http.ResponseWriter, r *http.Request) (*auth.OIDCCredentials, error) {
+	return f.creds, f.err
+}
+
+func TestHandleKubeconfigOIDC(t *testing.T) {
+	s := &Server{
+		KubeAPIServerURL: "https://api.example.com:6443",
+		KubectlClientID:  "kubectl",
+	}
+	user := &auth.User{Username: "developer", Token: "id-token"}
+	kubectl := &fakeKubectlAuthenticator{creds: &auth.OIDCCredentials{
+		IssuerURL:    "https://dex.example.com",
+		IssuerCAPEM:  []byte("-----BEGIN CERTIFICATE-----\n"),
+		ClientID:     "kubectl",
+		ClientSecret: "kubectl-secret",
+		IDToken:      "kubectl-id-token",
+		RefreshToken: "kubectl-refresh-token",
+	}}
+	parse := func(w *httptest.ResponseRecorder) *kubeconfigUser {
+		var kc kubeconfig
+		if err := yaml.UnmarshalStrict(w.Body.Bytes(), &kc); err != nil || len(kc.Users) != 1 {
+			return nil
+		}
+		return &kc.Users[0]
+	}
+
+	// Users are sent to log in with kubectl's client first.
+	w := httptest.NewRecorder()
+	s.handleKubeconfig(kubectl)(user, w, httptest.NewRequest("GET", kubeconfigEndpoint, nil))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusSeeOther, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	s.handleKubeconfigCallback(kubectl)(user, w, httptest.NewRequest("GET", KubeconfigCallbackEndpoint+"?code=code&state=state", nil))
+	kubeUser := parse(w)
+	if w.Code != http.StatusOK || kubeUser == nil {
+		t.Fatalf("callback status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	provider := kubeUser.User.AuthProvider
+	if kubeUser.User.Token != "" || provider == nil || provider.Name != "oidc" {
+		t.Fatalf("user = %+v, want an oidc auth provider", kubeUser.User)
+	}
+	want := map[string]string{
+		"idp-issuer-url":                 "https://dex.example.com",
+		"client-id":                      "kubectl",
+		"client-secret":                  "kubectl-secret",
+		"id-token":                       "kubectl-id-token",
+		"refresh-token":                  "kubectl-refresh-token",
+		"idp-certificate-authority-data": base64.StdEncoding.EncodeToString(kubectl.creds.IssuerCAPEM),
+	}
+	if !reflect.DeepEqual(provider.Config, want) {
+		t.Errorf("auth provider config = %v, want %v", provider.Config, want)
+	}
+
+	// Sessions of other auth sources fall back to the bearer token.
+	kubectl.err = auth.ErrSessionsNotSupported
+	w = httptest.NewRecorder()
+	s.handleKubeconfig(kubectl)(user, w, httptest.NewRequest("GET", kubeconfigEndpoint, nil))
+	if kubeUser := parse(w); w.Code != http.StatusOK || kubeUser == nil || kubeUser.User.Token != user.Token {
+		t.Errorf("without OIDC session support: status = %d, user = %+v, want the bearer token", w.Code, kubeUser)
+	}
+
+	kubectl.err = errors.New("no session")
+	w = httptest.NewRecorder()
+	s.handleKubeconfig(kubectl)(user, w, httptest.NewRequest("GET", kubeconfigEndpoint, nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("without a session: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	w = httptest.NewRecorder()
+	s.handleKubeconfigCallback(kubectl)(user, w, httptest.NewRequest("GET", KubeconfigCallbackEndpoint, nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("failed callback: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
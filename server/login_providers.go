@@ -0,0 +1,72 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/openshift/console/auth"
+	"github.com/openshift/console/pkg/proxy"
+)
+
+var providerPickerTemplate = template.Must(template.New("providers").Parse(`<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    <title>Log in</title>
+  </head>
+  <body>
+    <h1>Log in with</h1>
+    <ul>
+      {{ range . }}<li><a href="{{ .URL }}">{{ .DisplayName }}</a></li>
+      {{ end }}
+    </ul>
+  </body>
+</html>
+`))
+
+type providerLink struct {
+	DisplayName string
+	URL         string
+}
+
+// handleLogin starts a login with the only identity provider, or lets the
+// user pick one when several are configured.
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	providers := s.Auther.Providers()
+	switch len(providers) {
+	case 0:
+		s.Auther.LoginFunc(w, r)
+		return
+	case 1:
+		s.Auther.LoginWithProvider(w, r, providers[0].Name)
+		return
+	}
+
+	links := make([]providerLink, 0, len(providers))
+	for _, p := range providers {
+		links = append(links, providerLink{
+			DisplayName: p.DisplayName,
+			URL:         s.providerLoginURL(p, r.URL.Query().Get("then")),
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := providerPickerTemplate.Execute(w, links); err != nil {
+		plog.Errorf("failed to render provider selection page: %v", err)
+	}
+}
+
+// handleProviderLogin starts a login with the provider named in the path.
+func (s *Server) handleProviderLogin(w http.ResponseWriter, r *http.Request) {
+	s.Auther.LoginWithProvider(w, r, s.pathID(r, authLoginEndpoint))
+}
+
+// providerLoginURL returns the login URL of p, keeping the return path.
+func (s *Server) providerLoginURL(p auth.Provider, then string) string {
+	u := url.URL{Path: proxy.SingleJoiningSlash(s.BaseURL.Path, authLoginEndpoint+"/"+url.PathEscape(p.Name))}
+	if then != "" {
+		u.RawQuery = url.Values{"then": {then}}.Encode()
+	}
+	return u.String()
+}
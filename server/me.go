@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/openshift/console/auth"
+)
+
+const meEndpoint = "/api/console/me"
+
+// meResponse describes the logged in user. It never includes the user's token.
+type meResponse struct {
+	ID       string   `json:"id"`
+	Username string   `json:"username"`
+	Groups   []string `json:"groups"`
+	// ScopeProfile is the restricted scope profile the user logged in with,
+	// or empty if they have the default scopes.
+	ScopeProfile string `json:"scopeProfile"`
+}
+
+// handleMe returns the identity of the user making the request.
+func (s *Server) handleMe(user *auth.User, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		sendResponse(w, http.StatusMethodNotAllowed, apiError{"invalid method: only GET is allowed"})
+		return
+	}
+
+	groups := user.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+	sendResponse(w, http.StatusOK, meResponse{
+		ID:           user.ID,
+		Username:     user.Username,
+		Groups:       groups,
+		ScopeProfile: user.ScopeProfile,
+	})
+}
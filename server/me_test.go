@@ -0,0 +1,47 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/openshift/console/auth"
+)
+
+func TestHandleMe(t *testing.T) {
+	s := &Server{}
+	user := &auth.User{
+		ID:           "uid-developer",
+		Username:     "developer",
+		Groups:       []string{"system:authenticated"},
+		Token:        "secret-access-token",
+		ScopeProfile: "view",
+	}
+
+	w := httptest.NewRecorder()
+	s.handleMe(user, w, httptest.NewRequest("GET", meEndpoint, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if strings.Contains(w.Body.String(), user.Token) {
+		t.Error("response contains the user's token")
+	}
+
+	var got meResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := meResponse{ID: user.ID, Username: user.Username, Groups: user.Groups, ScopeProfile: "view"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("response = %+v, want %+v", got, want)
+	}
+
+	w = httptest.NewRecorder()
+	s.handleMe(user, w, httptest.NewRequest("POST", meEndpoint, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -2,7 +2,6 @@ package server
 
 import (
 	"compress/gzip"
-	"fmt"
 	"io"
 	"net/http"
 	"strings"
@@ -20,28 +19,61 @@ func authMiddleware(a *auth.Authenticator, hdlr http.HandlerFunc) http.Handler {
 }
 
 func authMiddlewareWithUser(a *auth.Authenticator, handlerFunc func(user *auth.User, w http.ResponseWriter, r *http.Request)) http.Handler {
+	return authMiddlewareWithActivity(a, true, handlerFunc)
+}
+
+// authMiddlewareWithActivity is authMiddlewareWithUser for handlers whose
+// requests only count as user activity for the idle timeout if userActivity
+// is set, such as the session status the UI polls. Requests to other
+// handlers count unless the UI marks them as background requests, see
+// auth.CheckActivity.
+func authMiddlewareWithActivity(a *auth.Authenticator, userActivity bool, handlerFunc func(user *auth.User, w http.ResponseWriter, r *http.Request)) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user, err := a.Authenticate(r)
 		if err != nil {
 			plog.Infof("authentication failed: %v", err)
+			a.RecordEvent(r, auth.AuthEvent{Type: auth.EventAuthenticationFailed, Message: err.Error()})
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 
-		r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", user.Token))
+		// A cookie that can't be opened is left over from another session.
+		if user.Impersonating, err = a.Impersonation(r, user); err != nil {
+			plog.Infof("ignoring impersonation: %v", err)
+		}
+		user.SetAuthHeaders(r.Header)
 
 		if err := a.VerifySourceOrigin(r); err != nil {
 			plog.Infof("invalid source origin: %v", err)
+			a.RecordEvent(r, auth.AuthEvent{Type: auth.EventOriginRejected, User: user.Username, Message: err.Error()})
 			w.WriteHeader(http.StatusForbidden)
 			return
 		}
 
-		if err := a.VerifyCSRFToken(r); err != nil {
+		err = a.VerifyCSRFToken(r, user)
+		// Also issues a token if the request lacked a valid one, so the
+		// browser recovers from an expired token or one of an old session.
+		a.SetCSRFCookie(w, r, user)
+		if err != nil {
 			plog.Infof("invalid CSRFToken: %v", err)
+			a.RecordEvent(r, auth.AuthEvent{Type: auth.EventCSRFRejected, User: user.Username, Message: err.Error()})
 			w.WriteHeader(http.StatusForbidden)
 			return
 		}
 
+		if err := a.CheckActivity(w, r, userActivity); err != nil {
+			plog.Infof("session ended: %v", err)
+			a.RecordEvent(r, auth.AuthEvent{Type: auth.EventSessionIdle, User: user.Username, Message: err.Error()})
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// Only bridge needs to know, not the API server or Prometheus.
+		r.Header.Del(auth.BackgroundRequestHeader)
+
+		// Cancel the request if the session is revoked while it's in flight.
+		r, done := a.TrackRequest(user, r)
+		defer done()
+
 		handlerFunc(user, w, r)
 	})
 }
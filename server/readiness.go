@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/coreos/pkg/health"
+
+	"github.com/openshift/console/auth"
+)
+
+// providerReadiness reports which identity providers users can log in with.
+type providerReadiness interface {
+	Healthy() error
+	ProviderStatuses() []auth.ProviderStatus
+}
+
+type readinessResponse struct {
+	health.StatusResponse `json:",inline"`
+	// Providers is the state of each identity provider, if auth is enabled.
+	Providers []auth.ProviderStatus `json:"providers,omitempty"`
+}
+
+// handleReadiness fails, unlike /health, while no identity provider can be
+// reached, so users aren't routed to a console they can't log in to. Each
+// provider's state is reported, since users of one that's unreachable can't
+// log in even when the console is ready. providers is nil if auth is
+// disabled.
+func handleReadiness(providers providerReadiness) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		resp := readinessResponse{StatusResponse: health.StatusResponse{Status: "ok"}}
+		if providers == nil {
+			sendResponse(w, http.StatusOK, resp)
+			return
+		}
+		resp.Providers = providers.ProviderStatuses()
+		if err := providers.Healthy(); err != nil {
+			resp.Status = "error"
+			resp.Details = &health.StatusResponseDetails{
+				Code:    http.StatusServiceUnavailable,
+				Message: err.Error(),
+			}
+			sendResponse(w, http.StatusServiceUnavailable, resp)
+			return
+		}
+		sendResponse(w, http.StatusOK, resp)
+	}
+}
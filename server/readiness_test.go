@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openshift/console/auth"
+)
+
+type fakeProviderReadiness struct {
+	err      error
+	statuses []auth.ProviderStatus
+}
+
+func (f *fakeProviderReadiness) Healthy() error {
+	return f.err
+}
+
+func (f *fakeProviderReadiness) ProviderStatuses() []auth.ProviderStatus {
+	return f.statuses
+}
+
+func TestHandleReadiness(t *testing.T) {
+	down := auth.ProviderStatus{Name: "contractors", Error: "connection refused"}
+	for _, tt := range []struct {
+		name      string
+		providers providerReadiness
+		wantCode  int
+		wantCount int
+	}{
+		{
+			name:     "auth disabled",
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "one provider unreachable",
+			providers: &fakeProviderReadiness{statuses: []auth.ProviderStatus{
+				{Name: "corp", Ready: true},
+				down,
+			}},
+			wantCode:  http.StatusOK,
+			wantCount: 2,
+		},
+		{
+			name: "no provider reachable",
+			providers: &fakeProviderReadiness{
+				err:      errors.New("no identity provider reachable"),
+				statuses: []auth.ProviderStatus{down},
+			},
+			wantCode:  http.StatusServiceUnavailable,
+			wantCount: 1,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleReadiness(tt.providers)(w, httptest.NewRequest("GET", "/readiness", nil))
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			var resp readinessResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Providers) != tt.wantCount {
+				t.Fatalf("got %d providers, want %d: %+v", len(resp.Providers), tt.wantCount, resp.Providers)
+			}
+			for _, p := range resp.Providers {
+				if p.Name == "contractors" && (p.Ready || p.Error == "") {
+					t.Errorf("unreachable provider reported as %+v", p)
+				}
+			}
+			if tt.wantCode != http.StatusOK && (resp.Status != "error" || resp.Details == nil) {
+				t.Errorf("unready response = %+v", resp)
+			}
+		})
+	}
+}
@@ -4,11 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
-	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"sync"
 
 	"github.com/coreos/dex/api"
 	"github.com/coreos/pkg/capnslog"
@@ -28,6 +28,7 @@ const (
 	AuthLoginSuccessEndpoint       = "/"
 	AuthLoginErrorEndpoint         = "/error"
 	authLogoutEndpoint             = "/auth/logout"
+	authBackChannelLogoutEndpoint  = "/auth/backchannel-logout"
 	k8sProxyEndpoint               = "/api/kubernetes/"
 	prometheusProxyEndpoint        = "/api/prometheus"
 	prometheusTenancyProxyEndpoint = "/api/prometheus-tenancy"
@@ -57,19 +58,25 @@ type jsGlobals struct {
 	DocumentationBaseURL     string `json:"documentationBaseURL"`
 	GoogleTagManagerID       string `json:"googleTagManagerID"`
 	LoadTestFactor           int    `json:"loadTestFactor"`
+	// ScopeProfiles are the restricted scope profiles users can log in with
+	// by adding ?scopeProfile=<name> to the login URL.
+	ScopeProfiles []string `json:"scopeProfiles"`
 }
 
 type Server struct {
-	K8sProxyConfig       *proxy.Config
-	BaseURL              *url.URL
-	LogoutRedirect       *url.URL
-	PublicDir            string
-	TectonicVersion      string
-	TectonicCACertFile   string
-	Auther               *auth.Authenticator
-	StaticUser           *auth.User
-	KubectlClientID      string
-	KubeAPIServerURL     string
+	K8sProxyConfig     *proxy.Config
+	BaseURL            *url.URL
+	LogoutRedirect     *url.URL
+	PublicDir          string
+	TectonicVersion    string
+	TectonicCACertFile string
+	Auther             *auth.Authenticator
+	StaticUser         *auth.User
+	KubectlClientID    string
+	KubeAPIServerURL   string
+	// KubeAPIServerCA is the PEM encoded CA bundle of the API server embedded
+	// in rendered kubeconfigs, or empty if clients should use their own.
+	KubeAPIServerCA      []byte
 	DocumentationBaseURL *url.URL
 	Branding             string
 	GoogleTagManagerID   string
@@ -80,6 +87,56 @@ type Server struct {
 	PrometheusProxyConfig        *proxy.Config
 	PrometheusTenancyProxyConfig *proxy.Config
 	AlertManagerProxyConfig      *proxy.Config
+
+	// mu guards StaticUser, KubeAPIServerCA and DexClient once the server is
+	// running.
+	mu sync.RWMutex
+}
+
+// SetStaticUser replaces StaticUser, for example after its token was rotated.
+func (s *Server) SetStaticUser(u *auth.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.StaticUser = u
+}
+
+// staticUser returns a copy of StaticUser handlers are free to modify.
+func (s *Server) staticUser() *auth.User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.StaticUser == nil {
+		return nil
+	}
+	u := *s.StaticUser
+	return &u
+}
+
+// SetKubeAPIServerCA replaces KubeAPIServerCA, for example after the CA
+// bundle was rotated.
+func (s *Server) SetKubeAPIServerCA(pem []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.KubeAPIServerCA = pem
+}
+
+func (s *Server) kubeAPIServerCA() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.KubeAPIServerCA
+}
+
+// SetDexClient replaces DexClient, for example after its CA bundle was
+// rotated.
+func (s *Server) SetDexClient(c api.DexClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.DexClient = c
+}
+
+func (s *Server) dexClient() api.DexClient {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.DexClient
 }
 
 func (s *Server) authDisabled() bool {
@@ -137,6 +194,11 @@ func (s *Server) HTTPHandler() http.Handler {
 	authHandlerWithUser := func(hf func(*auth.User, http.ResponseWriter, *http.Request)) http.Handler {
 		return authMiddlewareWithUser(s.Auther, hf)
 	}
+	// backgroundHandlerWithUser is for requests that don't count as user
+	// activity for the idle timeout.
+	backgroundHandlerWithUser := func(hf func(*auth.User, http.ResponseWriter, *http.Request)) http.Handler {
+		return authMiddlewareWithActivity(s.Auther, false, hf)
+	}
 
 	if s.authDisabled() {
 		authHandler = func(hf http.HandlerFunc) http.Handler {
@@ -144,17 +206,45 @@ func (s *Server) HTTPHandler() http.Handler {
 		}
 		authHandlerWithUser = func(hf func(*auth.User, http.ResponseWriter, *http.Request)) http.Handler {
 			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				hf(s.StaticUser, w, r)
+				hf(s.staticUser(), w, r)
 			})
 		}
 	}
 
 	if !s.authDisabled() {
-		handleFunc(authLoginEndpoint, s.Auther.LoginFunc)
+		handleFunc(authLoginEndpoint, s.handleLogin)
+		handleFunc(authLoginEndpoint+"/", s.handleProviderLogin)
 		handleFunc(authLogoutEndpoint, s.Auther.LogoutFunc)
+		handleFunc(authBackChannelLogoutEndpoint, s.Auther.BackChannelLogoutFunc)
 		handleFunc(AuthLoginCallbackEndpoint, s.Auther.CallbackFunc(fn))
+		handleFunc(AuthLoginCallbackEndpoint+"/", s.Auther.CallbackFunc(fn))
+
+		handle(sessionStatusEndpoint, backgroundHandlerWithUser(s.handleSessionStatus))
+		handle(sessionEventsEndpoint, backgroundHandlerWithUser(s.handleSessionEvents))
+		handle(sessionsEndpoint, authHandlerWithUser(s.handleSessions(s.Auther)))
+		handle(sessionsEndpoint+"/", authHandlerWithUser(s.handleSessions(s.Auther)))
+		handle(adminSessionsEndpoint, authHandlerWithUser(s.clusterAdminHandler(s.handleAdminSessions(s.Auther))))
+		handle(adminSessionsEndpoint+"/", authHandlerWithUser(s.clusterAdminHandler(s.handleAdminSessions(s.Auther))))
+		handle(impersonationEndpoint, authHandlerWithUser(s.handleImpersonation(s.Auther)))
+
+		if s.DexClient != nil {
+			handle(dexPasswordsEndpoint, authHandlerWithUser(s.dexHandler("passwords", s.handleDexPasswords)))
+			handle(dexPasswordsEndpoint+"/", authHandlerWithUser(s.dexHandler("passwords", s.handleDexPasswords)))
+			handle(dexClientsEndpoint, authHandlerWithUser(s.dexHandler("oauth2clients", s.handleDexClients)))
+			handle(dexClientsEndpoint+"/", authHandlerWithUser(s.dexHandler("oauth2clients", s.handleDexClients)))
+			handle(dexConnectorsEndpoint, authHandlerWithUser(s.dexHandler("connectors", s.handleDexConnectors)))
+			handle(dexVersionEndpoint, authHandlerWithUser(s.clusterAdminHandler(s.handleDexVersion)))
+		}
+	}
 
-		handle("/api/openshift/delete-token", authHandlerWithUser(s.handleOpenShiftTokenDeletion))
+	handle(meEndpoint, authHandlerWithUser(s.handleMe))
+	if s.authDisabled() {
+		handle(kubeconfigEndpoint, authHandlerWithUser(s.handleKubeconfig(nil)))
+	} else {
+		handle(kubeconfigEndpoint, authHandlerWithUser(s.handleKubeconfig(s.Auther)))
+		if s.KubectlClientID != "" {
+			handle(KubeconfigCallbackEndpoint, authHandlerWithUser(s.handleKubeconfigCallback(s.Auther)))
+		}
 	}
 
 	handleFunc("/api/", notFoundHandler)
@@ -171,11 +261,17 @@ func (s *Server) HTTPHandler() http.Handler {
 		Checks: []health.Checkable{},
 	}.ServeHTTP)
 
+	var readiness providerReadiness
+	if !s.authDisabled() {
+		readiness = s.Auther
+	}
+	handleFunc("/readiness", handleReadiness(readiness))
+
 	k8sProxy := proxy.NewProxy(s.K8sProxyConfig)
 	handle(k8sProxyEndpoint, http.StripPrefix(
 		proxy.SingleJoiningSlash(s.BaseURL.Path, k8sProxyEndpoint),
 		authHandlerWithUser(func(user *auth.User, w http.ResponseWriter, r *http.Request) {
-			r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", user.Token))
+			user.SetAuthHeaders(r.Header)
 			k8sProxy.ServeHTTP(w, r)
 		})),
 	)
@@ -186,19 +282,19 @@ func (s *Server) HTTPHandler() http.Handler {
 		prometheusProxy := proxy.NewProxy(s.PrometheusProxyConfig)
 		handle(prometheusProxyAPIPath, http.StripPrefix(
 			proxy.SingleJoiningSlash(s.BaseURL.Path, prometheusProxyAPIPath),
-			authHandlerWithUser(func(user *auth.User, w http.ResponseWriter, r *http.Request) {
+			authHandlerWithUser(s.directUserHandler(func(user *auth.User, w http.ResponseWriter, r *http.Request) {
 				r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", user.Token))
 				prometheusProxy.ServeHTTP(w, r)
-			})),
+			}))),
 		)
 		prometheusTenancyProxyAPIPath := prometheusTenancyProxyEndpoint + "/api/"
 		prometheusTenancyProxy := proxy.NewProxy(s.PrometheusTenancyProxyConfig)
 		handle(prometheusTenancyProxyAPIPath, http.StripPrefix(
 			proxy.SingleJoiningSlash(s.BaseURL.Path, prometheusTenancyProxyAPIPath),
-			authHandlerWithUser(func(user *auth.User, w http.ResponseWriter, r *http.Request) {
+			authHandlerWithUser(s.directUserHandler(func(user *auth.User, w http.ResponseWriter, r *http.Request) {
 				r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", user.Token))
 				prometheusTenancyProxy.ServeHTTP(w, r)
-			})),
+			}))),
 		)
 	}
 
@@ -207,10 +303,10 @@ func (s *Server) HTTPHandler() http.Handler {
 		alertManagerProxy := proxy.NewProxy(s.AlertManagerProxyConfig)
 		handle(alertManagerProxyAPIPath, http.StripPrefix(
 			proxy.SingleJoiningSlash(s.BaseURL.Path, alertManagerProxyAPIPath),
-			authHandlerWithUser(func(user *auth.User, w http.ResponseWriter, r *http.Request) {
+			authHandlerWithUser(s.directUserHandler(func(user *auth.User, w http.ResponseWriter, r *http.Request) {
 				r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", user.Token))
 				alertManagerProxy.ServeHTTP(w, r)
-			})),
+			}))),
 		)
 	}
 
@@ -261,6 +357,12 @@ func (s *Server) indexHandler(w http.ResponseWriter, r *http.Request) {
 
 	if !s.authDisabled() {
 		jsg.KubeAdminLogoutURL = s.Auther.GetKubeAdminLogoutURL()
+		jsg.ScopeProfiles = s.Auther.ScopeProfiles()
+		// Issue the CSRF token the UI needs before its first request that
+		// changes anything.
+		if user, err := s.Auther.Authenticate(r); err == nil {
+			s.Auther.SetCSRFCookie(w, r, user)
+		}
 	}
 
 	if s.prometheusProxyEnabled() {
@@ -272,10 +374,6 @@ func (s *Server) indexHandler(w http.ResponseWriter, r *http.Request) {
 		jsg.AlertManagerBaseURL = proxy.SingleJoiningSlash(s.BaseURL.Path, alertManagerProxyEndpoint)
 	}
 
-	if !s.authDisabled() {
-		s.Auther.SetCSRFCookie(s.BaseURL.Path, &w)
-	}
-
 	tpl := template.New(indexPageTemplateName)
 	tpl.Delims("[[", "]]")
 	tpls, err := tpl.ParseFiles(path.Join(s.PublicDir, indexPageTemplateName))
@@ -303,30 +401,3 @@ func notFoundHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNotFound)
 	w.Write([]byte("not found"))
 }
-
-func (s *Server) handleOpenShiftTokenDeletion(user *auth.User, w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		sendResponse(w, http.StatusMethodNotAllowed, apiError{"Invalid method: only POST is allowed"})
-		return
-	}
-
-	// Delete the OpenShift OAuthAccessToken.
-	path := "/apis/oauth.openshift.io/v1/oauthaccesstokens/" + user.Token
-	url := proxy.SingleJoiningSlash(s.K8sProxyConfig.Endpoint.String(), path)
-	req, err := http.NewRequest("DELETE", url, nil)
-	if err != nil {
-		sendResponse(w, http.StatusInternalServerError, apiError{fmt.Sprintf("Failed to create token DELETE request: %v", err)})
-		return
-	}
-
-	r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", user.Token))
-	resp, err := s.K8sClient.Do(req)
-	if err != nil {
-		sendResponse(w, http.StatusBadGateway, apiError{fmt.Sprintf("Failed to delete token: %v", err)})
-		return
-	}
-
-	w.WriteHeader(resp.StatusCode)
-	io.Copy(w, resp.Body)
-	resp.Body.Close()
-}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/openshift/console/auth"
+)
+
+const (
+	sessionStatusEndpoint = "/api/console/session"
+	sessionEventsEndpoint = "/api/console/session/events"
+
+	// sessionExpiringWarning is how long before the session ends the
+	// "expiring" event is sent.
+	sessionExpiringWarning = 5 * time.Minute
+)
+
+// sessionEventsInterval is how often session event streams check the session.
+var sessionEventsInterval = 15 * time.Second
+
+type sessionStatusResponse struct {
+	*auth.SessionStatus `json:",inline"`
+	// ExpiresIn is the remaining lifetime of the session in seconds, so the
+	// UI doesn't depend on the browser's clock. If the session idles out
+	// before it expires, it's the time until then.
+	ExpiresIn *int64 `json:"expiresIn,omitempty"`
+}
+
+// sessionEnd returns the earlier of when the session expires and when it
+// idles out, or nil if neither is known.
+func sessionEnd(status *auth.SessionStatus) *time.Time {
+	end := status.Expires
+	if status.IdleExpires != nil && (end == nil || status.IdleExpires.Before(*end)) {
+		end = status.IdleExpires
+	}
+	return end
+}
+
+func newSessionStatusResponse(status *auth.SessionStatus) sessionStatusResponse {
+	resp := sessionStatusResponse{SessionStatus: status}
+	if end := sessionEnd(status); end != nil {
+		expiresIn := int64(time.Until(*end).Seconds())
+		if expiresIn < 0 {
+			expiresIn = 0
+		}
+		resp.ExpiresIn = &expiresIn
+	}
+	return resp
+}
+
+// handleSessionStatus returns the status of the current user's session.
+func (s *Server) handleSessionStatus(user *auth.User, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		sendResponse(w, http.StatusMethodNotAllowed, apiError{"Invalid method: only GET is allowed"})
+		return
+	}
+	status, err := s.Auther.SessionStatus(r)
+	if err != nil {
+		sendResponse(w, http.StatusUnauthorized, apiError{"Session not found"})
+		return
+	}
+	sendResponse(w, http.StatusOK, newSessionStatusResponse(status))
+}
+
+// handleSessionEvents streams server-sent events about the current user's
+// session, so the UI can warn before the user is logged out:
+//
+//	expiring  the session expires or idles out within sessionExpiringWarning
+//	renewed   the session's token was renewed, or the user's activity kept
+//	          it from idling out after an "expiring" event
+//	expired   the session ended
+//	revoked   the session was revoked, or the token rejected, before it expired
+//
+// Each event carries the session status as JSON. The stream ends after an
+// "expired" or "revoked" event.
+func (s *Server) handleSessionEvents(user *auth.User, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		sendResponse(w, http.StatusMethodNotAllowed, apiError{"Invalid method: only GET is allowed"})
+		return
+	}
+	streamSessionEvents(w, r, func() (*auth.SessionStatus, error) {
+		return s.Auther.SessionStatus(r)
+	})
+}
+
+// streamSessionEvents writes session events to w until the session ends or
+// the request is done. statusFunc returns the current session status.
+func streamSessionEvents(w http.ResponseWriter, r *http.Request, statusFunc func() (*auth.SessionStatus, error)) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendResponse(w, http.StatusInternalServerError, apiError{"Streaming is not supported"})
+		return
+	}
+	status, err := statusFunc()
+	if err != nil {
+		sendResponse(w, http.StatusUnauthorized, apiError{"Session not found"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keep proxies such as nginx from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	warned := false
+	ticker := time.NewTicker(sessionEventsInterval)
+	defer ticker.Stop()
+	for {
+		end := sessionEnd(status)
+		if !warned && end != nil && time.Until(*end) <= sessionExpiringWarning {
+			warned = true
+			if writeSessionEvent(w, "expiring", status) != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		// The request context is also canceled when the session is revoked
+		// through this bridge instance, so check the session once more.
+		done := false
+		select {
+		case <-r.Context().Done():
+			done = true
+		case <-ticker.C:
+		}
+
+		current, err := statusFunc()
+		if err != nil {
+			event := "revoked"
+			if end != nil && !time.Now().Before(*end) {
+				event = "expired"
+			}
+			writeSessionEvent(w, event, status)
+			flusher.Flush()
+			return
+		}
+		if done {
+			return
+		}
+		renewed := current.TokenExpires != nil && status.TokenExpires != nil && current.TokenExpires.After(*status.TokenExpires)
+		if currentEnd := sessionEnd(current); warned && currentEnd != nil && time.Until(*currentEnd) > sessionExpiringWarning {
+			// Warn again if the session comes close to ending again.
+			warned = false
+			renewed = true
+		}
+		if renewed {
+			if writeSessionEvent(w, "renewed", current) != nil {
+				return
+			}
+		} else if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+			return
+		}
+		status = current
+	}
+}
+
+func writeSessionEvent(w http.ResponseWriter, event string, status *auth.SessionStatus) error {
+	data, err := json.Marshal(newSessionStatusResponse(status))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openshift/console/auth"
+)
+
+func TestSessionEvents(t *testing.T) {
+	defer func(interval time.Duration) { sessionEventsInterval = interval }(sessionEventsInterval)
+	sessionEventsInterval = 10 * time.Millisecond
+
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	statuses := []*auth.SessionStatus{
+		{AuthSource: "oidc", Expires: at(time.Hour), TokenExpires: at(time.Minute)},
+		{AuthSource: "oidc", Expires: at(time.Hour), TokenExpires: at(time.Minute)},
+		{AuthSource: "oidc", Expires: at(time.Hour), TokenExpires: at(10 * time.Minute)},
+		{AuthSource: "oidc", Expires: at(2 * time.Minute), TokenExpires: at(10 * time.Minute)},
+	}
+	events := readSessionEvents(t, statuses)
+	if got, want := sessionEventNames(events), "renewed,expiring,revoked"; got != want {
+		t.Fatalf("events = %s, want %s", got, want)
+	}
+	if got := events[0].status.TokenExpires; got == nil || !got.Equal(*statuses[2].TokenExpires) {
+		t.Errorf("renewed event has token expiry %v, want %v", got, statuses[2].TokenExpires)
+	}
+	if got := events[1].status.ExpiresIn; got == nil || *got > int64((2*time.Minute).Seconds()) || *got <= 0 {
+		t.Errorf("expiring event has expiresIn %v, want at most 120 seconds", got)
+	}
+}
+
+func TestSessionEventsIdleExpiry(t *testing.T) {
+	defer func(interval time.Duration) { sessionEventsInterval = interval }(sessionEventsInterval)
+	sessionEventsInterval = 10 * time.Millisecond
+
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	statuses := []*auth.SessionStatus{
+		{AuthSource: "oidc", Expires: at(time.Hour), IdleExpires: at(2 * time.Minute)},
+		// The user was active again.
+		{AuthSource: "oidc", Expires: at(time.Hour), IdleExpires: at(30 * time.Minute)},
+		{AuthSource: "oidc", Expires: at(time.Hour), IdleExpires: at(time.Minute)},
+	}
+	events := readSessionEvents(t, statuses)
+
+	if got, want := sessionEventNames(events), "expiring,renewed,expiring,revoked"; got != want {
+		t.Fatalf("events = %s, want %s", got, want)
+	}
+	if got := events[0].status.ExpiresIn; got == nil || *got > int64((2*time.Minute).Seconds()) || *got <= 0 {
+		t.Errorf("expiring event has expiresIn %v, want at most 120 seconds", got)
+	}
+}
+
+type sessionEvent struct {
+	name   string
+	status sessionStatusResponse
+}
+
+// readSessionEvents streams session events while the session has statuses,
+// one per check, and then is revoked.
+func readSessionEvents(t *testing.T, statuses []*auth.SessionStatus) []sessionEvent {
+	var (
+		mux   sync.Mutex
+		calls int
+	)
+	statusFunc := func() (*auth.SessionStatus, error) {
+		mux.Lock()
+		defer mux.Unlock()
+		calls++
+		if calls > len(statuses) {
+			return nil, auth.ErrSessionNotFound
+		}
+		return statuses[calls-1], nil
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		streamSessionEvents(w, r, statusFunc)
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", got)
+	}
+
+	var events []sessionEvent
+	scanner := bufio.NewScanner(resp.Body)
+	var name string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			e := sessionEvent{name: name}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e.status); err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func sessionEventNames(events []sessionEvent) string {
+	var names []string
+	for _, e := range events {
+		names = append(names, e.name)
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/openshift/console/auth"
+	"github.com/openshift/console/pkg/proxy"
+)
+
+const (
+	sessionsEndpoint      = "/api/console/sessions"
+	adminSessionsEndpoint = "/api/console/admin/sessions"
+)
+
+type sessionResponse struct {
+	auth.Session `json:",inline"`
+	// Current is true for the session that made the request.
+	Current bool `json:"current"`
+}
+
+type revokeResponse struct {
+	Revoked int `json:"revoked"`
+}
+
+// sessionManager is the part of auth.Authenticator the session handlers use.
+type sessionManager interface {
+	ListSessions(userID string) ([]auth.Session, error)
+	GetSession(id string) (*auth.Session, error)
+	RevokeSession(id string) error
+	RevokeUserSessions(userID string) (int, error)
+}
+
+// pathID returns the path segment following endpoint, if any.
+func (s *Server) pathID(r *http.Request, endpoint string) string {
+	prefix := proxy.SingleJoiningSlash(s.BaseURL.Path, endpoint) + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		return ""
+	}
+	return strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+}
+
+func toSessionResponses(user *auth.User, sessions []auth.Session) []sessionResponse {
+	resp := make([]sessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		resp = append(resp, sessionResponse{
+			Session: session,
+			Current: session.ID == user.SessionID,
+		})
+	}
+	return resp
+}
+
+func sendSessionError(w http.ResponseWriter, err error) {
+	switch err {
+	case auth.ErrSessionsNotSupported:
+		sendResponse(w, http.StatusNotImplemented, apiError{err.Error()})
+	case auth.ErrSessionNotFound:
+		sendResponse(w, http.StatusNotFound, apiError{err.Error()})
+	default:
+		sendResponse(w, http.StatusInternalServerError, apiError{err.Error()})
+	}
+}
+
+// handleSessions lets users list and revoke their own sessions.
+//
+//	GET    /api/console/sessions       lists the user's sessions
+//	DELETE /api/console/sessions       revokes all of the user's sessions
+//	DELETE /api/console/sessions/<id>  revokes one of the user's sessions
+func (s *Server) handleSessions(sessions sessionManager) func(*auth.User, http.ResponseWriter, *http.Request) {
+	return func(user *auth.User, w http.ResponseWriter, r *http.Request) {
+		id := s.pathID(r, sessionsEndpoint)
+
+		switch {
+		case r.Method == "GET" && id == "":
+			list, err := sessions.ListSessions(user.ID)
+			if err != nil {
+				sendSessionError(w, err)
+				return
+			}
+			sendResponse(w, http.StatusOK, toSessionResponses(user, list))
+		case r.Method == "DELETE" && id == "":
+			revoked, err := sessions.RevokeUserSessions(user.ID)
+			if err != nil {
+				sendSessionError(w, err)
+				return
+			}
+			sendResponse(w, http.StatusOK, revokeResponse{revoked})
+		case r.Method == "DELETE":
+			session, err := sessions.GetSession(id)
+			if err == nil && session.UserID != user.ID {
+				// Don't reveal that sessions of other users exist.
+				err = auth.ErrSessionNotFound
+			}
+			if err == nil {
+				err = sessions.RevokeSession(id)
+			}
+			if err != nil {
+				sendSessionError(w, err)
+				return
+			}
+			sendResponse(w, http.StatusOK, revokeResponse{1})
+		default:
+			sendResponse(w, http.StatusMethodNotAllowed, apiError{"Invalid method: only GET and DELETE are allowed"})
+		}
+	}
+}
+
+// handleAdminSessions lets cluster admins list and revoke any session.
+//
+//	GET    /api/console/admin/sessions[?userID=<id>]  lists sessions
+//	DELETE /api/console/admin/sessions?userID=<id>    revokes all sessions of a user
+//	DELETE /api/console/admin/sessions/<id>           revokes one session
+func (s *Server) handleAdminSessions(sessions sessionManager) func(*auth.User, http.ResponseWriter, *http.Request) {
+	return func(user *auth.User, w http.ResponseWriter, r *http.Request) {
+		id := s.pathID(r, adminSessionsEndpoint)
+		userID := r.URL.Query().Get("userID")
+
+		switch {
+		case r.Method == "GET" && id == "":
+			list, err := sessions.ListSessions(userID)
+			if err != nil {
+				sendSessionError(w, err)
+				return
+			}
+			sendResponse(w, http.StatusOK, toSessionResponses(user, list))
+		case r.Method == "DELETE" && id != "":
+			if err := sessions.RevokeSession(id); err != nil {
+				sendSessionError(w, err)
+				return
+			}
+			plog.Infof("user %q revoked session %s", user.ID, id)
+			sendResponse(w, http.StatusOK, revokeResponse{1})
+		case r.Method == "DELETE" && userID != "":
+			revoked, err := sessions.RevokeUserSessions(userID)
+			if err != nil {
+				sendSessionError(w, err)
+				return
+			}
+			plog.Infof("user %q revoked %d sessions of user %q", user.ID, revoked, userID)
+			sendResponse(w, http.StatusOK, revokeResponse{revoked})
+		case r.Method == "DELETE":
+			sendResponse(w, http.StatusBadRequest, apiError{"A session ID or userID query parameter is required"})
+		default:
+			sendResponse(w, http.StatusMethodNotAllowed, apiError{"Invalid method: only GET and DELETE are allowed"})
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+
+	"github.com/openshift/console/auth"
+	"github.com/openshift/console/pkg/proxy"
+)
+
+func TestPathID(t *testing.T) {
+	s := &Server{BaseURL: &url.URL{Path: "/console/"}}
+	for path, want := range map[string]string{
+		"/console/api/console/sessions":          "",
+		"/console/api/console/sessions/":         "",
+		"/console/api/console/sessions/abc":      "abc",
+		"/console/api/console/sessions/abc/":     "abc",
+		"/console/api/console/admin/sessions/ab": "",
+	} {
+		if got := s.pathID(httptest.NewRequest("GET", path, nil), sessionsEndpoint); got != want {
+			t.Errorf("pathID(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+// fakeSessions is an in-memory sessionManager.
+type fakeSessions map[string]auth.Session
+
+func (f fakeSessions) ListSessions(userID string) ([]auth.Session, error) {
+	sessions := []auth.Session{}
+	for _, s := range f {
+		if userID == "" || s.UserID == userID {
+			sessions = append(sessions, s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+	return sessions, nil
+}
+
+func (f fakeSessions) GetSession(id string) (*auth.Session, error) {
+	s, ok := f[id]
+	if !ok {
+		return nil, auth.ErrSessionNotFound
+	}
+	return &s, nil
+}
+
+func (f fakeSessions) RevokeSession(id string) error {
+	if _, ok := f[id]; !ok {
+		return auth.ErrSessionNotFound
+	}
+	delete(f, id)
+	return nil
+}
+
+func (f fakeSessions) RevokeUserSessions(userID string) (int, error) {
+	revoked := 0
+	for id, s := range f {
+		if s.UserID == userID {
+			delete(f, id)
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+func newFakeSessions() fakeSessions {
+	return fakeSessions{
+		"a1": {ID: "a1", UserID: "alice"},
+		"a2": {ID: "a2", UserID: "alice"},
+		"b1": {ID: "b1", UserID: "bob"},
+	}
+}
+
+// newTestAccessReviewServer returns a Server whose API server allows
+// SelfSubjectAccessReviews sent with adminToken and denies all others.
+func newTestAccessReviewServer(t *testing.T, adminToken string) (*Server, func()) {
+	k8s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != selfSubjectAccessReviewPath {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": map[string]bool{"allowed": r.Header.Get("Authorization") == "Bearer "+adminToken},
+		})
+	}))
+	endpoint, err := url.Parse(k8s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Server{
+		BaseURL:        &url.URL{Path: "/"},
+		K8sProxyConfig: &proxy.Config{Endpoint: endpoint},
+		K8sClient:      http.DefaultClient,
+	}, k8s.Close
+}
+
+func sessionsRequest(hf func(*auth.User, http.ResponseWriter, *http.Request), user *auth.User, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	hf(user, w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func TestHandleSessions(t *testing.T) {
+	s := &Server{BaseURL: &url.URL{Path: "/"}}
+	sessions := newFakeSessions()
+	alice := &auth.User{ID: "alice", SessionID: "a1"}
+
+	w := sessionsRequest(s.handleSessions(sessions), alice, "GET", sessionsEndpoint)
+	var list []sessionResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].ID != "a1" || !list[0].Current || list[1].ID != "a2" || list[1].Current {
+		t.Errorf("sessions = %+v, want alice's two sessions with a1 current", list)
+	}
+
+	if w := sessionsRequest(s.handleSessions(sessions), alice, "DELETE", sessionsEndpoint+"/b1"); w.Code != http.StatusNotFound {
+		t.Errorf("revoking another user's session: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if _, ok := sessions["b1"]; !ok {
+		t.Error("another user's session was revoked")
+	}
+	if w := sessionsRequest(s.handleSessions(sessions), alice, "DELETE", sessionsEndpoint+"/a2"); w.Code != http.StatusOK {
+		t.Errorf("revoking own session: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := sessionsRequest(s.handleSessions(sessions), alice, "DELETE", sessionsEndpoint); w.Code != http.StatusOK {
+		t.Errorf("revoking all own sessions: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if len(sessions) != 1 {
+		t.Errorf("sessions left = %v, want only bob's", sessions)
+	}
+}
+
+func TestHandleAdminSessions(t *testing.T) {
+	s, closer := newTestAccessReviewServer(t, "admin-token")
+	defer closer()
+	sessions := newFakeSessions()
+	hf := s.clusterAdminHandler(s.handleAdminSessions(sessions))
+	admin := &auth.User{ID: "admin", Token: "admin-token"}
+	alice := &auth.User{ID: "alice", Token: "alice-token"}
+
+	for _, tc := range []struct{ method, path string }{
+		{"GET", adminSessionsEndpoint},
+		{"DELETE", adminSessionsEndpoint + "/b1"},
+		{"DELETE", adminSessionsEndpoint + "?userID=bob"},
+	} {
+		if w := sessionsRequest(hf, alice, tc.method, tc.path); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s by a non-admin: status = %d, want %d", tc.method, tc.path, w.Code, http.StatusForbidden)
+		}
+	}
+	if len(sessions) != 3 {
+		t.Fatalf("a non-admin revoked sessions: %v", sessions)
+	}
+
+	w := sessionsRequest(hf, admin, "GET", adminSessionsEndpoint+"?userID=alice")
+	var list []sessionResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Errorf("alice's sessions = %+v, want 2", list)
+	}
+	if w := sessionsRequest(hf, admin, "DELETE", adminSessionsEndpoint+"/b1"); w.Code != http.StatusOK {
+		t.Errorf("revoking a session: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := sessionsRequest(hf, admin, "DELETE", adminSessionsEndpoint); w.Code != http.StatusBadRequest {
+		t.Errorf("revoking without a session or user: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := sessionsRequest(hf, admin, "DELETE", adminSessionsEndpoint+"?userID=alice"); w.Code != http.StatusOK {
+		t.Errorf("revoking a user's sessions: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if len(sessions) != 0 {
+		t.Errorf("sessions left = %v, want none", sessions)
+	}
+}
+
+func TestClusterAdminHandlerAccessReviewFailure(t *testing.T) {
+	s, closer := newTestAccessReviewServer(t, "admin-token")
+	closer()
+	called := false
+	hf := s.clusterAdminHandler(func(*auth.User, http.ResponseWriter, *http.Request) { called = true })
+	if w := sessionsRequest(hf, &auth.User{Token: "admin-token"}, "GET", adminSessionsEndpoint); w.Code != http.StatusBadGateway || called {
+		t.Errorf("unreachable API server: status = %d, handler called = %v, want %d and not called", w.Code, called, http.StatusBadGateway)
+	}
+}